@@ -0,0 +1,521 @@
+package shipmate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Every /api/v1 error is replied with this envelope, e.g. {"error":{"code":"not_found","message":"no pickup for 4105551234"}}
+type apiErrorEnvelope struct {
+	Error *apiError `json:"error"`
+}
+
+//Reply to an async /api/v1 request, with a Location header pointing at the request's status
+type apiAsyncReply struct {
+	RequestId string `json:"requestId"`
+}
+
+//A van and its latest location in /api/v1 replies
+type apiVanLocation struct {
+	VanId int `json:"vanId"`
+	Location
+}
+
+//Location fields of a request body. Heading is optional and -1 if it is left out, riders usually leave it out.
+type apiLocationBody struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Heading   *float64 `json:"heading"`
+}
+
+//Body of PATCH /api/v1/pickups/{phoneNumber}. Drivers send a status, riders a location.
+type apiPickupChange struct {
+	Status *string `json:"status"`
+	apiLocationBody
+}
+
+//Body of PUT /api/v1/pickups/{phoneNumber}/van. Drivers may leave out vanId to claim the pickup for their own van.
+type apiAssignmentBody struct {
+	VanId    *int `json:"vanId"`
+	DriverId int  `json:"driverId,omitempty"`
+}
+
+//Statuses drivers may set with PATCH /api/v1/pickups/{phoneNumber} and the permission each needs. Pickups are canceled with DELETE.
+var apiStatusPermissions = map[PickupStatus]permission{
+	confirmed: confirmPickupPermission,
+	enRoute:   progressPickupPermission,
+	arrived:   progressPickupPermission,
+	noShow:    progressPickupPermission,
+	completed: completePickupPermission,
+}
+
+type pathValuesKey struct{}
+
+//Route every /api/v1 request through apiRouter
+func (s *Server) registerAPIRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/", s.apiRouter(s.apiEndpoints()))
+}
+
+//Dispatch /api/v1 requests to the endpoint matching their method and path. Segments of a pattern in braces match any single path segment and are read with pathValue. Paths with no endpoint get 404, paths with endpoints for other methods 405.
+func (s *Server) apiRouter(endpoints []endpoint) http.HandlerFunc {
+	handlers := make([]http.HandlerFunc, len(endpoints))
+	for i, v := range endpoints {
+		if v.handler == nil {
+			handlers[i] = v.public
+		} else {
+			handlers[i] = s.apiAuthorize(v.handler, v.permissions...)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		//bypass same origin policy
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		var allowed []string
+		for i, v := range endpoints {
+			values, match := matchAPIPath(v.pattern, r.URL.Path)
+			if !match {
+				continue
+			}
+			if v.method == r.Method {
+				handlers[i](w, r.WithContext(context.WithValue(r.Context(), pathValuesKey{}, values)))
+				return
+			}
+			allowed = append(allowed, v.method)
+		}
+
+		switch {
+		case len(allowed) == 0:
+			s.writeAPIError(w, apiErrorf(http.StatusNotFound, notFoundCode, "no route %v %v", r.Method, r.URL.Path))
+		case r.Method == "OPTIONS":
+			//answer CORS preflight so browsers may send the Authorization header and JSON bodies
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			s.writeAPIError(w, apiErrorf(http.StatusMethodNotAllowed, methodNotAllowedCode, "%v is not allowed on %v", r.Method, r.URL.Path))
+		}
+	}
+}
+
+//Match a path against a route pattern and return the values of its brace segments
+func matchAPIPath(pattern string, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	values := make(map[string]string)
+	for i, v := range patternSegments {
+		if strings.HasPrefix(v, "{") && strings.HasSuffix(v, "}") && pathSegments[i] != "" {
+			values[v[1:len(v)-1]] = pathSegments[i]
+		} else if v != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+//Value of a brace segment of the route the request matched
+func pathValue(r *http.Request, name string) string {
+	values, _ := r.Context().Value(pathValuesKey{}).(map[string]string)
+	return values[name]
+}
+
+//Reply to an /api/v1 request with status and value as JSON
+func (s *Server) writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	output, err := json.Marshal(value)
+	if err != nil {
+		s.logger.Println(err)
+		status = http.StatusInternalServerError
+		output, _ = json.Marshal(apiErrorEnvelope{apiErrorf(status, internalErrorCode, "reply could not be encoded")})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(output)
+}
+
+//Reply to an /api/v1 request with the error envelope. Errors that are not apiErrors are reported as internal errors.
+func (s *Server) writeAPIError(w http.ResponseWriter, err error) {
+	s.logger.Println(err)
+
+	e, ok := err.(*apiError)
+	if !ok {
+		e = apiErrorf(http.StatusInternalServerError, internalErrorCode, "request failed")
+	}
+	s.writeJSON(w, e.status, apiErrorEnvelope{e})
+}
+
+//Reply 202 Accepted to an async request
+func (s *Server) writeAsyncReply(w http.ResponseWriter, requestId string) {
+	w.Header().Set("Location", "/api/v1/requests/"+requestId)
+	s.writeJSON(w, http.StatusAccepted, apiAsyncReply{requestId})
+}
+
+//Decode a JSON request body into target. Unknown fields are rejected so misspelled fields are not silently ignored.
+func decodeJSONBody(r *http.Request, target interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		if err == io.EOF {
+			return apiErrorf(http.StatusBadRequest, invalidBodyCode, "request body is empty")
+		}
+		return apiErrorf(http.StatusBadRequest, invalidBodyCode, "request body is not valid JSON: %v", err)
+	}
+	return nil
+}
+
+//Location in a request body. Latitude and longitude are required, heading is -1 if it is left out.
+func (b apiLocationBody) location() (Location, error) {
+	var v validator
+	location := Location{Heading: -1}
+
+	if b.Latitude == nil {
+		v.addError("latitude", "is required")
+	} else {
+		location.Latitude = v.checkLatitude("latitude", *b.Latitude)
+	}
+	if b.Longitude == nil {
+		v.addError("longitude", "is required")
+	} else {
+		location.Longitude = v.checkLongitude("longitude", *b.Longitude)
+	}
+	if b.Heading != nil {
+		location.Heading = v.checkHeading("heading", *b.Heading)
+	}
+	return location, v.err()
+}
+
+//Phone number in the request path
+func pathPhoneNumber(r *http.Request) (string, error) {
+	var v validator
+	number := v.phoneNumber("phoneNumber", pathValue(r, "phoneNumber"))
+	return number, v.err()
+}
+
+//Van id in the request path
+func pathVanId(r *http.Request) (int, error) {
+	var v validator
+	vanId := v.vanId("vanId", pathValue(r, "vanId"))
+	return vanId, v.err()
+}
+
+//Pickup with its van, ETA and queue position as it is in memory now
+func (s *Server) apiPickupInfo(targetPickup Pickup) pickupInfo {
+	s.pickupsLock.RLock()
+	defer s.pickupsLock.RUnlock()
+
+	return s.pickupInfoFor(targetPickup)
+}
+
+//Resolve the caller's session and only run the handler if their role holds at least one of the permissions. Missing or revoked sessions are replied to with 401, other roles with 403.
+func (s *Server) apiAuthorize(handler sessionHandlerFunc, permissions ...permission) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := s.sessionFromRequest(r)
+		if err != nil {
+			s.writeAPIError(w, err)
+			return
+		}
+
+		for _, v := range permissions {
+			if hasPermission(session.Role, v) {
+				handler(w, r, session)
+				return
+			}
+		}
+		s.writeAPIError(w, apiErrorf(http.StatusForbidden, forbiddenCode, "role %v may not %v %v", session.Role, r.Method, r.URL.Path))
+	}
+}
+
+//POST /api/v1/pickups {"latitude":38.98,"longitude":-76.48} requests a pickup for the rider's phone number
+func (s *Server) apiCreatePickup(w http.ResponseWriter, r *http.Request, session Session) {
+	var body apiLocationBody
+	if err := decodeJSONBody(r, &body); err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+	location, err := body.location()
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	tmp, requestId, err := s.createPickup(session, location, isAsyncRequest(r.URL.Query()))
+	if err != nil {
+		s.writeAPIError(w, err)
+	} else if requestId != "" {
+		s.writeAsyncReply(w, requestId)
+	} else {
+		w.Header().Set("Location", "/api/v1/pickups/"+tmp.PhoneNumber)
+		s.writeJSON(w, http.StatusCreated, s.apiPickupInfo(tmp))
+	}
+}
+
+//GET /api/v1/pickups lists every pickup in memory, oldest first
+func (s *Server) apiListPickups(w http.ResponseWriter, r *http.Request, session Session) {
+	s.pickupsLock.RLock()
+	list := make([]Pickup, 0, len(s.pickups))
+	for _, v := range s.pickups {
+		list = append(list, v)
+	}
+	s.pickupsLock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].InitialTime.Before(list[j].InitialTime)
+	})
+	s.writeJSON(w, http.StatusOK, list)
+}
+
+//GET /api/v1/pickups/{phoneNumber}
+func (s *Server) apiGetPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	number, err := pathPhoneNumber(r)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	if info, err := s.viewPickup(session, number); err != nil {
+		s.writeAPIError(w, err)
+	} else {
+		s.writeJSON(w, http.StatusOK, info)
+	}
+}
+
+//PATCH /api/v1/pickups/{phoneNumber} with {"status":"confirmed"} from drivers, or {"latitude":38.98,"longitude":-76.48} from the rider
+func (s *Server) apiUpdatePickup(w http.ResponseWriter, r *http.Request, session Session) {
+	number, err := pathPhoneNumber(r)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	var body apiPickupChange
+	if err := decodeJSONBody(r, &body); err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	switch {
+	case body.Status != nil && (body.Latitude != nil || body.Longitude != nil):
+		s.writeAPIError(w, apiErrorf(http.StatusBadRequest, invalidParameterCode, "status and location are changed separately"))
+
+	case body.Status != nil:
+		to, exist := parsePickupStatus(*body.Status)
+		required, allowed := apiStatusPermissions[to]
+		if !exist || !allowed {
+			s.writeAPIError(w, apiErrorf(http.StatusBadRequest, invalidParameterCode, "status %q cannot be set, cancel pickups with DELETE", *body.Status))
+			return
+		}
+		if !hasPermission(session.Role, required) {
+			s.writeAPIError(w, apiErrorf(http.StatusForbidden, forbiddenCode, "role %v may not set status %v", session.Role, to))
+			return
+		}
+
+		tmp, requestId, err := s.setPickupStatus(session, number, to, isAsyncRequest(r.URL.Query()))
+		if err != nil {
+			s.writeAPIError(w, err)
+		} else if requestId != "" {
+			s.writeAsyncReply(w, requestId)
+		} else {
+			s.writeJSON(w, http.StatusOK, s.apiPickupInfo(tmp))
+		}
+
+	default:
+		location, err := body.location()
+		if err != nil {
+			s.writeAPIError(w, err)
+			return
+		}
+
+		if !hasPermission(session.Role, locateOwnPickupPermission) || number != session.PhoneNumber {
+			s.writeAPIError(w, apiErrorf(http.StatusForbidden, forbiddenCode, "only the rider may report the location of pickup %v", number))
+			return
+		}
+		if err := s.recordRiderLocation(session, location); err != nil {
+			s.writeAPIError(w, err)
+			return
+		}
+
+		if info, err := s.viewPickup(session, number); err != nil {
+			s.writeAPIError(w, err)
+		} else {
+			s.writeJSON(w, http.StatusOK, info)
+		}
+	}
+}
+
+//DELETE /api/v1/pickups/{phoneNumber} cancels the pickup and replies with it as it was moved to past pickups
+func (s *Server) apiCancelPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	number, err := pathPhoneNumber(r)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	tmp, requestId, err := s.cancelPickupFor(session, number, isAsyncRequest(r.URL.Query()))
+	if err != nil {
+		s.writeAPIError(w, err)
+	} else if requestId != "" {
+		s.writeAsyncReply(w, requestId)
+	} else {
+		s.writeJSON(w, http.StatusOK, tmp)
+	}
+}
+
+//GET /api/v1/pickups/{phoneNumber}/events, staff may pass ?initialTime= (RFC 3339) for a past pickup
+func (s *Server) apiGetPickupEvents(w http.ResponseWriter, r *http.Request, session Session) {
+	var v validator
+	number := v.phoneNumber("phoneNumber", pathValue(r, "phoneNumber"))
+	var initialTime time.Time
+	if value := r.URL.Query().Get("initialTime"); value != "" {
+		initialTime = v.time("initialTime", value)
+	}
+	if err := v.err(); err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	if events, err := s.pickupEventsFor(session, number, initialTime); err != nil {
+		s.writeAPIError(w, err)
+	} else {
+		s.writeJSON(w, http.StatusOK, events)
+	}
+}
+
+//PUT /api/v1/pickups/{phoneNumber}/van with {"vanId":2,"driverId":7}. Dispatchers move the pickup to any van, drivers claim it for their own van.
+func (s *Server) apiAssignPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	number, err := pathPhoneNumber(r)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	var body apiAssignmentBody
+	if err := decodeJSONBody(r, &body); err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	var v validator
+	if body.VanId != nil {
+		v.checkVanId("vanId", *body.VanId)
+	}
+	if body.DriverId < 0 {
+		v.addError("driverId", "must be a whole number of at least 1")
+	}
+	if hasPermission(session.Role, reassignPickupPermission) && body.VanId == nil {
+		v.addError("vanId", "is required")
+	}
+	if err := v.err(); err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	var tmp Pickup
+	if hasPermission(session.Role, reassignPickupPermission) {
+		tmp, err = s.reassignPickupFor(session, number, *body.VanId, body.DriverId)
+	} else {
+		if body.VanId != nil && *body.VanId != session.VanId {
+			s.writeAPIError(w, apiErrorf(http.StatusForbidden, vanNotAssignedCode, "driver %v is not assigned to van %v", session.DriverId, *body.VanId))
+			return
+		}
+		tmp, err = s.claimPickupFor(session, number)
+	}
+
+	if err != nil {
+		s.writeAPIError(w, err)
+	} else {
+		s.writeJSON(w, http.StatusOK, s.apiPickupInfo(tmp))
+	}
+}
+
+//DELETE /api/v1/pickups/{phoneNumber}/van releases the pickup from its van. Drivers declining a pickup are not offered it again.
+func (s *Server) apiUnassignPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	number, err := pathPhoneNumber(r)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	if tmp, err := s.unassignPickupFor(session, number); err != nil {
+		s.writeAPIError(w, err)
+	} else {
+		s.writeJSON(w, http.StatusOK, s.apiPickupInfo(tmp))
+	}
+}
+
+//GET /api/v1/vans lists the vans in service that have reported recently, lowest id first
+func (s *Server) apiListVans(w http.ResponseWriter, r *http.Request) {
+	locations := s.activeVanLocations()
+	list := make([]apiVanLocation, 0)
+	for _, v := range s.vans.list() {
+		if tmp, exist := locations[v.Id]; exist {
+			list = append(list, apiVanLocation{v.Id, tmp})
+		}
+	}
+	s.writeJSON(w, http.StatusOK, list)
+}
+
+//PUT /api/v1/vans/{vanId}/location with {"latitude":38.98,"longitude":-76.48,"heading":90}
+func (s *Server) apiUpdateVanLocation(w http.ResponseWriter, r *http.Request, session Session) {
+	vanId, err := pathVanId(r)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	var body apiLocationBody
+	if err := decodeJSONBody(r, &body); err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+	location, err := body.location()
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+	if location, err = s.reportVanLocation(session, vanId, location); err != nil {
+		s.writeAPIError(w, err)
+	} else {
+		s.writeJSON(w, http.StatusOK, apiVanLocation{vanId, location})
+	}
+}
+
+//GET /api/v1/vans/{vanId}/route
+func (s *Server) apiGetVanRoute(w http.ResponseWriter, r *http.Request, session Session) {
+	vanId, err := pathVanId(r)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	if route, err := s.vanRouteFor(session, vanId); err != nil {
+		s.writeAPIError(w, err)
+	} else {
+		s.writeJSON(w, http.StatusOK, route)
+	}
+}
+
+//GET /api/v1/requests/{requestId} replies with the outcome of an async request
+func (s *Server) apiGetRequestStatus(w http.ResponseWriter, r *http.Request, session Session) {
+	var v validator
+	requestId := v.requestId("requestId", pathValue(r, "requestId"))
+	if err := v.err(); err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+
+	if tmp, err := s.requestStatusFor(session, requestId); err != nil {
+		s.writeAPIError(w, err)
+	} else {
+		s.writeJSON(w, http.StatusOK, tmp)
+	}
+}
@@ -0,0 +1,171 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+//Decode the error envelope of an /api/v1 reply, empty if the reply is not an error
+func decodeAPIErrorCode(body string) string {
+	var envelope struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal([]byte(body), &envelope)
+	return envelope.Error.Code
+}
+
+func TestAPIErrors(t *testing.T) {
+	location := map[string]float64{"latitude": 38.98, "longitude": -76.48}
+
+	tests := []struct {
+		name       string
+		setup      func(ts *testServer) string //returns the token to request with
+		method     string
+		path       string
+		body       interface{}
+		wantStatus int
+		wantCode   string //empty if the request should succeed
+	}{
+		{"rider creates pickup", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, "POST", "/api/v1/pickups", location, http.StatusCreated, ""},
+		{"no token", func(ts *testServer) string {
+			return ""
+		}, "POST", "/api/v1/pickups", location, http.StatusUnauthorized, unauthorizedCode},
+		{"expired token", func(ts *testServer) string {
+			token := ts.riderToken(testRider, testDevice)
+			ts.clock.Advance(accessTokenLifetime + time.Second)
+			return token
+		}, "POST", "/api/v1/pickups", location, http.StatusUnauthorized, unauthorizedCode},
+		{"drivers may not create pickups", func(ts *testServer) string {
+			return ts.driverToken(1, 1)
+		}, "POST", "/api/v1/pickups", location, http.StatusForbidden, forbiddenCode},
+		{"phone number not verified", func(ts *testServer) string {
+			return ts.token(Session{Role: riderRole, PhoneNumber: testRider, DeviceId: testDevice})
+		}, "POST", "/api/v1/pickups", location, http.StatusForbidden, phoneNotVerifiedCode},
+		{"missing longitude", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, "POST", "/api/v1/pickups", map[string]float64{"latitude": 38.98}, http.StatusBadRequest, invalidParameterCode},
+		{"unknown field", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, "POST", "/api/v1/pickups", map[string]float64{"lat": 38.98, "longitude": -76.48}, http.StatusBadRequest, invalidBodyCode},
+		{"empty body", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, "POST", "/api/v1/pickups", nil, http.StatusBadRequest, invalidBodyCode},
+		{"pickup already pending", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testDevice)
+		}, "POST", "/api/v1/pickups", location, http.StatusConflict, pickupExistsCode},
+		{"no pickup for phone number", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, "GET", "/api/v1/pickups/" + testRider, nil, http.StatusNotFound, notFoundCode},
+		{"rider views another rider's pickup", func(ts *testServer) string {
+			ts.newPickup(testOtherRider, testOtherDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testDevice)
+		}, "GET", "/api/v1/pickups/" + testOtherRider, nil, http.StatusForbidden, forbiddenCode},
+		{"driver confirms pickup", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(3, 2)
+		}, "PATCH", "/api/v1/pickups/" + testRider, map[string]string{"status": "confirmed"}, http.StatusOK, ""},
+		{"status cannot be canceled with PATCH", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(3, 2)
+		}, "PATCH", "/api/v1/pickups/" + testRider, map[string]string{"status": "canceled"}, http.StatusBadRequest, invalidParameterCode},
+		{"pickup cannot skip to completed", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(3, 2)
+		}, "PATCH", "/api/v1/pickups/" + testRider, map[string]string{"status": "completed"}, http.StatusConflict, invalidTransitionCode},
+		{"pickup held by another van", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			ts.apiRequest(ts.driverToken(4, 1), "PUT", "/api/v1/pickups/"+testRider+"/van", map[string]int{})
+			return ts.driverToken(3, 2)
+		}, "PATCH", "/api/v1/pickups/" + testRider, map[string]string{"status": "confirmed"}, http.StatusConflict, pickupHeldCode},
+		{"driver claims for another van", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(3, 2)
+		}, "PUT", "/api/v1/pickups/" + testRider + "/van", map[string]int{"vanId": 1}, http.StatusForbidden, vanNotAssignedCode},
+		{"dispatcher reassigns without van", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.staffToken(dispatcherRole, 9)
+		}, "PUT", "/api/v1/pickups/" + testRider + "/van", map[string]int{"driverId": 3}, http.StatusBadRequest, invalidParameterCode},
+		{"driver reports another van", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, "PUT", "/api/v1/vans/1/location", location, http.StatusForbidden, vanNotAssignedCode},
+		{"van id not a number", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, "PUT", "/api/v1/vans/two/location", location, http.StatusBadRequest, invalidParameterCode},
+		{"unknown route", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, "GET", "/api/v1/shuttles", nil, http.StatusNotFound, notFoundCode},
+		{"unknown request id", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, "GET", "/api/v1/requests/0123456789abcdef0123456789abcdef", nil, http.StatusNotFound, notFoundCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := tt.setup(ts)
+
+			status, body := ts.apiRequest(token, tt.method, tt.path, tt.body)
+			if status != tt.wantStatus || decodeAPIErrorCode(body) != tt.wantCode {
+				t.Fatalf("got %v %v, want %v %q", status, body, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAPIPickupLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+	rider := ts.riderToken(testRider, testDevice)
+	driver := ts.driverToken(3, 2)
+
+	if status, body := ts.apiRequest(driver, "PUT", "/api/v1/vans/2/location", map[string]float64{"latitude": 38.97, "longitude": -76.47, "heading": 90}); status != http.StatusOK {
+		t.Fatalf("van location replied %v %v", status, body)
+	}
+	if status, body := ts.apiRequest(rider, "POST", "/api/v1/pickups", map[string]float64{"latitude": 38.98, "longitude": -76.48}); status != http.StatusCreated {
+		t.Fatalf("create replied %v %v", status, body)
+	}
+	if tmp, _ := ts.memoryPickup(testRider); tmp.InitialLocation.Heading != -1 {
+		t.Errorf("rider location has heading %v, want -1 for unknown", tmp.InitialLocation.Heading)
+	}
+
+	for _, v := range []string{"confirmed", "enRoute", "arrived", "completed"} {
+		status, body := ts.apiRequest(driver, "PATCH", "/api/v1/pickups/"+testRider, map[string]string{"status": v})
+		var info pickupInfo
+		if status != http.StatusOK || json.Unmarshal([]byte(body), &info) != nil || info.Status.String() != v {
+			t.Fatalf("PATCH status %v replied %v %v", v, status, body)
+		}
+	}
+
+	status, body := ts.apiRequest(rider, "GET", "/api/v1/pickups/"+testRider+"/events", nil)
+	var events []PickupEvent
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &events) != nil {
+		t.Fatalf("events replied %v %v", status, body)
+	}
+
+	//the legacy routes see the same pickup
+	if tmp, ok := decodePickup(ts.request(rider, "/getPickupInfo", url.Values{})); !ok || tmp.Status != completed {
+		t.Errorf("legacy getPickupInfo replied %+v, want the completed pickup", tmp)
+	}
+}
+
+func TestAPIAsyncRequest(t *testing.T) {
+	ts := newTestServer(t)
+	rider := ts.riderToken(testRider, testDevice)
+
+	status, body := ts.apiRequest(rider, "POST", "/api/v1/pickups?async=true", map[string]float64{"latitude": 38.98, "longitude": -76.48})
+	var reply apiAsyncReply
+	if status != http.StatusAccepted || json.Unmarshal([]byte(body), &reply) != nil || reply.RequestId == "" {
+		t.Fatalf("async create replied %v %v", status, body)
+	}
+
+	if status, body := ts.apiRequest(rider, "GET", "/api/v1/requests/"+reply.RequestId, nil); status != http.StatusOK {
+		t.Fatalf("request status replied %v %v", status, body)
+	}
+}
@@ -0,0 +1,81 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+//Machine readable error codes sent in the /api/v1 error envelope
+const (
+	invalidBodyCode       = "invalid_body"        //request body is not the expected JSON
+	invalidParameterCode  = "invalid_parameter"   //a field is missing or has a bad value
+	unauthorizedCode      = "unauthorized"        //missing, expired or revoked access token
+	forbiddenCode         = "forbidden"           //the session's role may not do this
+	deviceMismatchCode    = "device_mismatch"     //the pickup was requested from another device
+	phoneNotVerifiedCode  = "phone_not_verified"  //the phone number has not been verified on the device
+	vanNotAssignedCode    = "van_not_assigned"    //the driver is not assigned to the van
+	notFoundCode          = "not_found"           //no such pickup, van, route or request
+	methodNotAllowedCode  = "method_not_allowed"  //the route exists for other methods
+	unknownVanCode        = "unknown_van"         //no van is registered with the id
+	vanInactiveCode       = "van_inactive"        //the van is registered but not in service
+	vanExistsCode         = "van_exists"          //a van is already registered with the id
+	pickupExistsCode      = "pickup_exists"       //the phone number has an active pickup on another device
+	pickupHeldCode        = "pickup_held"         //another van holds the pickup
+	invalidTransitionCode = "invalid_transition"  //the pickup cannot move to the requested status
+	stalePickupCode       = "stale_pickup"        //another request or instance changed the pickup first
+	storeUnavailableCode  = "store_unavailable"   //the store could not be read or written
+	internalErrorCode     = "internal_error"      //anything else, details are in the server log
+)
+
+//Why a request failed. /api/v1 replies with the HTTP status and the error envelope, legacy routes with the canned response the code has always had.
+type apiError struct {
+	status  int
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields,omitempty"` //each invalid parameter of an invalid_parameter error
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func apiErrorf(status int, code string, format string, args ...interface{}) *apiError {
+	return &apiError{status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+//Error for a failed store write. Version conflicts are the caller's to retry, anything else means the store is unavailable.
+func storeError(err error) *apiError {
+	if err == errStalePickup {
+		return apiErrorf(http.StatusConflict, stalePickupCode, "%v", err)
+	}
+	return apiErrorf(http.StatusServiceUnavailable, storeUnavailableCode, "%v", err)
+}
+
+//Error for a status change transitionPickup refused
+func transitionError(err error) *apiError {
+	return apiErrorf(http.StatusConflict, invalidTransitionCode, "%v", err)
+}
+
+//True if err is an apiError with code
+func hasErrorCode(err error, code string) bool {
+	e, ok := err.(*apiError)
+	return ok && e.Code == code
+}
+
+//Canned response legacy routes reply with for err. Old apps treat wrongPasswordResponse as "sign in again", so only errors about who is asking use it. Invalid parameters are listed in "fields" next to the failure status old apps read.
+func legacyResponse(err error) string {
+	if e, ok := err.(*apiError); ok {
+		switch e.Code {
+		case unauthorizedCode, forbiddenCode, deviceMismatchCode, phoneNotVerifiedCode:
+			return wrongPasswordResponse
+		}
+
+		if len(e.Fields) > 0 {
+			if output, err := json.Marshal(statusResponse{Status: "-1", Fields: e.Fields}); err == nil {
+				return string(output)
+			}
+		}
+	}
+	return failResponse
+}
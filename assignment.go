@@ -0,0 +1,235 @@
+package shipmate
+
+import (
+	"fmt"
+	"net/http"
+)
+
+//Pickup as returned by getPickupInfo, with the live location of the van assigned to it and when it should arrive
+type pickupInfo struct {
+	Pickup
+	VanLocation   *Location  `json:"vanLocation,omitempty"`
+	ETA           *pickupETA `json:"eta,omitempty"`
+	QueuePosition int        `json:"queuePosition,omitempty"` //position among pickups waiting for a van
+}
+
+//Latest reported location of a van, or nil if the van has not reported recently
+func (s *Server) currentVanLocation(vanId int) *Location {
+	tmp, exist := s.vanLocations.get(vanId)
+	if !exist {
+		return nil
+	}
+	return &tmp
+}
+
+//Caller must hold pickupsLock
+func (s *Server) pickupInfoFor(targetPickup Pickup) pickupInfo {
+	return pickupInfo{targetPickup, s.currentVanLocation(targetPickup.VanId), s.estimatePickupArrival(targetPickup), s.pickupQueuePosition(targetPickup)}
+}
+
+//Write a pickup's new van, driver and suggested van to the store and memory, and record it in the timeline. Returns false if another instance changed the pickup first. Caller must hold pickupsLock.
+func (s *Server) commitPickupAssignment(tmp Pickup, eventType string, eventVanId int, actor string) bool {
+	if err := s.pickupStore.UpdatePickup(tmp); err != nil {
+		//another instance changed the pickup first, most likely another van claimed it
+		s.logger.Println(err)
+		s.loadPickupIntoMemory(tmp.PhoneNumber)
+		return false
+	}
+
+	//increment pickup counter in tmp struct
+	tmp.version = tmp.version+1
+
+	//commit changes to instance memory
+	s.pickups[tmp.PhoneNumber] = tmp
+	s.databaseInsertPickupVanEvent(tmp, eventType, eventVanId, actor, s.clock())
+	s.logger.Printf("Pickup %v %v van %v by %v\n", tmp.PhoneNumber, eventType, eventVanId, actor)
+	return true
+}
+
+//Give a pickup to a van and driver on behalf of a staff member. Any dispatch suggestion is dropped. Caller must hold pickupsLock.
+func (s *Server) assignPickup(session Session, tmp Pickup, vanId int, driverId int) (Pickup, error) {
+	tmp.VanId = vanId
+	tmp.DriverId = driverId
+	tmp.SuggestedVanId = 0
+
+	if !s.commitPickupAssignment(tmp, assignmentEvent, vanId, sessionActor(session)) {
+		return Pickup{}, apiErrorf(http.StatusConflict, stalePickupCode, "pickup %v changed before it was assigned", tmp.PhoneNumber)
+	}
+	return s.pickups[tmp.PhoneNumber], nil
+}
+
+//Look up the active pickup for a phone number. Caller must hold pickupsLock.
+func (s *Server) activePickup(number string) (Pickup, error) {
+	tmp, exist := s.pickups[number]
+	if !exist || !tmp.Status.isActive() {
+		return Pickup{}, apiErrorf(http.StatusNotFound, notFoundCode, "no active pickup for %v", number)
+	}
+	return tmp, nil
+}
+
+//Reply to a legacy assignment request with the outcome of an assignment
+func (s *Server) writeAssignmentResponse(w http.ResponseWriter, err error) {
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+	fmt.Fprint(w, successResponse)
+}
+
+//Driver takes a pickup for their van. Fails if another van already holds it.
+func (s *Server) claimPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("claimPickup()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	number := v.formPhoneNumber(r.Form)
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	_, err := s.claimPickupFor(session, number)
+	s.writeAssignmentResponse(w, err)
+}
+
+//Give the pickup for a phone number to the driver's van
+func (s *Server) claimPickupFor(session Session, number string) (Pickup, error) {
+	s.pickupsLock.Lock()
+	defer s.pickupsLock.Unlock()
+
+	if session.VanId == 0 {
+		return Pickup{}, apiErrorf(http.StatusForbidden, vanNotAssignedCode, "driver %v has no van to claim pickups with", session.DriverId)
+	}
+
+	tmp, err := s.activePickup(number)
+	if err != nil {
+		return Pickup{}, err
+	}
+
+	if tmp.VanId != 0 && tmp.VanId != session.VanId {
+		return Pickup{}, apiErrorf(http.StatusConflict, pickupHeldCode, "pickup %v already held by van %v", tmp.PhoneNumber, tmp.VanId)
+	}
+
+	return s.assignPickup(session, tmp, session.VanId, session.DriverId)
+}
+
+//Release a pickup from its van and dispatch it again. The holding or suggested driver may decline the pickup, which keeps dispatch from offering it to their van again. Dispatchers may release any pickup.
+func (s *Server) unassignPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("unassignPickup()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	number := v.formPhoneNumber(r.Form)
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	_, err := s.unassignPickupFor(session, number)
+	s.writeAssignmentResponse(w, err)
+}
+
+//Release the pickup for a phone number from its van, returning it to pending, and dispatch it again
+func (s *Server) unassignPickupFor(session Session, number string) (Pickup, error) {
+	//look up vans that declined the pickup before taking pickupsLock so the database is not queried while holding it
+	s.pickupsLock.RLock()
+	previous := s.pickups[number]
+	s.pickupsLock.RUnlock()
+	declinedVanIds := s.selectDeclinedVanIds(previous)
+
+	s.pickupsLock.Lock()
+	defer s.pickupsLock.Unlock()
+
+	tmp, err := s.activePickup(number)
+	if err != nil {
+		return Pickup{}, err
+	}
+
+	declining := !hasPermission(session.Role, reassignPickupPermission)
+	if declining && (session.VanId == 0 || (tmp.VanId != session.VanId && tmp.SuggestedVanId != session.VanId)) {
+		return Pickup{}, apiErrorf(http.StatusConflict, pickupHeldCode, "van %v does not hold pickup %v", session.VanId, tmp.PhoneNumber)
+	}
+
+	//a released pickup waits for a new van again
+	if tmp.Status != pending {
+		if err := s.transitionPickup(&tmp, pending, sessionActor(session), s.clock()); err != nil {
+			return Pickup{}, transitionError(err)
+		}
+	}
+
+	if _, err := s.assignPickup(session, tmp, 0, 0); err != nil {
+		return Pickup{}, err
+	}
+
+	if !tmp.InitialTime.Equal(previous.InitialTime) {
+		declinedVanIds = make(map[int]bool)
+	}
+	if declining {
+		s.databaseInsertPickupVanEvent(tmp, declineEvent, session.VanId, sessionActor(session), s.clock())
+		declinedVanIds[session.VanId] = true
+	}
+	s.dispatchPickup(s.pickups[tmp.PhoneNumber], declinedVanIds)
+	return s.pickups[tmp.PhoneNumber], nil
+}
+
+//Dispatcher moves a pickup to the van in "vanId", optionally naming the driver in "driverId"
+func (s *Server) reassignPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("reassignPickup()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	number := v.formPhoneNumber(r.Form)
+	var vanId, driverId int
+	if value, exist := v.required(r.Form, "vanId"); exist {
+		vanId = v.vanId("vanId", value)
+	}
+	if value, exist := formValue(r.Form, "driverId"); exist {
+		driverId = v.id("driverId", value)
+	}
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	_, err := s.reassignPickupFor(session, number, vanId, driverId)
+	s.writeAssignmentResponse(w, err)
+}
+
+//Move the pickup for a phone number to a van, and to a driver if driverId is not 0
+func (s *Server) reassignPickupFor(session Session, number string, vanId int, driverId int) (Pickup, error) {
+	s.pickupsLock.Lock()
+	defer s.pickupsLock.Unlock()
+
+	if vanId < 1 {
+		return Pickup{}, apiErrorf(http.StatusBadRequest, invalidParameterCode, "invalid van id %v", vanId)
+	}
+	if _, err := s.activeVan(vanId); err != nil {
+		return Pickup{}, err
+	}
+
+	tmp, err := s.activePickup(number)
+	if err != nil {
+		return Pickup{}, err
+	}
+
+	return s.assignPickup(session, tmp, vanId, driverId)
+}
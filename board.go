@@ -0,0 +1,218 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"time"
+)
+
+//Pickup board message types
+const boardSnapshot string = "snapshot"
+const boardPickupAdded string = "pickupAdded"
+const boardPickupUpdated string = "pickupUpdated"
+const boardPickupRemoved string = "pickupRemoved"
+const boardVanUpdated string = "vanUpdated"
+const boardVanRemoved string = "vanRemoved"
+
+const boardSendBuffer = 64 //messages queued for a client before it is dropped as too slow
+const boardWriteTimeout = time.Duration(10) * time.Second
+const boardPongTimeout = time.Duration(60) * time.Second
+const boardPingInterval = time.Duration(25) * time.Second
+
+//Message sent to board clients. A snapshot carries every pickup and van, the other types one pickup or van.
+type boardMessage struct {
+	Type        string            `json:"type"`
+	Pickups     map[string]Pickup `json:"pickups,omitempty"`
+	Vans        map[int]Location  `json:"vans,omitempty"` //keyed by van id
+	PhoneNumber string            `json:"phoneNumber,omitempty"`
+	Pickup      *Pickup           `json:"pickup,omitempty"`
+	VanId       int               `json:"vanId,omitempty"`
+	Van         *Location         `json:"van,omitempty"`
+	Time        time.Time         `json:"time"`
+}
+
+type boardClient struct {
+	session Session
+	send    chan []byte
+}
+
+//Apps authenticate with a bearer token rather than cookies, so connections from any origin are accepted
+var boardUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+//Queue a message for every board client. Clients whose queue is full are dropped instead of waiting for them.
+func (s *Server) publishBoard(message boardMessage) {
+	message.Time = s.clock()
+	output, err := json.Marshal(message)
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+
+	s.boardClientsLock.Lock()
+	defer s.boardClientsLock.Unlock()
+
+	if message.Type == boardPickupAdded || message.Type == boardPickupUpdated {
+		s.boardPickupsSent[message.PhoneNumber] = true
+	} else if message.Type == boardPickupRemoved {
+		delete(s.boardPickupsSent, message.PhoneNumber)
+	}
+
+	for client := range s.boardClients {
+		select {
+		case client.send <- output:
+		default:
+			s.logger.Println("Dropping slow board client", sessionActor(client.session))
+			delete(s.boardClients, client)
+			close(client.send)
+		}
+	}
+}
+
+//Send the current state of a pickup to board clients. Pickups that no longer exist or became inactive are removed. pickupsLock is held until the message is queued, so a change cannot be committed between reading a pickup and queuing it and an older state never reaches clients after a newer one.
+func (s *Server) publishBoardPickup(number string) {
+	s.pickupsLock.RLock()
+	defer s.pickupsLock.RUnlock()
+
+	tmp, exist := s.pickups[number]
+	if !exist || tmp.Status == inactive {
+		s.boardClientsLock.Lock()
+		sent := s.boardPickupsSent[number]
+		s.boardClientsLock.Unlock()
+
+		if sent {
+			s.publishBoard(boardMessage{Type: boardPickupRemoved, PhoneNumber: number})
+		}
+		return
+	}
+
+	s.boardClientsLock.Lock()
+	messageType := boardPickupUpdated
+	if !s.boardPickupsSent[number] {
+		messageType = boardPickupAdded
+	}
+	s.boardClientsLock.Unlock()
+
+	s.publishBoard(boardMessage{Type: messageType, PhoneNumber: number, Pickup: &tmp})
+}
+
+func (s *Server) publishBoardVan(vanId int, vanLocation Location) {
+	s.publishBoard(boardMessage{Type: boardVanUpdated, VanId: vanId, Van: &vanLocation})
+}
+
+func (s *Server) publishBoardVansRemoved(vanIds []int) {
+	for _, v := range vanIds {
+		s.publishBoard(boardMessage{Type: boardVanRemoved, VanId: v})
+	}
+}
+
+//A pickup changed in memory: wake rider streams and update the board
+func (s *Server) pickupChanged(number string) {
+	s.notifyPickupSubscribers(number)
+	s.publishBoardPickup(number)
+}
+
+//Register a client and queue the snapshot as its first message. The snapshot is taken while pickups cannot change, so every later change reaches the client after it.
+func (s *Server) addBoardClient(session Session) *boardClient {
+	client := &boardClient{session: session, send: make(chan []byte, boardSendBuffer)}
+
+	s.pickupsLock.RLock()
+	defer s.pickupsLock.RUnlock()
+
+	snapshot := boardMessage{Type: boardSnapshot, Pickups: make(map[string]Pickup), Vans: s.activeVanLocations(), Time: s.clock()}
+	for k, v := range s.pickups {
+		if v.Status != inactive {
+			snapshot.Pickups[k] = v
+		}
+	}
+
+	output, err := json.Marshal(snapshot)
+	if err != nil {
+		s.logger.Println(err)
+		return nil
+	}
+	client.send <- output
+
+	s.boardClientsLock.Lock()
+	s.boardClients[client] = true
+	for k := range snapshot.Pickups {
+		s.boardPickupsSent[k] = true
+	}
+	s.boardClientsLock.Unlock()
+	return client
+}
+
+func (s *Server) removeBoardClient(client *boardClient) {
+	s.boardClientsLock.Lock()
+	defer s.boardClientsLock.Unlock()
+
+	if s.boardClients[client] {
+		delete(s.boardClients, client)
+		close(client.send)
+	}
+}
+
+//WebSocket feed of the pickup board for drivers and dispatchers: a snapshot of every pickup and van, then a message for each change
+func (s *Server) pickupBoard(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("pickupBoard()")
+
+	conn, err := boardUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+
+	client := s.addBoardClient(session)
+	if client == nil {
+		conn.Close()
+		return
+	}
+
+	//read in the background only to answer pings and notice when the client goes away
+	go func() {
+		defer s.removeBoardClient(client)
+
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(boardPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(boardPongTimeout))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(boardPingInterval)
+	defer ping.Stop()
+	defer conn.Close()
+
+	for {
+		select {
+		case output, open := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(boardWriteTimeout))
+			if !open {
+				//dropped for falling behind, or the reader saw the connection close
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, output); err != nil {
+				s.removeBoardClient(client)
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(boardWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				s.removeBoardClient(client)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//The shipmate server and its driver and migrate subcommands. The server itself is in the shipmate package so it can also be embedded in other programs.
+package main
+
+import (
+	"github.com/ansonl/shipmate"
+	"os"
+)
+
+func main() {
+	os.Exit(shipmate.Main(os.Args[1:]))
+}
@@ -0,0 +1,138 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//Settings admins may change at runtime and their defaults. Servers may replace the defaults with WithConfig.
+var configDefaults = map[string]float64{
+	"pickupTimeoutMinutes":     5,   //clear device phrase of pickups not updated for this long
+	"vanTimeoutMinutes":        10,  //hide vans that have not reported for this long
+	"vanCapacity":              12,  //most active pickups a van may hold
+	"dispatchAutoAssign":       0,   //1 assigns new pickups to the best van, 0 only suggests the van to its driver
+	"dispatchLoadPenaltyKm":    1.5, //distance added to a van's score for each pickup it already holds
+	"dispatchHeadingPenaltyKm": 1,   //distance added to a van's score when it is heading directly away from the pickup
+	"vanAverageSpeedKph":       25,  //speed used for arrival estimates when a van's speed has not been measured
+	"etaUseMeasuredSpeed":      1,   //1 estimates arrivals with speeds measured from van location updates, 0 always uses vanAverageSpeedKph
+	"etaStopMinutes":           2,   //time a van spends at each stop before the rider's
+}
+
+//Return the current value of a setting, or its default if it has not been changed
+func (s *Server) configValue(key string) float64 {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+
+	if value, exist := s.configValues[key]; exist {
+		return value
+	}
+	return s.configDefaults[key]
+}
+
+func (s *Server) configMinutes(key string) time.Duration {
+	return time.Duration(s.configValue(key) * float64(time.Minute))
+}
+
+//SELECT every setting from config table into memory so changes made on other instances are picked up
+func (s *Server) loadConfig() {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return
+	}
+
+	rows, err := s.db.Query("SELECT Key, Value FROM config;")
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+
+	tmpValues := make(map[string]float64)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			s.logger.Println(err)
+			continue
+		}
+		if parsedValue, err := strconv.ParseFloat(value, 64); err == nil {
+			tmpValues[key] = parsedValue
+		} else {
+			s.logger.Println(err)
+		}
+	}
+	rows.Close()
+
+	s.configLock.Lock()
+	s.configValues = tmpValues
+	s.configLock.Unlock()
+}
+
+//INSERT or UPDATE a setting in config table
+func (s *Server) databaseUpsertConfig(key string, value float64, driverId int) bool {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if _, err := s.db.Exec(`INSERT INTO config (Key, Value, UpdatedTime, UpdatedDriverId)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (Key) DO UPDATE SET Value = $2, UpdatedTime = $3, UpdatedDriverId = $4;`, key, strconv.FormatFloat(value, 'f', -1, 64), time.Now(), driverId); err != nil {
+			s.logger.Println(err)
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("getConfig()")
+
+	current := make(map[string]float64)
+	for k := range configDefaults {
+		current[k] = s.configValue(k)
+	}
+
+	if output, err := json.Marshal(current); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
+
+func (s *Server) setConfig(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("setConfig()")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	key, keyExist := v.required(r.Form, "key")
+	if _, exist := configDefaults[key]; keyExist && !exist {
+		v.addError("key", "is not a config key")
+	}
+	var value float64
+	if rawValue, exist := v.required(r.Form, "value"); exist {
+		var ok bool
+		if value, ok = v.number("value", rawValue); ok && value < 0 {
+			v.addError("value", "must not be negative")
+		}
+	}
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	if !s.databaseUpsertConfig(key, value, session.DriverId) {
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	s.configLock.Lock()
+	if s.configValues == nil {
+		s.configValues = make(map[string]float64)
+	}
+	s.configValues[key] = value
+	s.configLock.Unlock()
+
+	s.logger.Printf("Config %v set to %v by driver %v\n", key, value, session.DriverId)
+	fmt.Fprint(w, successResponse)
+}
@@ -0,0 +1,192 @@
+package shipmate
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const earthRadiusKm = 6371.0
+
+//How well a van suits a pickup. Lower scores are better, measured in km of driving.
+type vanScore struct {
+	VanId      int     `json:"vanId"`
+	DistanceKm float64 `json:"distanceKm"`
+	Load       int     `json:"load"`
+	Score      float64 `json:"score"`
+}
+
+func degreesToRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+//Great circle distance between two locations
+func haversineKm(from Location, to Location) float64 {
+	lat1 := degreesToRadians(from.Latitude)
+	lat2 := degreesToRadians(to.Latitude)
+	dLat := lat2 - lat1
+	dLon := degreesToRadians(to.Longitude - from.Longitude)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+//Compass bearing in degrees from one location towards another
+func bearingDegrees(from Location, to Location) float64 {
+	lat1 := degreesToRadians(from.Latitude)
+	lat2 := degreesToRadians(to.Latitude)
+	dLon := degreesToRadians(to.Longitude - from.Longitude)
+
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+//Angle between two compass headings, 0 to 180 degrees
+func headingDifference(a float64, b float64) float64 {
+	difference := math.Mod(math.Abs(a-b), 360)
+	if difference > 180 {
+		difference = 360 - difference
+	}
+	return difference
+}
+
+//Vans that have reported within vanTimeoutMinutes
+func (s *Server) isVanActive(vanLocation Location, now time.Time) bool {
+	return (vanLocation.latestTime != time.Time{}) && now.Sub(vanLocation.latestTime) <= s.configMinutes("vanTimeoutMinutes")
+}
+
+//Pickups whose rider stopped reporting. They are not dispatched again or counted in van loads and queues.
+func isPickupAbandoned(targetPickup Pickup) bool {
+	return targetPickup.devicePhrase == "" || targetPickup.Status == expired
+}
+
+//Count active pickups held by each van. Caller must hold pickupsLock.
+func (s *Server) vanLoads() map[int]int {
+	loads := make(map[int]int)
+	for _, v := range s.pickups {
+		if v.VanId != 0 && v.Status.isActive() && !isPickupAbandoned(v) {
+			loads[v.VanId]++
+		}
+	}
+	return loads
+}
+
+//Score vans in service that reported recently for a pickup, best first. Vans in excludedVanIds and full vans are left out. Caller must hold pickupsLock.
+func (s *Server) rankVansForPickup(targetPickup Pickup, excludedVanIds map[int]bool) []vanScore {
+	loads := s.vanLoads()
+	loadPenalty := s.configValue("dispatchLoadPenaltyKm")
+	headingPenalty := s.configValue("dispatchHeadingPenaltyKm")
+	now := s.clock()
+
+	//vans in id order so vans with the same score are always ranked the same way
+	locations := s.activeVanLocations()
+	vanIds := make([]int, 0, len(locations))
+	for k := range locations {
+		vanIds = append(vanIds, k)
+	}
+	sort.Ints(vanIds)
+
+	scores := make([]vanScore, 0)
+	for _, vanId := range vanIds {
+		v := locations[vanId]
+		if !s.isVanActive(v, now) || excludedVanIds[vanId] || loads[vanId] >= s.vanCapacity(vanId) {
+			continue
+		}
+
+		tmp := vanScore{VanId: vanId, DistanceKm: haversineKm(v, targetPickup.LatestLocation), Load: loads[vanId]}
+		tmp.Score = tmp.DistanceKm + loadPenalty*float64(tmp.Load)
+
+		//a van driving away from the pickup has to turn around first, heading is -1 when the van did not report one
+		if v.Heading >= 0 && tmp.DistanceKm > 0 {
+			tmp.Score += headingPenalty * headingDifference(v.Heading, bearingDegrees(v, targetPickup.LatestLocation)) / 180
+		}
+		scores = append(scores, tmp)
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score < scores[j].Score
+	})
+	return scores
+}
+
+//Pick the best van for a pending pickup that no van holds and, depending on dispatchAutoAssign, assign the pickup to it or suggest it to the van's driver. Vans in declinedVanIds are skipped. Caller must hold pickupsLock.
+func (s *Server) dispatchPickup(targetPickup Pickup, declinedVanIds map[int]bool) {
+	if targetPickup.Status != pending || targetPickup.VanId != 0 || isPickupAbandoned(targetPickup) {
+		return
+	}
+
+	tmp := targetPickup
+	tmp.SuggestedVanId = 0
+	eventType := suggestionEvent
+	var eventVanId int
+
+	if scores := s.rankVansForPickup(tmp, declinedVanIds); len(scores) > 0 {
+		eventVanId = scores[0].VanId
+		if s.configValue("dispatchAutoAssign") == 1 {
+			tmp.VanId = eventVanId
+			vanLocation, _ := s.vanLocations.get(eventVanId)
+			tmp.DriverId = vanLocation.driverId
+			eventType = assignmentEvent
+		} else {
+			tmp.SuggestedVanId = eventVanId
+		}
+	}
+
+	//nothing to write if dispatch picked the same van as before
+	if current := s.pickups[tmp.PhoneNumber]; current.VanId == tmp.VanId && current.SuggestedVanId == tmp.SuggestedVanId {
+		return
+	}
+
+	if eventVanId == 0 {
+		s.logger.Println("No van available to dispatch pickup", tmp.PhoneNumber)
+	}
+	s.commitPickupAssignment(tmp, eventType, eventVanId, systemActor)
+}
+
+//Dispatch pending pickups again that were given to vans which stopped reporting, and retry pickups no van was available for
+func (s *Server) redispatchPickups(staleVanIds []int) {
+	stale := make(map[int]bool)
+	for _, v := range staleVanIds {
+		stale[v] = true
+	}
+	needsDispatch := func(targetPickup Pickup) bool {
+		if targetPickup.Status != pending || isPickupAbandoned(targetPickup) {
+			return false
+		}
+		return stale[targetPickup.VanId] || stale[targetPickup.SuggestedVanId] || (targetPickup.VanId == 0 && targetPickup.SuggestedVanId == 0)
+	}
+
+	//look up declined vans before taking pickupsLock so the database is not queried while holding it
+	s.pickupsLock.RLock()
+	candidates := make([]Pickup, 0)
+	for _, v := range s.pickups {
+		if needsDispatch(v) {
+			candidates = append(candidates, v)
+		}
+	}
+	s.pickupsLock.RUnlock()
+
+	declinedVanIds := make([]map[int]bool, len(candidates))
+	for i, v := range candidates {
+		declinedVanIds[i] = s.selectDeclinedVanIds(v)
+	}
+
+	s.pickupsLock.Lock()
+	defer s.pickupsLock.Unlock()
+
+	for i, candidate := range candidates {
+		//the pickup may have changed while declined vans were looked up
+		v, exist := s.pickups[candidate.PhoneNumber]
+		if !exist || !v.InitialTime.Equal(candidate.InitialTime) || !needsDispatch(v) {
+			continue
+		}
+
+		if stale[v.VanId] || stale[v.SuggestedVanId] {
+			s.logger.Println("Van for pickup", v.PhoneNumber, "stopped reporting, dispatching again")
+			v.VanId = 0
+			v.DriverId = 0
+		}
+		s.dispatchPickup(v, declinedVanIds[i])
+	}
+}
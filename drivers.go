@@ -0,0 +1,379 @@
+package shipmate
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//Staff account. Each van driver, dispatcher and admin logs in with their own credentials so a single person can be disabled without affecting the rest of the fleet.
+type Driver struct {
+	Id           int    `json:"driverId"`
+	Username     string `json:"username"`
+	passwordHash string
+	Enabled      bool   `json:"enabled"`
+	Role         string `json:"role"`
+	VanId        int    `json:"vanId"` //van the driver may report locations for, 0 if none
+}
+
+//Hash compared against when a username does not exist so that failed logins take the same time either way. A bcrypt hash at bcrypt.DefaultCost, precomputed so it is not hashed at startup.
+const dummyPasswordHash = "$2a$10$fel3fOwHVSpvIXxDBEb1V.nYi2LR5d1gtYWEnAY2FTygzVWAfxQxO"
+
+func hashDriverPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+//SELECT driver row by username. Return false if the driver does not exist.
+func (s *Server) selectDriverByUsername(username string) (Driver, bool) {
+	var tmpDriver Driver
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return tmpDriver, false
+	}
+
+	if err := s.db.QueryRow(`SELECT DriverId, Username, PasswordHash, Enabled, Role, VanId
+		FROM drivers
+		WHERE Username = $1;`, username).Scan(&tmpDriver.Id, &tmpDriver.Username, &tmpDriver.passwordHash, &tmpDriver.Enabled, &tmpDriver.Role, &tmpDriver.VanId); err != nil {
+		if err != sql.ErrNoRows {
+			s.logger.Println(err)
+		}
+		return tmpDriver, false
+	}
+	return tmpDriver, true
+}
+
+//SELECT all driver rows ordered by DriverId
+func (s *Server) selectAllDrivers() []Driver {
+	drivers := make([]Driver, 0)
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return drivers
+	}
+
+	rows, err := s.db.Query("SELECT DriverId, Username, PasswordHash, Enabled, Role, VanId FROM drivers ORDER BY DriverId;")
+	if err != nil {
+		s.logger.Println(err)
+		return drivers
+	}
+	for rows.Next() {
+		var tmpDriver Driver
+		if err := rows.Scan(&tmpDriver.Id, &tmpDriver.Username, &tmpDriver.passwordHash, &tmpDriver.Enabled, &tmpDriver.Role, &tmpDriver.VanId); err != nil {
+			s.logger.Println(err)
+			continue
+		}
+		drivers = append(drivers, tmpDriver)
+	}
+	rows.Close()
+	return drivers
+}
+
+//INSERT new driver row in drivers table
+func (s *Server) databaseInsertDriver(targetDriver Driver) bool {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if _, err := s.db.Exec(`INSERT INTO drivers (Username, PasswordHash, Enabled, Role, VanId)
+			VALUES ($1, $2, $3, $4, $5);`, targetDriver.Username, targetDriver.passwordHash, targetDriver.Enabled, targetDriver.Role, targetDriver.VanId); err != nil {
+			s.logger.Println(err)
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+//UPDATE password hash, enabled flag, role and van of a driver row in drivers table
+func (s *Server) databaseUpdateDriver(targetDriver Driver) bool {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if result, err := s.db.Exec(`UPDATE drivers
+			SET PasswordHash = $1, Enabled = $2, Role = $3, VanId = $4
+			WHERE DriverId = $5;`, targetDriver.passwordHash, targetDriver.Enabled, targetDriver.Role, targetDriver.VanId, targetDriver.Id); err != nil {
+			s.logger.Println(err)
+		} else if rowsAffected, _ := result.RowsAffected(); rowsAffected == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+//Check "username" and "password" parameters against the drivers table. Return the driver and true if the credentials are correct and the driver is enabled.
+func (s *Server) authenticateDriver(targetDictionary url.Values) (Driver, bool) {
+	if !doKeysExist(targetDictionary, []string{"username", "password"}) || areFieldsEmpty(targetDictionary, []string{"username", "password"}) {
+		return Driver{}, false
+	}
+
+	username := targetDictionary["username"][0]
+	password := targetDictionary["password"][0]
+
+	tmpDriver, exist := s.selectDriverByUsername(username)
+	if !exist {
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		s.logger.Println("Unknown driver username", username)
+		return Driver{}, false
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(tmpDriver.passwordHash), []byte(password)); err != nil {
+		s.logger.Println("Wrong password for driver", tmpDriver.Id)
+		return Driver{}, false
+	}
+
+	if !tmpDriver.Enabled {
+		s.logger.Println("Disabled driver", tmpDriver.Id, "attempted to sign in")
+		return Driver{}, false
+	}
+
+	return tmpDriver, true
+}
+
+func (s *Server) driverLogin(w http.ResponseWriter, r *http.Request) {
+	s.logger.Println("driverLogin()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	driver, isDriver := s.authenticateDriver(r.Form)
+	if !isDriver {
+		fmt.Fprint(w, wrongPasswordResponse)
+		return
+	}
+
+	if tokens, ok := s.issueSessionTokens(Session{Role: driver.Role, DriverId: driver.Id, VanId: driver.VanId}); ok {
+		s.writeSessionTokens(w, tokens)
+	} else {
+		fmt.Fprint(w, failResponse)
+	}
+}
+
+//Apply the optional "password", "enabled", "role" and "vanId" parameters to a driver. A "vanId" of 0 takes the driver off their van, any other van must be registered and in service.
+func (s *Server) applyDriverParameters(targetDriver *Driver, targetDictionary url.Values) error {
+	var v validator
+	tmpDriver := *targetDriver
+
+	if value, exist := formValue(targetDictionary, "enabled"); exist {
+		if enabled, err := strconv.ParseBool(value); err != nil {
+			v.addError("enabled", "must be true or false")
+		} else {
+			tmpDriver.Enabled = enabled
+		}
+	}
+
+	if value, exist := formValue(targetDictionary, "role"); exist {
+		if !isStaffRole(value) {
+			v.addError("role", "must be one of %v", strings.Join(staffRoles, ", "))
+		} else {
+			tmpDriver.Role = value
+		}
+	}
+
+	value, changingVan := formValue(targetDictionary, "vanId")
+	if changingVan {
+		if value == "0" {
+			tmpDriver.VanId = 0
+		} else {
+			tmpDriver.VanId = v.vanId("vanId", value)
+		}
+	}
+
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	if changingVan && tmpDriver.VanId != 0 {
+		if _, err := s.activeVan(tmpDriver.VanId); err != nil {
+			return err
+		}
+	}
+
+	//hash the password only once everything else is valid, bcrypt is slow on purpose
+	if password, exist := formValue(targetDictionary, "password"); exist {
+		hash, err := hashDriverPassword(password)
+		if err != nil {
+			return err
+		}
+		tmpDriver.passwordHash = hash
+	}
+
+	*targetDriver = tmpDriver
+	return nil
+}
+
+func (s *Server) listAccounts(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("listAccounts()")
+
+	if output, err := json.Marshal(s.selectAllDrivers()); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
+
+func (s *Server) createAccount(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("createAccount()")
+
+	//parse http parameters
+	r.ParseForm()
+
+	if !doKeysExist(r.Form, []string{"username", "password"}) || areFieldsEmpty(r.Form, []string{"username", "password"}) {
+		s.logger.Println("required http parameters not found for createAccount")
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	tmpDriver := Driver{Username: r.Form["username"][0], Enabled: true, Role: driverRole}
+	if err := s.applyDriverParameters(&tmpDriver, r.Form); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+	if !s.databaseInsertDriver(tmpDriver) {
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	s.logger.Printf("Account %v created by driver %v\n", tmpDriver.Username, session.DriverId)
+	fmt.Fprint(w, successResponse)
+}
+
+func (s *Server) updateAccount(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("updateAccount()")
+
+	//parse http parameters
+	r.ParseForm()
+
+	if !doKeysExist(r.Form, []string{"username"}) || areFieldsEmpty(r.Form, []string{"username"}) {
+		s.logger.Println("required http parameters not found for updateAccount")
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	tmpDriver, exist := s.selectDriverByUsername(r.Form["username"][0])
+	if !exist {
+		fmt.Fprint(w, failResponse)
+		return
+	}
+	if err := s.applyDriverParameters(&tmpDriver, r.Form); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+	if !s.databaseUpdateDriver(tmpDriver) {
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	//sign the account out everywhere so the new role, van or password takes effect immediately
+	s.databaseRevokeDriverSessions(tmpDriver.Id)
+
+	s.logger.Printf("Account %v updated by driver %v\n", tmpDriver.Username, session.DriverId)
+	fmt.Fprint(w, successResponse)
+}
+
+//Read a password from the first line of stdin so that it does not show up in shell history or the process list
+func (s *Server) readPasswordFromStdin() string {
+	fmt.Fprintln(os.Stderr, "Enter password:")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		s.logger.Println(err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+//Apply parameters collected from the command line, printing why they were refused
+func (s *Server) applyDriverCommand(targetDriver *Driver, parameters url.Values) bool {
+	if err := s.applyDriverParameters(targetDriver, parameters); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	return true
+}
+
+//Handle "shipmate driver <command> <username> [value]" account management. Return process exit code.
+func (s *Server) driverCommand(args []string) int {
+	usage := `Usage: shipmate driver add|passwd|enable|disable <username>
+       shipmate driver role <username> driver|dispatcher|admin
+       shipmate driver van <username> <vanId>
+       shipmate driver list`
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	if !s.migrateDatabase() {
+		fmt.Fprintln(os.Stderr, "Drivers table unavailable.")
+		return 1
+	}
+
+	if args[0] == "list" {
+		for _, v := range s.selectAllDrivers() {
+			fmt.Printf("%v\t%v\t%v\tvan=%v\tenabled=%v\n", v.Id, v.Username, v.Role, v.VanId, v.Enabled)
+		}
+		return 0
+	}
+
+	if len(args) < 2 || isFieldEmpty(args[1]) {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	username := args[1]
+
+	//collect the change as http style parameters so it is validated the same way as the admin endpoints
+	parameters := url.Values{}
+	switch args[0] {
+	case "add", "passwd":
+		password := s.readPasswordFromStdin()
+		if isFieldEmpty(password) {
+			fmt.Fprintln(os.Stderr, "Password must not be empty.")
+			return 1
+		}
+		parameters.Set("password", password)
+	case "enable":
+		parameters.Set("enabled", "true")
+	case "disable":
+		parameters.Set("enabled", "false")
+	case "role", "van":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		if args[0] == "role" {
+			parameters.Set("role", args[2])
+		} else {
+			//the registry is only loaded by Start, vans are checked against it
+			s.loadVans()
+			parameters.Set("vanId", args[2])
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	var ok bool
+	if args[0] == "add" {
+		tmpDriver := Driver{Username: username, Enabled: true, Role: driverRole}
+		ok = s.applyDriverCommand(&tmpDriver, parameters) && s.databaseInsertDriver(tmpDriver)
+	} else if tmpDriver, exist := s.selectDriverByUsername(username); exist {
+		ok = s.applyDriverCommand(&tmpDriver, parameters) && s.databaseUpdateDriver(tmpDriver)
+		if ok {
+			//sign the account out everywhere so the change takes effect immediately
+			s.databaseRevokeDriverSessions(tmpDriver.Id)
+		}
+	}
+
+	if !ok {
+		fmt.Fprintf(os.Stderr, "driver %v %v failed\n", args[0], username)
+		return 1
+	}
+	fmt.Printf("driver %v %v done\n", args[0], username)
+	return 0
+}
@@ -0,0 +1,212 @@
+package shipmate
+
+import (
+	"net/http"
+)
+
+//A route and what /openapi.json says about it. registerRoutes registers every endpoint from these definitions, so the document cannot leave a route out or get its permissions wrong.
+type endpoint struct {
+	method      string //empty for legacy routes, which accept any method
+	pattern     string //path, /api/v1 paths may have {name} segments
+	summary     string
+	permissions []permission //the session's role must hold one of them, nil for routes anyone may call
+	session     bool         //needs a session even though no permission is checked
+	parameters  []endpointParameter
+	async       bool        //accepts the "async" parameter
+	body        interface{} //value of the JSON request body type, nil if there is none
+	status      int         //status of a successful reply, 200 if 0
+	reply       interface{} //value of the type of a successful reply, nil for routes that reply with a canned status
+	contentType string      //of a successful reply, application/json if empty
+	messages    interface{} //value of the type of each message of a stream
+	public      http.HandlerFunc
+	handler     sessionHandlerFunc
+}
+
+//A query or form parameter
+type endpointParameter struct {
+	name        string
+	kind        string //OpenAPI type: string, number, integer or boolean
+	format      string
+	required    bool
+	description string
+}
+
+//Parameters shared by several routes
+var (
+	phoneNumberParameter      = endpointParameter{"phoneNumber", "string", "", true, "10 digit phone number of the pickup"}
+	staffPhoneNumberParameter = endpointParameter{"phoneNumber", "string", "", false, "10 digit phone number of the pickup. Required for staff, riders always get their own pickup"}
+	latitudeParameter         = endpointParameter{"latitude", "number", "double", true, "From -90 to 90"}
+	longitudeParameter        = endpointParameter{"longitude", "number", "double", true, "From -180 to 180"}
+	deviceIdParameter         = endpointParameter{"deviceId", "string", "uuid", true, "UUID the app generated for the device"}
+
+	//optional van details of /createVan and /updateVan
+	vanParameters = []endpointParameter{
+		{"capacity", "integer", "", false, "Most active pickups the van may hold, 0 for the vanCapacity setting"},
+		{"active", "boolean", "", false, "Whether the van is in service. New vans are"},
+		{"plate", "string", "", false, "At most 16 characters"},
+		{"accessibility", "string", "", false, "Features separated by commas, e.g. wheelchair,ramp, or none"},
+	}
+)
+
+//Legacy routes. Parameters may be sent in the query or a form body with any method, and replies are JSON with HTTP status 200 whether the request succeeded or not.
+func (s *Server) endpoints() []endpoint {
+	return []endpoint{
+		//general functions
+		{pattern: "/", summary: "Redirect to the project page", status: http.StatusFound, public: s.aboutHandler},
+		{pattern: "/uptime", summary: "Uptime and how many pickups and vans are in memory", contentType: "text/plain", reply: "", public: s.uptimeHandler},
+		{pattern: "/openapi.json", summary: "This document", reply: map[string]interface{}{}, public: s.openAPIHandler},
+
+		//session functions
+		{pattern: "/requestVerificationCode", summary: "Text a verification code to a rider's phone", parameters: []endpointParameter{
+			phoneNumberParameter,
+			deviceIdParameter,
+		}, public: s.requestVerificationCode},
+		{pattern: "/registerRider", summary: "Confirm a verification code and start a rider session", parameters: []endpointParameter{
+			phoneNumberParameter,
+			deviceIdParameter,
+			{"code", "string", "", true, "6 digit code texted by /requestVerificationCode"},
+		}, reply: sessionTokens{}, public: s.registerRider},
+		{pattern: "/driverLogin", summary: "Start a staff session", parameters: []endpointParameter{
+			{"username", "string", "", true, ""},
+			{"password", "string", "password", true, ""},
+		}, reply: sessionTokens{}, public: s.driverLogin},
+		{pattern: "/refreshSession", summary: "Exchange a refresh token for new tokens", parameters: []endpointParameter{
+			{"refreshToken", "string", "", true, ""},
+		}, reply: sessionTokens{}, public: s.refreshSession},
+		{pattern: "/logout", summary: "Revoke the session on every instance", session: true, handler: s.logout},
+
+		//pickupee functions
+		{pattern: "/newPickup", summary: "Request a pickup for the rider's phone number", permissions: []permission{createPickupPermission}, parameters: []endpointParameter{
+			latitudeParameter,
+			longitudeParameter,
+		}, async: true, reply: Pickup{}, handler: s.newPickup},
+		{pattern: "/getPickupInfo", summary: "Pickup with its van, arrival estimate and queue position", permissions: []permission{viewOwnPickupPermission, viewAnyPickupPermission}, parameters: []endpointParameter{
+			staffPhoneNumberParameter,
+			{"latitude", "number", "double", false, "Rider's location, recorded like /updatePickupLocation"},
+			{"longitude", "number", "double", false, "Rider's location, recorded like /updatePickupLocation"},
+		}, reply: pickupInfo{}, handler: s.getPickupInfo},
+		{pattern: "/updatePickupLocation", summary: "Report the rider's location", permissions: []permission{locateOwnPickupPermission}, parameters: []endpointParameter{
+			latitudeParameter,
+			longitudeParameter,
+		}, handler: s.updatePickupLocation},
+		{pattern: "/streamPickupInfo", summary: "Server-Sent Events stream of /getPickupInfo replies", permissions: []permission{viewOwnPickupPermission, viewAnyPickupPermission}, parameters: []endpointParameter{
+			staffPhoneNumberParameter,
+			{"accessToken", "string", "", false, "Access token for clients that cannot set the Authorization header"},
+		}, contentType: "text/event-stream", messages: pickupInfo{}, handler: s.streamPickupInfo},
+		{pattern: "/getPickupEvents", summary: "Timeline of a pickup", permissions: []permission{viewOwnPickupPermission, viewAnyPickupPermission}, parameters: []endpointParameter{
+			staffPhoneNumberParameter,
+			{"initialTime", "string", "date-time", false, "Initial time of a past pickup, staff only"},
+		}, reply: []PickupEvent{}, handler: s.getPickupEvents},
+		{pattern: "/getRequestStatus", summary: "State of an async write", permissions: []permission{viewOwnPickupPermission, viewAnyPickupPermission}, parameters: []endpointParameter{
+			{"requestId", "string", "", true, "Returned by the async request"},
+		}, reply: queuedWrite{}, handler: s.getRequestStatus},
+		{pattern: "/getVanLocations", summary: "Location of every van in service that has reported, keyed by van id", reply: map[int]Location{}, public: s.getVanLocations},
+
+		//shared functions
+		{pattern: "/cancelPickup", summary: "Cancel a pickup", permissions: []permission{cancelOwnPickupPermission, cancelAnyPickupPermission}, parameters: []endpointParameter{
+			staffPhoneNumberParameter,
+		}, async: true, handler: s.cancelPickup},
+
+		//driver functions
+		{pattern: "/getPickupList", summary: "Every pickup in memory by phone number", permissions: []permission{listPickupsPermission}, reply: map[string]Pickup{}, handler: s.getPickupList},
+		{pattern: "/pickupBoard", summary: "WebSocket of every change to pickups and vans", permissions: []permission{listPickupsPermission}, parameters: []endpointParameter{
+			{"accessToken", "string", "", false, "Access token for clients that cannot set the Authorization header"},
+		}, status: http.StatusSwitchingProtocols, messages: boardMessage{}, handler: s.pickupBoard},
+		{pattern: "/confirmPickup", summary: "Confirm a pending pickup, claiming it for the driver's van", permissions: []permission{confirmPickupPermission}, parameters: []endpointParameter{
+			phoneNumberParameter,
+		}, async: true, handler: s.confirmPickup},
+		{pattern: "/completePickup", summary: "Complete a pickup", permissions: []permission{completePickupPermission}, parameters: []endpointParameter{
+			phoneNumberParameter,
+		}, async: true, handler: s.completePickup},
+		{pattern: "/updatePickupStatus", summary: "Report progress on a confirmed pickup", permissions: []permission{progressPickupPermission}, parameters: []endpointParameter{
+			phoneNumberParameter,
+			{"status", "string", "", true, "enRoute, arrived or noShow"},
+		}, async: true, handler: s.updatePickupStatus},
+		{pattern: "/claimPickup", summary: "Take a pickup for the driver's van", permissions: []permission{claimPickupPermission}, parameters: []endpointParameter{
+			phoneNumberParameter,
+		}, handler: s.claimPickup},
+		{pattern: "/unassignPickup", summary: "Release a pickup from its van", permissions: []permission{claimPickupPermission, reassignPickupPermission}, parameters: []endpointParameter{
+			phoneNumberParameter,
+		}, handler: s.unassignPickup},
+		{pattern: "/reassignPickup", summary: "Move a pickup to another van", permissions: []permission{reassignPickupPermission}, parameters: []endpointParameter{
+			phoneNumberParameter,
+			{"vanId", "integer", "", true, "Van to move the pickup to"},
+			{"driverId", "integer", "", false, "Driver of the van, if known"},
+		}, handler: s.reassignPickup},
+		{pattern: "/getVanRoute", summary: "Stop order for the driver's van", permissions: []permission{listPickupsPermission}, parameters: []endpointParameter{
+			{"vanId", "integer", "", false, "Any van, dispatchers only"},
+		}, reply: VanRoute{}, handler: s.getVanRoute},
+		{pattern: "/updateVanLocation", summary: "Report a van's location", permissions: []permission{updateOwnVanPermission, updateAnyVanPermission}, parameters: []endpointParameter{
+			{"vanNumber", "integer", "", true, "Van reporting. It must be registered and in service"},
+			latitudeParameter,
+			longitudeParameter,
+			{"heading", "number", "double", false, "From 0 to 360, -1 if unknown"},
+		}, reply: Location{}, handler: s.updateVanLocation},
+
+		//admin functions
+		{pattern: "/listAccounts", summary: "Every staff account", permissions: []permission{manageAccountsPermission}, reply: []Driver{}, handler: s.listAccounts},
+		{pattern: "/createAccount", summary: "Create a staff account", permissions: []permission{manageAccountsPermission}, parameters: []endpointParameter{
+			{"username", "string", "", true, ""},
+			{"password", "string", "password", true, ""},
+			{"role", "string", "", false, "driver, dispatcher or admin. Defaults to driver"},
+			{"vanId", "integer", "", false, "Van the driver may report, 0 for none"},
+			{"enabled", "boolean", "", false, ""},
+		}, handler: s.createAccount},
+		{pattern: "/updateAccount", summary: "Change a staff account and sign it out everywhere", permissions: []permission{manageAccountsPermission}, parameters: []endpointParameter{
+			{"username", "string", "", true, ""},
+			{"password", "string", "password", false, ""},
+			{"role", "string", "", false, "driver, dispatcher or admin"},
+			{"vanId", "integer", "", false, "Van the driver may report, 0 for none"},
+			{"enabled", "boolean", "", false, ""},
+		}, handler: s.updateAccount},
+		{pattern: "/listVans", summary: "Every registered van", permissions: []permission{manageVansPermission}, reply: []Van{}, handler: s.listVans},
+		{pattern: "/createVan", summary: "Register a van on every instance", permissions: []permission{manageVansPermission}, parameters: append([]endpointParameter{
+			{"vanId", "integer", "", true, "Not used by another van"},
+			{"callsign", "string", "", true, "At most 32 characters"},
+		}, vanParameters...), handler: s.createVan},
+		{pattern: "/updateVan", summary: "Change a registered van on every instance", permissions: []permission{manageVansPermission}, parameters: append([]endpointParameter{
+			{"vanId", "integer", "", true, ""},
+			{"callsign", "string", "", false, "At most 32 characters"},
+		}, vanParameters...), handler: s.updateVan},
+		{pattern: "/getConfig", summary: "Current configuration values", permissions: []permission{manageConfigPermission}, reply: map[string]float64{}, handler: s.getConfig},
+		{pattern: "/setConfig", summary: "Change a configuration value on every instance", permissions: []permission{manageConfigPermission}, parameters: []endpointParameter{
+			{"key", "string", "", true, ""},
+			{"value", "number", "double", true, "Not negative"},
+		}, handler: s.setConfig},
+
+		//test functions
+		{pattern: "/asyncTest", summary: "Reply slowly, for testing clients", contentType: "text/plain", reply: "", public: asyncTest},
+	}
+}
+
+//Routes of the versioned JSON API. The legacy routes keep working next to them for old app versions.
+func (s *Server) apiEndpoints() []endpoint {
+	return []endpoint{
+		{method: "POST", pattern: "/api/v1/pickups", summary: "Request a pickup for the rider's phone number", permissions: []permission{createPickupPermission}, async: true, body: apiLocationBody{}, status: http.StatusCreated, reply: pickupInfo{}, handler: s.apiCreatePickup},
+		{method: "GET", pattern: "/api/v1/pickups", summary: "Current pickups, oldest first", permissions: []permission{listPickupsPermission}, reply: []Pickup{}, handler: s.apiListPickups},
+		{method: "GET", pattern: "/api/v1/pickups/{phoneNumber}", summary: "Pickup with its van, arrival estimate and queue position", permissions: []permission{viewOwnPickupPermission, viewAnyPickupPermission}, reply: pickupInfo{}, handler: s.apiGetPickup},
+		{method: "PATCH", pattern: "/api/v1/pickups/{phoneNumber}", summary: "Drivers change the pickup's status, the rider reports their location", permissions: []permission{locateOwnPickupPermission, confirmPickupPermission, progressPickupPermission, completePickupPermission}, async: true, body: apiPickupChange{}, reply: pickupInfo{}, handler: s.apiUpdatePickup},
+		{method: "DELETE", pattern: "/api/v1/pickups/{phoneNumber}", summary: "Cancel the pickup", permissions: []permission{cancelOwnPickupPermission, cancelAnyPickupPermission}, async: true, reply: Pickup{}, handler: s.apiCancelPickup},
+		{method: "GET", pattern: "/api/v1/pickups/{phoneNumber}/events", summary: "Timeline of the pickup", permissions: []permission{viewOwnPickupPermission, viewAnyPickupPermission}, parameters: []endpointParameter{
+			{"initialTime", "string", "date-time", false, "Initial time of a past pickup, staff only"},
+		}, reply: []PickupEvent{}, handler: s.apiGetPickupEvents},
+		{method: "PUT", pattern: "/api/v1/pickups/{phoneNumber}/van", summary: "Dispatchers assign the pickup to a van, drivers claim it for their own van", permissions: []permission{claimPickupPermission, reassignPickupPermission}, body: apiAssignmentBody{}, reply: pickupInfo{}, handler: s.apiAssignPickup},
+		{method: "DELETE", pattern: "/api/v1/pickups/{phoneNumber}/van", summary: "Release the pickup from its van", permissions: []permission{claimPickupPermission, reassignPickupPermission}, reply: pickupInfo{}, handler: s.apiUnassignPickup},
+		{method: "GET", pattern: "/api/v1/vans", summary: "Vans that have reported recently", reply: []apiVanLocation{}, public: s.apiListVans},
+		{method: "PUT", pattern: "/api/v1/vans/{vanId}/location", summary: "Report a van's location", permissions: []permission{updateOwnVanPermission, updateAnyVanPermission}, body: apiLocationBody{}, reply: apiVanLocation{}, handler: s.apiUpdateVanLocation},
+		{method: "GET", pattern: "/api/v1/vans/{vanId}/route", summary: "Stop order for the van", permissions: []permission{listPickupsPermission}, reply: VanRoute{}, handler: s.apiGetVanRoute},
+		{method: "GET", pattern: "/api/v1/requests/{requestId}", summary: "State of an async write", permissions: []permission{viewOwnPickupPermission, viewAnyPickupPermission}, reply: queuedWrite{}, handler: s.apiGetRequestStatus},
+	}
+}
+
+//Handler of a legacy route, behind the session and permission checks it declares
+func (s *Server) legacyHandler(e endpoint) http.HandlerFunc {
+	switch {
+	case e.handler == nil:
+		return e.public
+	case len(e.permissions) == 0:
+		return s.withSession(e.handler)
+	default:
+		return s.authorize(e.handler, e.permissions...)
+	}
+}
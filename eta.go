@@ -0,0 +1,133 @@
+package shipmate
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+//Estimated arrival of a van at a pickup
+type pickupETA struct {
+	VanId       int       `json:"vanId"`
+	StopsAhead  int       `json:"stopsAhead"` //pickups the van serves first
+	DistanceKm  float64   `json:"distanceKm"`
+	SpeedKph    float64   `json:"speedKph"`
+	Minutes     float64   `json:"minutes"`
+	ArrivalTime time.Time `json:"arrivalTime"`
+}
+
+const vanSpeedSmoothing = 0.3
+const minimumMeasuredSpeedKph = 5   //slower vans are treated as parked and the configured speed is used
+const maximumMeasuredSpeedKph = 130 //faster readings are GPS jumps
+
+//Update the measured speed of a van from its previous and newly reported locations
+func (s *Server) recordVanSpeed(vanId int, previous Location, current Location) {
+	elapsed := current.latestTime.Sub(previous.latestTime)
+	if (previous.latestTime == time.Time{}) || elapsed < 5*time.Second || elapsed > 5*time.Minute {
+		return
+	}
+
+	speed := haversineKm(previous, current) / elapsed.Hours()
+	if speed > maximumMeasuredSpeedKph {
+		return
+	}
+
+	s.vanSpeedsLock.Lock()
+	defer s.vanSpeedsLock.Unlock()
+
+	if measured, exist := s.vanSpeeds[vanId]; exist {
+		s.vanSpeeds[vanId] = measured + vanSpeedSmoothing*(speed-measured)
+	} else {
+		s.vanSpeeds[vanId] = speed
+	}
+}
+
+//Speed used for a van's estimates. The measured speed is used when etaUseMeasuredSpeed is 1 and the van is moving.
+func (s *Server) vanSpeedKph(vanId int) float64 {
+	if s.configValue("etaUseMeasuredSpeed") == 1 {
+		s.vanSpeedsLock.Lock()
+		measured, exist := s.vanSpeeds[vanId]
+		s.vanSpeedsLock.Unlock()
+
+		if exist && measured >= minimumMeasuredSpeedKph {
+			return measured
+		}
+	}
+	return s.configValue("vanAverageSpeedKph")
+}
+
+//Position of a pending pickup among all pickups waiting for a van, oldest first starting at 1. Caller must hold pickupsLock.
+func (s *Server) pickupQueuePosition(targetPickup Pickup) int {
+	if targetPickup.Status != pending {
+		return 0
+	}
+
+	waiting := make([]Pickup, 0)
+	for _, v := range s.pickups {
+		if v.Status == pending && !isPickupAbandoned(v) {
+			waiting = append(waiting, v)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		return waiting[i].InitialTime.Before(waiting[j].InitialTime)
+	})
+
+	for i, v := range waiting {
+		if v.PhoneNumber == targetPickup.PhoneNumber {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+//Estimate when a van reaches a pickup, using the assigned van, else the suggested van, else the best van dispatch would choose. Returns nil if the van already arrived or no van is reporting. Caller must hold pickupsLock.
+func (s *Server) estimatePickupArrival(targetPickup Pickup) *pickupETA {
+	if !targetPickup.Status.isActive() || targetPickup.Status == arrived {
+		return nil
+	}
+
+	vanId := targetPickup.VanId
+	if vanId == 0 {
+		vanId = targetPickup.SuggestedVanId
+	}
+	if vanId == 0 {
+		if scores := s.rankVansForPickup(targetPickup, nil); len(scores) > 0 {
+			vanId = scores[0].VanId
+		}
+	}
+
+	vanLocation := s.currentVanLocation(vanId)
+	if vanLocation == nil || !s.isVanActive(*vanLocation, s.clock()) {
+		return nil
+	}
+
+	eta := pickupETA{VanId: vanId, SpeedKph: s.vanSpeedKph(vanId)}
+	if eta.SpeedKph <= 0 {
+		return nil
+	}
+	route := s.currentVanRoute(vanId)
+
+	//the route was planned from where the van was at the time, so measure from where it is now to the first stop
+	var stopsKm float64
+	var lastStop = *vanLocation
+	eta.StopsAhead = len(route.Stops)
+	for i, v := range route.Stops {
+		if v.PhoneNumber == targetPickup.PhoneNumber {
+			eta.StopsAhead = i
+			break
+		}
+		stopsKm = v.TotalKm - route.Stops[0].TotalKm
+		lastStop = v.Location
+	}
+
+	if eta.StopsAhead == 0 {
+		eta.DistanceKm = haversineKm(*vanLocation, targetPickup.LatestLocation)
+	} else {
+		eta.DistanceKm = haversineKm(*vanLocation, route.Stops[0].Location) + stopsKm + haversineKm(lastStop, targetPickup.LatestLocation)
+	}
+
+	eta.Minutes = eta.DistanceKm/eta.SpeedKph*60 + float64(eta.StopsAhead)*s.configValue("etaStopMinutes")
+	eta.Minutes = math.Round(eta.Minutes*10) / 10
+	eta.ArrivalTime = s.clock().Add(time.Duration(eta.Minutes * float64(time.Minute))).Round(time.Minute)
+	return &eta
+}
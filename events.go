@@ -0,0 +1,165 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//Pickup event types
+const createdEvent string = "created"
+const locationEvent string = "location"
+const statusEvent string = "status"
+const timeoutEvent string = "timeout"
+const assignmentEvent string = "assignment"
+const suggestionEvent string = "suggestion"
+const declineEvent string = "decline"
+
+//One entry in a pickup's timeline. A pickup is identified by its phone number and initial time.
+type PickupEvent struct {
+	PhoneNumber string       `json:"phoneNumber"`
+	InitialTime time.Time    `json:"initialTime"`
+	Type        string       `json:"type"`
+	Status      PickupStatus `json:"status"`
+	Location    Location     `json:"location"`
+	Actor       string       `json:"actor"`
+	VanId       int          `json:"vanId"` //assigned van, or the van suggested or declining for suggestion and decline events
+	Time        time.Time    `json:"time"`
+}
+
+//INSERT event row in pickup_events table for the pickup's assigned van. Rows are never updated or deleted.
+func (s *Server) databaseInsertPickupEvent(targetPickup Pickup, eventType string, actor string, eventTime time.Time) bool {
+	return s.databaseInsertPickupVanEvent(targetPickup, eventType, targetPickup.VanId, actor, eventTime)
+}
+
+//INSERT event row in pickup_events table about a van other than the one assigned
+func (s *Server) databaseInsertPickupVanEvent(targetPickup Pickup, eventType string, vanId int, actor string, eventTime time.Time) bool {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if _, err := s.db.Exec(`INSERT INTO pickup_events (PhoneNumber, InitialTime, EventType, Status, Latitude, Longitude, Actor, EventTime, VanId)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`, targetPickup.PhoneNumber, targetPickup.InitialTime, eventType, targetPickup.Status, targetPickup.LatestLocation.Latitude, targetPickup.LatestLocation.Longitude, actor, eventTime, vanId); err != nil {
+			s.logger.Println(err)
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+//SELECT events of one pickup in the order they happened
+func (s *Server) selectPickupEvents(targetPhoneNumber string, targetInitialTime time.Time) []PickupEvent {
+	events := make([]PickupEvent, 0)
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return events
+	}
+
+	rows, err := s.db.Query(`SELECT PhoneNumber, InitialTime, EventType, Status, Latitude, Longitude, Actor, EventTime, VanId
+		FROM pickup_events
+		WHERE PhoneNumber = $1 AND InitialTime = $2
+		ORDER BY EventId;`, targetPhoneNumber, targetInitialTime)
+	if err != nil {
+		s.logger.Println(err)
+		return events
+	}
+	for rows.Next() {
+		var tmpEvent PickupEvent
+		if err := rows.Scan(&tmpEvent.PhoneNumber, &tmpEvent.InitialTime, &tmpEvent.Type, &tmpEvent.Status, &tmpEvent.Location.Latitude, &tmpEvent.Location.Longitude, &tmpEvent.Actor, &tmpEvent.Time, &tmpEvent.VanId); err != nil {
+			s.logger.Println(err)
+			continue
+		}
+		events = append(events, tmpEvent)
+	}
+	rows.Close()
+	return events
+}
+
+//SELECT vans that declined a pickup so dispatch does not offer it to them again
+func (s *Server) selectDeclinedVanIds(targetPickup Pickup) map[int]bool {
+	vanIds := make(map[int]bool)
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return vanIds
+	}
+
+	rows, err := s.db.Query(`SELECT DISTINCT VanId
+		FROM pickup_events
+		WHERE PhoneNumber = $1 AND InitialTime = $2 AND EventType = $3;`, targetPickup.PhoneNumber, targetPickup.InitialTime, declineEvent)
+	if err != nil {
+		s.logger.Println(err)
+		return vanIds
+	}
+	for rows.Next() {
+		var vanId int
+		if err := rows.Scan(&vanId); err != nil {
+			s.logger.Println(err)
+			continue
+		}
+		vanIds[vanId] = true
+	}
+	rows.Close()
+	return vanIds
+}
+
+//Reply with the timeline of the current pickup for a phone number. Staff may pass "initialTime" (RFC 3339) to fetch a past pickup.
+func (s *Server) getPickupEvents(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("getPickupEvents()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var number string
+	var initialTime time.Time
+
+	//staff may view any pickup, riders only the pickup for their own phone number
+	var v validator
+	viewingOwnPickup := !hasPermission(session.Role, viewAnyPickupPermission)
+	if viewingOwnPickup {
+		number = session.PhoneNumber
+	} else {
+		number = v.formPhoneNumber(r.Form)
+		if value, exist := formValue(r.Form, "initialTime"); exist {
+			initialTime = v.time("initialTime", value)
+		}
+	}
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	events, err := s.pickupEventsFor(session, number, initialTime)
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	if output, err := json.Marshal(events); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
+
+//Timeline of a pickup for a phone number, the current pickup if initialTime is zero. Riders may only see their own current pickup.
+func (s *Server) pickupEventsFor(session Session, number string, initialTime time.Time) ([]PickupEvent, error) {
+	viewingOwnPickup := !hasPermission(session.Role, viewAnyPickupPermission)
+	if viewingOwnPickup && (number != session.PhoneNumber || initialTime != time.Time{}) {
+		return nil, apiErrorf(http.StatusForbidden, forbiddenCode, "riders may only view their own current pickup")
+	}
+
+	if (initialTime == time.Time{}) {
+		s.pickupsLock.RLock()
+		tmp, exist := s.pickups[number]
+		s.pickupsLock.RUnlock()
+
+		if !exist || (viewingOwnPickup && tmp.devicePhrase != "" && tmp.devicePhrase != session.DeviceId) {
+			return nil, apiErrorf(http.StatusNotFound, notFoundCode, "no pickup for %v", number)
+		}
+		initialTime = tmp.InitialTime
+	}
+
+	return s.selectPickupEvents(number, initialTime), nil
+}
@@ -0,0 +1,415 @@
+package shipmate
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ansonl/shipmate/shipmatepb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Open streams check their session this often, and before each update or fix
+const grpcSessionCheckInterval = time.Duration(30) * time.Second
+
+//gRPC status of each HTTP status the core functions fail with. Codes in grpcErrorCodes take precedence.
+var grpcStatusCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.FailedPrecondition,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+var grpcErrorCodes = map[string]codes.Code{
+	pickupExistsCode: codes.AlreadyExists,
+	stalePickupCode:  codes.Aborted,
+}
+
+//The Shipmate gRPC service for driver tablets. Every call goes through the same core functions as the HTTP routes, so pickups and vans change the same way whichever protocol a client speaks.
+type grpcService struct {
+	shipmatepb.UnimplementedShipmateServer
+	s *Server
+}
+
+//gRPC server with the Shipmate service registered, served by ServeGRPC
+func (s *Server) newGRPCServer() *grpc.Server {
+	tmp := grpc.NewServer()
+	shipmatepb.RegisterShipmateServer(tmp, &grpcService{s: s})
+	return tmp
+}
+
+//Serve the gRPC service on listener until it fails or the Server is closed
+func (s *Server) ServeGRPC(listener net.Listener) error {
+	return s.grpc.Serve(listener)
+}
+
+//Serve the gRPC service on addr, a separate port from the HTTP routes, until the listener fails or the Server is closed
+func (s *Server) ListenAndServeGRPC(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.logger.Println("Serving gRPC on " + addr)
+	return s.ServeGRPC(listener)
+}
+
+//Status for an error from the core functions. The apiError code is sent as the reason of an ErrorInfo detail and invalid parameters as a BadRequest detail, so gRPC clients act on the same codes as /api/v1 clients.
+func grpcError(err error) error {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = apiErrorf(http.StatusInternalServerError, internalErrorCode, "request failed")
+	}
+
+	code, exist := grpcErrorCodes[e.Code]
+	if !exist {
+		if code, exist = grpcStatusCodes[e.status]; !exist {
+			code = codes.Unknown
+		}
+	}
+
+	tmp := status.New(code, e.Message)
+	if withDetails, err := tmp.WithDetails(&errdetails.ErrorInfo{Reason: e.Code, Domain: "shipmate"}); err == nil {
+		tmp = withDetails
+	}
+	if len(e.Fields) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, v := range e.Fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: v.Field, Description: v.Message})
+		}
+		if withDetails, err := tmp.WithDetails(badRequest); err == nil {
+			tmp = withDetails
+		}
+	}
+	return tmp.Err()
+}
+
+//Session of the access token sent as "authorization: Bearer <accessToken>" metadata, if its role holds at least one of the permissions
+func (g *grpcService) authorize(ctx context.Context, permissions ...permission) (Session, error) {
+	var token string
+	if md, exist := metadata.FromIncomingContext(ctx); exist {
+		if values := md.Get("authorization"); len(values) > 0 && len(values[0]) > 7 && strings.EqualFold(values[0][:7], "Bearer ") {
+			token = strings.TrimSpace(values[0][7:])
+		}
+	}
+
+	session, err := g.s.sessionFromToken(token)
+	if err != nil {
+		return Session{}, err
+	}
+	for _, v := range permissions {
+		if hasPermission(session.Role, v) {
+			return session, nil
+		}
+	}
+	method, _ := grpc.Method(ctx)
+	return Session{}, apiErrorf(http.StatusForbidden, forbiddenCode, "role %v may not call %v", session.Role, method)
+}
+
+//Location in a request. Latitude and longitude are required, heading is -1 if it is left out.
+func locationFromProto(field string, l *shipmatepb.Location) (Location, error) {
+	var v validator
+	if l == nil {
+		v.addError(field, "is required")
+		return Location{}, v.err()
+	}
+
+	location := Location{Latitude: v.checkLatitude(field+".latitude", l.Latitude), Longitude: v.checkLongitude(field+".longitude", l.Longitude), Heading: -1}
+	if l.Heading != nil {
+		location.Heading = v.checkHeading(field+".heading", *l.Heading)
+	}
+	return location, v.err()
+}
+
+//Phone number of a request. Riders may leave it out for their own pickup, staff with permission must send it.
+func requestPhoneNumber(session Session, number string, anyPermission permission) (string, error) {
+	if number == "" && !hasPermission(session.Role, anyPermission) {
+		return session.PhoneNumber, nil
+	}
+
+	var v validator
+	if number == "" {
+		v.addError("phone_number", "is required")
+	} else {
+		v.phoneNumber("phone_number", number)
+	}
+	return number, v.err()
+}
+
+func locationProto(l Location) *shipmatepb.Location {
+	heading := l.Heading
+	return &shipmatepb.Location{Latitude: l.Latitude, Longitude: l.Longitude, Heading: &heading}
+}
+
+//Unset for times that have not happened yet
+func timestampProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func pickupProto(p Pickup) *shipmatepb.Pickup {
+	return &shipmatepb.Pickup{
+		PhoneNumber:      p.PhoneNumber,
+		InitialLocation:  locationProto(p.InitialLocation),
+		InitialTime:      timestampProto(p.InitialTime),
+		LatestLocation:   locationProto(p.LatestLocation),
+		LatestTime:       timestampProto(p.LatestTime),
+		ConfirmTime:      timestampProto(p.ConfirmTime),
+		CompleteTime:     timestampProto(p.CompleteTime),
+		Status:           shipmatepb.PickupStatus(p.Status),
+		ConfirmDriverId:  int32(p.ConfirmDriverId),
+		CompleteDriverId: int32(p.CompleteDriverId),
+		StatusActor:      p.StatusActor,
+		StatusTime:       timestampProto(p.StatusTime),
+		VanId:            int32(p.VanId),
+		DriverId:         int32(p.DriverId),
+		SuggestedVanId:   int32(p.SuggestedVanId),
+	}
+}
+
+func pickupInfoProto(info pickupInfo) *shipmatepb.PickupInfo {
+	tmp := &shipmatepb.PickupInfo{Pickup: pickupProto(info.Pickup), QueuePosition: int32(info.QueuePosition)}
+	if info.VanLocation != nil {
+		tmp.VanLocation = locationProto(*info.VanLocation)
+	}
+	if info.ETA != nil {
+		tmp.Eta = &shipmatepb.PickupEta{
+			VanId:       int32(info.ETA.VanId),
+			StopsAhead:  int32(info.ETA.StopsAhead),
+			DistanceKm:  info.ETA.DistanceKm,
+			SpeedKph:    info.ETA.SpeedKph,
+			Minutes:     info.ETA.Minutes,
+			ArrivalTime: timestampProto(info.ETA.ArrivalTime),
+		}
+	}
+	return tmp
+}
+
+func (g *grpcService) CreatePickup(ctx context.Context, request *shipmatepb.CreatePickupRequest) (*shipmatepb.PickupInfo, error) {
+	session, err := g.authorize(ctx, createPickupPermission)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	location, err := locationFromProto("location", request.Location)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	tmp, _, err := g.s.createPickup(session, location, false)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pickupInfoProto(g.s.apiPickupInfo(tmp)), nil
+}
+
+func (g *grpcService) GetPickup(ctx context.Context, request *shipmatepb.GetPickupRequest) (*shipmatepb.PickupInfo, error) {
+	session, err := g.authorize(ctx, viewOwnPickupPermission, viewAnyPickupPermission)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	number, err := requestPhoneNumber(session, request.PhoneNumber, viewAnyPickupPermission)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	info, err := g.s.viewPickup(session, number)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pickupInfoProto(info), nil
+}
+
+func (g *grpcService) CancelPickup(ctx context.Context, request *shipmatepb.CancelPickupRequest) (*shipmatepb.Pickup, error) {
+	session, err := g.authorize(ctx, cancelOwnPickupPermission, cancelAnyPickupPermission)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	number, err := requestPhoneNumber(session, request.PhoneNumber, cancelAnyPickupPermission)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	tmp, _, err := g.s.cancelPickupFor(session, number, false)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pickupProto(tmp), nil
+}
+
+//Move a pickup to a status a driver sets
+func (g *grpcService) setPickupStatus(ctx context.Context, number string, to PickupStatus) (*shipmatepb.PickupInfo, error) {
+	session, err := g.authorize(ctx, apiStatusPermissions[to])
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	var v validator
+	if number == "" {
+		v.addError("phone_number", "is required")
+	} else {
+		v.phoneNumber("phone_number", number)
+	}
+	if err := v.err(); err != nil {
+		return nil, grpcError(err)
+	}
+
+	tmp, _, err := g.s.setPickupStatus(session, number, to, false)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pickupInfoProto(g.s.apiPickupInfo(tmp)), nil
+}
+
+func (g *grpcService) ConfirmPickup(ctx context.Context, request *shipmatepb.ConfirmPickupRequest) (*shipmatepb.PickupInfo, error) {
+	return g.setPickupStatus(ctx, request.PhoneNumber, confirmed)
+}
+
+func (g *grpcService) CompletePickup(ctx context.Context, request *shipmatepb.CompletePickupRequest) (*shipmatepb.PickupInfo, error) {
+	return g.setPickupStatus(ctx, request.PhoneNumber, completed)
+}
+
+//Pickup board as a stream of pickup updates. The stream registers as a board client, so it sees the same snapshot and changes as /pickupBoard, filtered to one van if the request names it. It ends with Unauthenticated once the session expires or is revoked.
+func (g *grpcService) StreamPickups(request *shipmatepb.StreamPickupsRequest, stream shipmatepb.Shipmate_StreamPickupsServer) error {
+	session, err := g.authorize(stream.Context(), listPickupsPermission)
+	if err != nil {
+		return grpcError(err)
+	}
+
+	vanId := int(request.VanId)
+	if vanId != 0 {
+		var v validator
+		v.checkVanId("van_id", vanId)
+		if err := v.err(); err != nil {
+			return grpcError(err)
+		}
+		if _, registered := g.s.vans.get(vanId); !registered {
+			return grpcError(apiErrorf(http.StatusNotFound, unknownVanCode, "no van %v is registered", vanId))
+		}
+	}
+
+	client := g.s.addBoardClient(session)
+	if client == nil {
+		return grpcError(apiErrorf(http.StatusInternalServerError, internalErrorCode, "board snapshot could not be encoded"))
+	}
+	defer g.s.removeBoardClient(client)
+
+	sessionCheck := time.NewTicker(grpcSessionCheckInterval)
+	defer sessionCheck.Stop()
+
+	sent := make(map[string]bool) //phone numbers this stream has been sent and not told were removed
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-sessionCheck.C:
+			if err := g.s.checkSessionCurrent(session); err != nil {
+				return grpcError(err)
+			}
+		case output, open := <-client.send:
+			if !open {
+				return status.Error(codes.ResourceExhausted, "dropped for falling behind, open the stream again")
+			}
+			if err := g.s.checkSessionCurrent(session); err != nil {
+				return grpcError(err)
+			}
+
+			var message boardMessage
+			if err := json.Unmarshal(output, &message); err != nil {
+				g.s.logger.Println(err)
+				continue
+			}
+			for _, v := range pickupUpdates(message, vanId, sent) {
+				if err := stream.Send(v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+//Updates for a stream following vanId, or every van if it is 0, from a board message. sent is updated with the phone numbers the stream has been sent.
+func pickupUpdates(message boardMessage, vanId int, sent map[string]bool) []*shipmatepb.PickupUpdate {
+	var pickups []Pickup
+	switch message.Type {
+	case boardSnapshot:
+		for _, v := range message.Pickups {
+			pickups = append(pickups, v)
+		}
+		sort.Slice(pickups, func(i, j int) bool {
+			return pickups[i].InitialTime.Before(pickups[j].InitialTime)
+		})
+	case boardPickupAdded, boardPickupUpdated:
+		pickups = append(pickups, *message.Pickup)
+	case boardPickupRemoved:
+		if !sent[message.PhoneNumber] {
+			return nil
+		}
+		delete(sent, message.PhoneNumber)
+		return []*shipmatepb.PickupUpdate{{Type: shipmatepb.PickupUpdate_TYPE_REMOVED, PhoneNumber: message.PhoneNumber, Time: timestampProto(message.Time)}}
+	}
+
+	var updates []*shipmatepb.PickupUpdate
+	for _, v := range pickups {
+		following := vanId == 0 || v.VanId == vanId || v.SuggestedVanId == vanId
+		switch {
+		case following && sent[v.PhoneNumber]:
+			updates = append(updates, &shipmatepb.PickupUpdate{Type: shipmatepb.PickupUpdate_TYPE_UPDATED, PhoneNumber: v.PhoneNumber, Pickup: pickupProto(v), Time: timestampProto(message.Time)})
+		case following:
+			sent[v.PhoneNumber] = true
+			updates = append(updates, &shipmatepb.PickupUpdate{Type: shipmatepb.PickupUpdate_TYPE_ADDED, PhoneNumber: v.PhoneNumber, Pickup: pickupProto(v), Time: timestampProto(message.Time)})
+		case sent[v.PhoneNumber]:
+			//moved to another van
+			delete(sent, v.PhoneNumber)
+			updates = append(updates, &shipmatepb.PickupUpdate{Type: shipmatepb.PickupUpdate_TYPE_REMOVED, PhoneNumber: v.PhoneNumber, Time: timestampProto(message.Time)})
+		}
+	}
+	return updates
+}
+
+//Record each GPS fix a tablet sends, like PUT /api/v1/vans/{vanId}/location. The session is checked when the stream opens and again for every fix.
+func (g *grpcService) ReportVanLocations(stream shipmatepb.Shipmate_ReportVanLocationsServer) error {
+	session, err := g.authorize(stream.Context(), updateOwnVanPermission, updateAnyVanPermission)
+	if err != nil {
+		return grpcError(err)
+	}
+
+	reply := &shipmatepb.ReportVanLocationsReply{}
+	for {
+		fix, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(reply)
+		} else if err != nil {
+			return err
+		}
+
+		if err := g.s.checkSessionCurrent(session); err != nil {
+			return grpcError(err)
+		}
+		location, err := locationFromProto("location", fix.Location)
+		if err != nil {
+			return grpcError(err)
+		}
+		if location, err = g.s.reportVanLocation(session, int(fix.VanId), location); err != nil {
+			return grpcError(err)
+		}
+
+		reply.Locations++
+		reply.LatestLocation = locationProto(location)
+	}
+}
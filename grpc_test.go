@@ -0,0 +1,399 @@
+package shipmate
+
+import (
+	"context"
+	"github.com/ansonl/shipmate/shipmatepb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+//Client of the test server's gRPC service over an in-memory connection. The connection is closed when the test ends.
+func (ts *testServer) grpcClient() shipmatepb.ShipmateClient {
+	ts.t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	go ts.server.ServeGRPC(listener)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	ts.t.Cleanup(func() {
+		conn.Close()
+	})
+	return shipmatepb.NewShipmateClient(conn)
+}
+
+//Context of a call sending token as its access token, canceled when the test ends. An empty token sends none.
+func grpcContext(t *testing.T, token string) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	if token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+//Reason of the ErrorInfo detail of a call's error, which is the /api/v1 error code
+func grpcErrorReason(err error) string {
+	for _, v := range status.Convert(err).Details() {
+		if info, ok := v.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func grpcLocation(latitude float64, longitude float64) *shipmatepb.Location {
+	return &shipmatepb.Location{Latitude: latitude, Longitude: longitude}
+}
+
+func TestGRPCPickupLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+	client := ts.grpcClient()
+	rider := grpcContext(t, ts.riderToken(testRider, testDevice))
+	driver := grpcContext(t, ts.driverToken(3, 2))
+
+	created, err := client.CreatePickup(rider, &shipmatepb.CreatePickupRequest{Location: grpcLocation(38.98, -76.48)})
+	if err != nil || created.Pickup.Status != shipmatepb.PickupStatus_PICKUP_STATUS_PENDING || created.Pickup.PhoneNumber != testRider {
+		t.Fatalf("CreatePickup replied %v, %v", created, err)
+	}
+
+	stream, err := client.StreamPickups(driver, &shipmatepb.StreamPickupsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update, err := stream.Recv(); err != nil || update.Type != shipmatepb.PickupUpdate_TYPE_ADDED || update.Pickup.Status != shipmatepb.PickupStatus_PICKUP_STATUS_PENDING {
+		t.Fatalf("first update %v, %v, want the pending pickup added", update, err)
+	}
+
+	fixes, err := client.ReportVanLocations(driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []*shipmatepb.Location{grpcLocation(38.96, -76.46), grpcLocation(38.97, -76.47)} {
+		if err := fixes.Send(&shipmatepb.VanLocation{VanId: 2, Location: v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply, err := fixes.CloseAndRecv(); err != nil || reply.Locations != 2 || reply.LatestLocation.Latitude != 38.97 || reply.LatestLocation.GetHeading() != -1 {
+		t.Fatalf("ReportVanLocations replied %v, %v", reply, err)
+	}
+	if tmp, exist := ts.server.vanLocations.get(2); !exist || tmp.Latitude != 38.97 {
+		t.Errorf("van 2 is at %+v, want the last fix", tmp)
+	}
+
+	if info, err := client.ConfirmPickup(driver, &shipmatepb.ConfirmPickupRequest{PhoneNumber: testRider}); err != nil || info.Pickup.Status != shipmatepb.PickupStatus_PICKUP_STATUS_CONFIRMED || info.Pickup.VanId != 2 {
+		t.Fatalf("ConfirmPickup replied %v, %v", info, err)
+	}
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if update.Type == shipmatepb.PickupUpdate_TYPE_UPDATED && update.Pickup.Status == shipmatepb.PickupStatus_PICKUP_STATUS_CONFIRMED {
+			break
+		}
+	}
+
+	//the rider sees the driver's change over gRPC and HTTP
+	if info, err := client.GetPickup(rider, &shipmatepb.GetPickupRequest{}); err != nil || info.Pickup.Status != shipmatepb.PickupStatus_PICKUP_STATUS_CONFIRMED || info.VanLocation == nil {
+		t.Fatalf("GetPickup replied %v, %v", info, err)
+	}
+	if tmp, ok := decodePickup(ts.request(ts.riderToken(testRider, testDevice), "/getPickupInfo", url.Values{})); !ok || tmp.Status != confirmed {
+		t.Errorf("legacy getPickupInfo replied %+v, want the confirmed pickup", tmp)
+	}
+
+	if info, err := client.CompletePickup(driver, &shipmatepb.CompletePickupRequest{PhoneNumber: testRider}); err != nil || info.Pickup.Status != shipmatepb.PickupStatus_PICKUP_STATUS_COMPLETED || info.Pickup.CompleteTime == nil {
+		t.Fatalf("CompletePickup replied %v, %v", info, err)
+	}
+	if tmp, _ := ts.storedPickup(testRider); tmp.Status != completed {
+		t.Errorf("stored pickup is %v, want completed", tmp.Status)
+	}
+}
+
+func TestGRPCCancelPickup(t *testing.T) {
+	ts := newTestServer(t)
+	client := ts.grpcClient()
+	ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+
+	tmp, err := client.CancelPickup(grpcContext(t, ts.riderToken(testRider, testDevice)), &shipmatepb.CancelPickupRequest{})
+	if err != nil || tmp.Status != shipmatepb.PickupStatus_PICKUP_STATUS_CANCELED {
+		t.Fatalf("CancelPickup replied %v, %v", tmp, err)
+	}
+	if _, exist := ts.memoryPickup(testRider); exist {
+		t.Error("canceled pickup still in memory")
+	}
+	if past := ts.store.ListPastPickups(); len(past) != 1 || past[0].Status != canceled {
+		t.Errorf("past pickups are %+v, want one canceled pickup", past)
+	}
+}
+
+//Streams opened with a session end with Unauthenticated once the session expires
+func TestGRPCStreamsEndWithSession(t *testing.T) {
+	ts := newTestServer(t)
+	client := ts.grpcClient()
+	driver := grpcContext(t, ts.driverToken(3, 2))
+	ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+
+	stream, err := client.StreamPickups(driver, &shipmatepb.StreamPickupsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	fixes, err := client.ReportVanLocations(driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.clock.Advance(accessTokenLifetime + time.Second)
+	ts.newPickup(testOtherRider, testOtherDevice, "38.98", "-76.48")
+
+	if update, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("StreamPickups sent %v, %v after the session expired, want Unauthenticated", update, err)
+	}
+	fixes.Send(&shipmatepb.VanLocation{VanId: 2, Location: grpcLocation(38.97, -76.47)})
+	if reply, err := fixes.CloseAndRecv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ReportVanLocations replied %v, %v after the session expired, want Unauthenticated", reply, err)
+	}
+	if _, exist := ts.server.vanLocations.get(2); exist {
+		t.Error("fix sent after the session expired was recorded")
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	location := grpcLocation(38.98, -76.48)
+
+	tests := []struct {
+		name       string
+		setup      func(ts *testServer) string //returns the token to call with
+		call       func(ctx context.Context, client shipmatepb.ShipmateClient) error
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{"no token", func(ts *testServer) string {
+			return ""
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			_, err := client.CreatePickup(ctx, &shipmatepb.CreatePickupRequest{Location: location})
+			return err
+		}, codes.Unauthenticated, unauthorizedCode},
+		{"drivers may not create pickups", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			_, err := client.CreatePickup(ctx, &shipmatepb.CreatePickupRequest{Location: location})
+			return err
+		}, codes.PermissionDenied, forbiddenCode},
+		{"create without location", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			_, err := client.CreatePickup(ctx, &shipmatepb.CreatePickupRequest{})
+			return err
+		}, codes.InvalidArgument, invalidParameterCode},
+		{"pickup already pending", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testDevice)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			_, err := client.CreatePickup(ctx, &shipmatepb.CreatePickupRequest{Location: location})
+			return err
+		}, codes.AlreadyExists, pickupExistsCode},
+		{"staff get without phone number", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			_, err := client.GetPickup(ctx, &shipmatepb.GetPickupRequest{})
+			return err
+		}, codes.InvalidArgument, invalidParameterCode},
+		{"no pickup for phone number", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			_, err := client.GetPickup(ctx, &shipmatepb.GetPickupRequest{PhoneNumber: testRider})
+			return err
+		}, codes.NotFound, notFoundCode},
+		{"pickup cannot skip to completed", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(3, 2)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			_, err := client.CompletePickup(ctx, &shipmatepb.CompletePickupRequest{PhoneNumber: testRider})
+			return err
+		}, codes.FailedPrecondition, invalidTransitionCode},
+		{"riders may not stream every pickup", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			stream, err := client.StreamPickups(ctx, &shipmatepb.StreamPickupsRequest{})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.PermissionDenied, forbiddenCode},
+		{"stream a van id below 1", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			stream, err := client.StreamPickups(ctx, &shipmatepb.StreamPickupsRequest{VanId: -1})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.InvalidArgument, invalidParameterCode},
+		{"stream an unregistered van", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			stream, err := client.StreamPickups(ctx, &shipmatepb.StreamPickupsRequest{VanId: 9})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.NotFound, unknownVanCode},
+		{"driver reports another van", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			stream, err := client.ReportVanLocations(ctx)
+			if err != nil {
+				return err
+			}
+			stream.Send(&shipmatepb.VanLocation{VanId: 1, Location: location})
+			_, err = stream.CloseAndRecv()
+			return err
+		}, codes.PermissionDenied, vanNotAssignedCode},
+		{"fix past the pole", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, func(ctx context.Context, client shipmatepb.ShipmateClient) error {
+			stream, err := client.ReportVanLocations(ctx)
+			if err != nil {
+				return err
+			}
+			stream.Send(&shipmatepb.VanLocation{VanId: 2, Location: grpcLocation(91, -76.48)})
+			_, err = stream.CloseAndRecv()
+			return err
+		}, codes.InvalidArgument, invalidParameterCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			client := ts.grpcClient()
+
+			err := tt.call(grpcContext(t, tt.setup(ts)), client)
+			if status.Code(err) != tt.wantCode || grpcErrorReason(err) != tt.wantReason {
+				t.Fatalf("got %v with reason %q, want %v %q", err, grpcErrorReason(err), tt.wantCode, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestGRPCFieldErrors(t *testing.T) {
+	ts := newTestServer(t)
+	client := ts.grpcClient()
+
+	_, err := client.CreatePickup(grpcContext(t, ts.riderToken(testRider, testDevice)), &shipmatepb.CreatePickupRequest{Location: grpcLocation(91, -181)})
+
+	var got []string
+	for _, v := range status.Convert(err).Details() {
+		if badRequest, ok := v.(*errdetails.BadRequest); ok {
+			for _, f := range badRequest.FieldViolations {
+				got = append(got, f.Field)
+			}
+		}
+	}
+	if len(got) != 2 || got[0] != "location.latitude" || got[1] != "location.longitude" {
+		t.Errorf("got field violations %v, want location.latitude and location.longitude", got)
+	}
+}
+
+func TestPickupUpdatesFollowVan(t *testing.T) {
+	sent := make(map[string]bool)
+	snapshot := boardMessage{Type: boardSnapshot, Pickups: map[string]Pickup{
+		testRider:      {PhoneNumber: testRider, VanId: 2},
+		testOtherRider: {PhoneNumber: testOtherRider, VanId: 3},
+	}}
+
+	steps := []struct {
+		message boardMessage
+		want    []shipmatepb.PickupUpdate_Type
+	}{
+		{snapshot, []shipmatepb.PickupUpdate_Type{shipmatepb.PickupUpdate_TYPE_ADDED}},
+		{boardMessage{Type: boardPickupUpdated, PhoneNumber: testRider, Pickup: &Pickup{PhoneNumber: testRider, VanId: 2}}, []shipmatepb.PickupUpdate_Type{shipmatepb.PickupUpdate_TYPE_UPDATED}},
+		{boardMessage{Type: boardPickupUpdated, PhoneNumber: testOtherRider, Pickup: &Pickup{PhoneNumber: testOtherRider, SuggestedVanId: 2}}, []shipmatepb.PickupUpdate_Type{shipmatepb.PickupUpdate_TYPE_ADDED}},
+		{boardMessage{Type: boardPickupUpdated, PhoneNumber: testRider, Pickup: &Pickup{PhoneNumber: testRider, VanId: 4}}, []shipmatepb.PickupUpdate_Type{shipmatepb.PickupUpdate_TYPE_REMOVED}},
+		{boardMessage{Type: boardPickupRemoved, PhoneNumber: testRider}, nil},
+		{boardMessage{Type: boardPickupRemoved, PhoneNumber: testOtherRider}, []shipmatepb.PickupUpdate_Type{shipmatepb.PickupUpdate_TYPE_REMOVED}},
+	}
+
+	for i, step := range steps {
+		var got []shipmatepb.PickupUpdate_Type
+		for _, v := range pickupUpdates(step.message, 2, sent) {
+			got = append(got, v.Type)
+		}
+		if len(got) != len(step.want) || (len(got) > 0 && got[0] != step.want[0]) {
+			t.Fatalf("step %v got %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+//Tablets streaming fixes and following the board while riders and drivers change pickups over HTTP. Run with -race.
+func TestConcurrentGRPCTablets(t *testing.T) {
+	ts := newTestServer(t)
+	client := ts.grpcClient()
+
+	var wg sync.WaitGroup
+	for van := 1; van <= 3; van++ {
+		ctx, cancel := context.WithCancel(grpcContext(t, ts.driverToken(van, van)))
+		stream, err := client.StreamPickups(ctx, &shipmatepb.StreamPickupsRequest{VanId: int32(van)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := stream.Recv(); err != nil {
+					return
+				}
+			}
+		}()
+		go func(van int) {
+			defer wg.Done()
+			defer cancel()
+
+			fixes, err := client.ReportVanLocations(ctx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for j := 0; j < 20; j++ {
+				fixes.Send(&shipmatepb.VanLocation{VanId: int32(van), Location: grpcLocation(38.97+float64(j)/1000, -76.47)})
+			}
+			if _, err := fixes.CloseAndRecv(); err != nil {
+				t.Error(err)
+			}
+		}(van)
+	}
+
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(number string) {
+			defer wg.Done()
+			token := ts.riderToken(number, testDevice)
+			ts.request(token, "/newPickup", url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}})
+			ts.request(ts.driverToken(1, 1), "/confirmPickup", url.Values{"phoneNumber": {number}})
+			ts.request(token, "/cancelPickup", url.Values{})
+		}("410555030" + string(rune('0'+i)))
+	}
+	wg.Wait()
+}
@@ -0,0 +1,679 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testRider = "4105550101"
+const testOtherRider = "4105550102"
+const testDevice = "device-a"
+const testOtherDevice = "device-b"
+
+func TestNewPickup(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(ts *testServer) string //returns the token to request with
+		want  string                      //canned response, empty if a pending pickup is expected
+	}{
+		{"verified rider", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, ""},
+		{"device not verified", func(ts *testServer) string {
+			return ts.token(Session{Role: riderRole, PhoneNumber: testRider, DeviceId: testDevice})
+		}, wrongPasswordResponse},
+		{"no token", func(ts *testServer) string {
+			return ""
+		}, wrongPasswordResponse},
+		{"drivers may not request", func(ts *testServer) string {
+			return ts.driverToken(1, 1)
+		}, wrongPasswordResponse},
+		{"pickup already pending", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testDevice)
+		}, failResponse},
+		{"active pickup on another device", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testOtherDevice)
+		}, failResponse},
+		{"previous pickup completed", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			driver := ts.driverToken(1, 1)
+			ts.request(driver, "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+			ts.request(driver, "/completePickup", url.Values{"phoneNumber": {testRider}})
+			ts.clock.Advance(time.Minute)
+			return ts.riderToken(testRider, testDevice)
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := tt.setup(ts)
+
+			body := ts.request(token, "/newPickup", url.Values{"latitude": {"38.9844"}, "longitude": {"-76.4889"}})
+			if tt.want != "" {
+				if body != tt.want {
+					t.Fatalf("got %v, want %v", body, tt.want)
+				}
+				return
+			}
+
+			tmp, ok := decodePickup(body)
+			if !ok {
+				t.Fatalf("got %v, want a pickup", body)
+			}
+			if tmp.PhoneNumber != testRider || tmp.Status != pending {
+				t.Errorf("got pickup %v with status %v, want %v pending", tmp.PhoneNumber, tmp.Status, testRider)
+			}
+			if tmp.LatestLocation.Latitude != 38.9844 || tmp.LatestLocation.Longitude != -76.4889 {
+				t.Errorf("got location %v, want 38.9844,-76.4889", tmp.LatestLocation)
+			}
+			if !tmp.InitialTime.Equal(ts.clock.Now()) || tmp.StatusActor != "rider:"+testRider {
+				t.Errorf("got initial time %v by %v, want %v by rider:%v", tmp.InitialTime, tmp.StatusActor, ts.clock.Now(), testRider)
+			}
+
+			if current, exist := ts.memoryPickup(testRider); !exist || current.Status != pending || current.devicePhrase != testDevice {
+				t.Errorf("pickup in memory is %+v", current)
+			}
+			if stored, exist := ts.storedPickup(testRider); !exist || !stored.InitialTime.Equal(tmp.InitialTime) {
+				t.Errorf("pickup in store is %+v", stored)
+			}
+		})
+	}
+}
+
+func TestGetPickupInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(ts *testServer) string //returns the token to request with
+		parameters url.Values
+		want       string //canned response, empty if the rider's pickup is expected
+	}{
+		{"rider without pickup", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, url.Values{}, successResponse},
+		{"rider views own pickup", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testDevice)
+		}, url.Values{}, ""},
+		{"rider on another device", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testOtherDevice)
+		}, url.Values{}, wrongPasswordResponse},
+		{"riders only see their own pickup", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			ts.newPickup(testOtherRider, testDevice, "38.99", "-76.49")
+			return ts.riderToken(testRider, testDevice)
+		}, url.Values{"phoneNumber": {testOtherRider}}, ""},
+		{"driver views any pickup", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(1, 1)
+		}, url.Values{"phoneNumber": {testRider}}, ""},
+		{"driver without phone number", func(ts *testServer) string {
+			return ts.driverToken(1, 1)
+		}, url.Values{}, invalidParameterResponse("phoneNumber", "is required")},
+		{"no token", func(ts *testServer) string {
+			return ""
+		}, url.Values{}, wrongPasswordResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := tt.setup(ts)
+
+			body := ts.request(token, "/getPickupInfo", tt.parameters)
+			if tt.want != "" {
+				if body != tt.want {
+					t.Fatalf("got %v, want %v", body, tt.want)
+				}
+				return
+			}
+
+			tmp, ok := decodePickup(body)
+			if !ok || tmp.PhoneNumber != testRider || tmp.Status != pending {
+				t.Fatalf("got %v, want pending pickup for %v", body, testRider)
+			}
+		})
+	}
+}
+
+func TestGetPickupInfoRecordsRiderLocation(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+	ts.clock.Advance(time.Minute)
+
+	body := ts.request(ts.riderToken(testRider, testDevice), "/getPickupInfo", url.Values{"latitude": {"38.99"}, "longitude": {"-76.49"}})
+	if tmp, ok := decodePickup(body); !ok || tmp.LatestLocation.Latitude != 38.99 || tmp.LatestLocation.Longitude != -76.49 {
+		t.Fatalf("got %v, want latest location 38.99,-76.49", body)
+	}
+
+	stored, _ := ts.storedPickup(testRider)
+	if stored.LatestLocation.Latitude != 38.99 || !stored.LatestTime.Equal(ts.clock.Now()) {
+		t.Errorf("stored latest location %v at %v, want 38.99 at %v", stored.LatestLocation, stored.LatestTime, ts.clock.Now())
+	}
+	if stored.version != 0 {
+		t.Errorf("location update bumped version to %v", stored.version)
+	}
+}
+
+func TestCancelPickup(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(ts *testServer) string //returns the token to request with
+		parameters url.Values
+		want       string
+		canceled   bool //pickup expected to move to past pickups
+	}{
+		{"rider cancels own pickup", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testDevice)
+		}, url.Values{}, successResponse, true},
+		{"rider without pickup", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, url.Values{}, failResponse, false},
+		{"rider on another device", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testOtherDevice)
+		}, url.Values{}, wrongPasswordResponse, false},
+		{"dispatcher cancels any pickup", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.staffToken(dispatcherRole, 7)
+		}, url.Values{"phoneNumber": {testRider}}, successResponse, true},
+		{"dispatcher without phone number", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.staffToken(dispatcherRole, 7)
+		}, url.Values{}, invalidParameterResponse("phoneNumber", "is required"), false},
+		{"dispatcher with malformed phone number", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.staffToken(dispatcherRole, 7)
+		}, url.Values{"phoneNumber": {"410-555-0101"}}, invalidParameterResponse("phoneNumber", "must be a 10 digit phone number"), false},
+		{"drivers may not cancel", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(1, 1)
+		}, url.Values{"phoneNumber": {testRider}}, wrongPasswordResponse, false},
+		{"completed pickup", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			driver := ts.driverToken(1, 1)
+			ts.request(driver, "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+			ts.request(driver, "/completePickup", url.Values{"phoneNumber": {testRider}})
+			return ts.staffToken(dispatcherRole, 7)
+		}, url.Values{"phoneNumber": {testRider}}, failResponse, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := tt.setup(ts)
+			pastBefore := len(ts.store.ListPastPickups())
+
+			if body := ts.request(token, "/cancelPickup", tt.parameters); body != tt.want {
+				t.Fatalf("got %v, want %v", body, tt.want)
+			}
+
+			past := ts.store.ListPastPickups()
+			if !tt.canceled {
+				if len(past) != pastBefore {
+					t.Errorf("%v pickups moved to past pickups, want none", len(past)-pastBefore)
+				}
+				return
+			}
+
+			if _, exist := ts.memoryPickup(testRider); exist {
+				t.Error("canceled pickup still in memory")
+			}
+			if _, exist := ts.storedPickup(testRider); exist {
+				t.Error("canceled pickup still in the store")
+			}
+			if len(past) != 1 || past[0].Status != canceled || !past[0].CompleteTime.Equal(ts.clock.Now()) {
+				t.Errorf("past pickups are %+v, want one canceled pickup", past)
+			}
+		})
+	}
+}
+
+func TestConfirmPickup(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(ts *testServer) string //returns the token to request with
+		parameters url.Values
+		want       string
+	}{
+		{"driver confirms pending pickup", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(3, 2)
+		}, url.Values{"phoneNumber": {testRider}}, successResponse},
+		{"no pickup for phone number", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, url.Values{"phoneNumber": {testRider}}, failResponse},
+		{"missing phone number", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(3, 2)
+		}, url.Values{}, invalidParameterResponse("phoneNumber", "is required")},
+		{"pickup held by another van", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			ts.request(ts.driverToken(4, 1), "/claimPickup", url.Values{"phoneNumber": {testRider}})
+			return ts.driverToken(3, 2)
+		}, url.Values{"phoneNumber": {testRider}}, failResponse},
+		{"already confirmed", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			driver := ts.driverToken(3, 2)
+			ts.request(driver, "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+			return driver
+		}, url.Values{"phoneNumber": {testRider}}, failResponse},
+		{"riders may not confirm", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.riderToken(testRider, testDevice)
+		}, url.Values{"phoneNumber": {testRider}}, wrongPasswordResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := tt.setup(ts)
+			before, _ := ts.memoryPickup(testRider)
+
+			if body := ts.request(token, "/confirmPickup", tt.parameters); body != tt.want {
+				t.Fatalf("got %v, want %v", body, tt.want)
+			}
+
+			current, _ := ts.memoryPickup(testRider)
+			if tt.want != successResponse {
+				if current.Status != before.Status || current.version != before.version {
+					t.Errorf("failed confirm changed pickup from %v version %v to %v version %v", before.Status, before.version, current.Status, current.version)
+				}
+				return
+			}
+
+			if current.Status != confirmed || current.ConfirmDriverId != 3 || current.VanId != 2 || current.DriverId != 3 {
+				t.Errorf("got %v by driver %v in van %v, want confirmed by driver 3 in van 2", current.Status, current.ConfirmDriverId, current.VanId)
+			}
+			if !current.ConfirmTime.Equal(ts.clock.Now()) || current.StatusActor != "driver:3" {
+				t.Errorf("got confirm time %v by %v", current.ConfirmTime, current.StatusActor)
+			}
+			if stored, _ := ts.storedPickup(testRider); stored.Status != confirmed || stored.version != current.version {
+				t.Errorf("store has %v version %v, memory has version %v", stored.Status, stored.version, current.version)
+			}
+		})
+	}
+}
+
+func TestCompletePickup(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(ts *testServer) string //returns the token to request with
+		parameters url.Values
+		want       string
+	}{
+		{"driver completes confirmed pickup", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			driver := ts.driverToken(3, 2)
+			ts.request(driver, "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+			return driver
+		}, url.Values{"phoneNumber": {testRider}}, successResponse},
+		{"pending pickup must be confirmed first", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(3, 2)
+		}, url.Values{"phoneNumber": {testRider}}, failResponse},
+		{"pickup held by another van", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			ts.request(ts.driverToken(4, 1), "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+			return ts.driverToken(3, 2)
+		}, url.Values{"phoneNumber": {testRider}}, failResponse},
+		{"dispatchers may not complete", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			ts.request(ts.driverToken(3, 2), "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+			return ts.staffToken(dispatcherRole, 7)
+		}, url.Values{"phoneNumber": {testRider}}, wrongPasswordResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := tt.setup(ts)
+			ts.clock.Advance(10 * time.Minute)
+
+			if body := ts.request(token, "/completePickup", tt.parameters); body != tt.want {
+				t.Fatalf("got %v, want %v", body, tt.want)
+			}
+
+			current, _ := ts.memoryPickup(testRider)
+			past := ts.store.ListPastPickups()
+			if tt.want != successResponse {
+				if current.Status == completed || len(past) != 0 {
+					t.Errorf("failed complete left pickup %v with %v past pickups", current.Status, len(past))
+				}
+				return
+			}
+
+			if current.Status != completed || current.CompleteDriverId != 3 || !current.CompleteTime.Equal(ts.clock.Now()) {
+				t.Errorf("got %v by driver %v at %v", current.Status, current.CompleteDriverId, current.CompleteTime)
+			}
+			if want := ts.clock.Now().Add(pickupRetireDelay); !current.retireTime.Equal(want) {
+				t.Errorf("got retire time %v, want %v", current.retireTime, want)
+			}
+			if len(past) != 1 || past[0].Status != completed {
+				t.Errorf("past pickups are %+v, want the completed pickup", past)
+			}
+
+			//the rider's app still sees the final status until the pickup retires
+			if stored, exist := ts.storedPickup(testRider); !exist || stored.Status != completed {
+				t.Errorf("store has %+v, want completed pickup", stored)
+			}
+		})
+	}
+}
+
+func TestUpdateVanLocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      func(ts *testServer) string
+		parameters url.Values
+		want       string   //canned response, empty if a location is expected
+		location   Location //expected reply
+	}{
+		{"driver reports own van", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, url.Values{"vanNumber": {"2"}, "latitude": {"38.98"}, "longitude": {"-76.48"}}, "", Location{Latitude: 38.98, Longitude: -76.48, Heading: -1}},
+		{"driver reports heading", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, url.Values{"vanNumber": {"2"}, "latitude": {"38.98"}, "longitude": {"-76.48"}, "heading": {"90"}}, "", Location{Latitude: 38.98, Longitude: -76.48, Heading: 90}},
+		{"driver reports another van", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, url.Values{"vanNumber": {"1"}, "latitude": {"38.98"}, "longitude": {"-76.48"}}, failResponse, Location{}},
+		{"admin reports any van", func(ts *testServer) string {
+			return ts.staffToken(adminRole, 9)
+		}, url.Values{"vanNumber": {"4"}, "latitude": {"38.98"}, "longitude": {"-76.48"}}, "", Location{Latitude: 38.98, Longitude: -76.48, Heading: -1}},
+		{"unregistered van", func(ts *testServer) string {
+			return ts.staffToken(adminRole, 9)
+		}, url.Values{"vanNumber": {"6"}, "latitude": {"38.98"}, "longitude": {"-76.48"}}, failResponse, Location{}},
+		{"van out of service", func(ts *testServer) string {
+			ts.server.vans.set(Van{Id: 2, Callsign: "Van 2"})
+			return ts.driverToken(3, 2)
+		}, url.Values{"vanNumber": {"2"}, "latitude": {"38.98"}, "longitude": {"-76.48"}}, failResponse, Location{}},
+		{"riders may not report", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, url.Values{"vanNumber": {"2"}, "latitude": {"38.98"}, "longitude": {"-76.48"}}, wrongPasswordResponse, Location{}},
+		{"van number not a number", func(ts *testServer) string {
+			return ts.staffToken(adminRole, 9)
+		}, url.Values{"vanNumber": {"two"}, "latitude": {"38.98"}, "longitude": {"-76.48"}}, invalidParameterResponse("vanNumber", "must be a whole number"), Location{}},
+		{"missing van number", func(ts *testServer) string {
+			return ts.staffToken(adminRole, 9)
+		}, url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}}, invalidParameterResponse("vanNumber", "is required"), Location{}},
+		{"bad latitude with good longitude", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, url.Values{"vanNumber": {"2"}, "latitude": {"north"}, "longitude": {"-76.48"}}, invalidParameterResponse("latitude", "must be a number"), Location{}},
+		{"latitude out of range", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, url.Values{"vanNumber": {"2"}, "latitude": {"98.98"}, "longitude": {"-76.48"}}, invalidParameterResponse("latitude", "must be from -90 to 90"), Location{}},
+		{"heading out of range", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, url.Values{"vanNumber": {"2"}, "latitude": {"38.98"}, "longitude": {"-76.48"}, "heading": {"400"}}, invalidParameterResponse("heading", "must be from 0 to 360, or -1 if unknown"), Location{}},
+		{"missing longitude", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, url.Values{"vanNumber": {"2"}, "latitude": {"38.98"}}, invalidParameterResponse("longitude", "is required"), Location{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+
+			body := ts.request(tt.token(ts), "/updateVanLocation", tt.parameters)
+			if tt.want != "" {
+				if body != tt.want {
+					t.Fatalf("got %v, want %v", body, tt.want)
+				}
+				if list := ts.server.vanLocations.list(); len(list) != 0 {
+					t.Errorf("rejected van added to van locations %v", list)
+				}
+				return
+			}
+
+			tmp, ok := decodeLocation(body)
+			if !ok || tmp != tt.location {
+				t.Fatalf("got %v, want %+v", body, tt.location)
+			}
+
+			vanId, _ := strconv.Atoi(tt.parameters.Get("vanNumber"))
+			if stored, exist, _ := ts.store.GetVanLocation(vanId); !exist || stored.Latitude != tt.location.Latitude || !stored.latestTime.Equal(ts.clock.Now()) {
+				t.Errorf("store has van %v at %+v, exist %v", vanId, stored, exist)
+			}
+		})
+	}
+}
+
+func TestNewPickupSuggestsNearestVan(t *testing.T) {
+	ts := newTestServer(t)
+	ts.reportVan(1, "38.90", "-76.40")
+	ts.reportVan(2, "38.98", "-76.48")
+
+	tmp := ts.newPickup(testRider, testDevice, "38.981", "-76.481")
+	current, _ := ts.memoryPickup(testRider)
+	if tmp.SuggestedVanId != 2 || current.SuggestedVanId != 2 {
+		t.Errorf("got suggested van %v, %v in memory, want the nearest van 2", tmp.SuggestedVanId, current.SuggestedVanId)
+	}
+}
+
+//Pickups are not suggested to a van holding as many pickups as its own capacity allows
+func TestNewPickupSkipsVanAtCapacity(t *testing.T) {
+	ts := newTestServer(t)
+	if body := ts.request(ts.staffToken(adminRole, 9), "/updateVan", url.Values{"vanId": {"2"}, "capacity": {"1"}}); body != successResponse {
+		t.Fatalf("updateVan replied %v", body)
+	}
+	ts.reportVan(1, "38.90", "-76.40")
+	ts.reportVan(2, "38.98", "-76.48")
+
+	ts.newPickup(testRider, testDevice, "38.981", "-76.481")
+	if body := ts.request(ts.staffToken(dispatcherRole, 8), "/reassignPickup", url.Values{"phoneNumber": {testRider}, "vanId": {"2"}}); body != successResponse {
+		t.Fatalf("reassignPickup replied %v", body)
+	}
+
+	if tmp := ts.newPickup(testOtherRider, testDevice, "38.981", "-76.481"); tmp.SuggestedVanId != 1 {
+		t.Errorf("got suggested van %v, want van 1 since the nearest van 2 is full", tmp.SuggestedVanId)
+	}
+}
+
+//Admins register vans past the first five and take vans out of service
+func TestManageVans(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.staffToken(adminRole, 9)
+	parameters := url.Values{"vanId": {"7"}, "callsign": {"Navy 7"}, "capacity": {"8"}, "plate": {"MD 1234"}, "accessibility": {"wheelchair, ramp"}}
+
+	if body := ts.request(ts.staffToken(dispatcherRole, 8), "/createVan", parameters); body != wrongPasswordResponse {
+		t.Fatalf("dispatcher createVan replied %v, want %v", body, wrongPasswordResponse)
+	}
+	if body := ts.request(admin, "/createVan", parameters); body != successResponse {
+		t.Fatalf("createVan replied %v", body)
+	}
+	if body := ts.request(admin, "/createVan", parameters); body != failResponse {
+		t.Errorf("second createVan for van 7 replied %v, want %v", body, failResponse)
+	}
+	if body, want := ts.request(admin, "/createVan", url.Values{"vanId": {"8"}}), invalidParameterResponse("callsign", "is required"); body != want {
+		t.Errorf("createVan without a callsign replied %v, want %v", body, want)
+	}
+
+	want := Van{Id: 7, Callsign: "Navy 7", Capacity: 8, Active: true, Plate: "MD 1234", Accessibility: []string{"wheelchair", "ramp"}}
+	var list []Van
+	if err := json.Unmarshal([]byte(ts.request(admin, "/listVans", url.Values{})), &list); err != nil || len(list) != 6 || !reflect.DeepEqual(list[5], want) {
+		t.Fatalf("listVans replied %+v, want vans 1 to 5 and %+v", list, want)
+	}
+	if stored, _ := ts.store.ListVans(); len(stored) != 6 || !reflect.DeepEqual(stored[5], want) {
+		t.Errorf("store has vans %+v, want van 7 added", stored)
+	}
+
+	//the new van reports and is dispatched to like the first five
+	ts.reportVan(1, "38.90", "-76.40")
+	ts.reportVan(7, "38.98", "-76.48")
+	var locations map[string]Location
+	if err := json.Unmarshal([]byte(ts.request("", "/getVanLocations", url.Values{})), &locations); err != nil || len(locations) != 2 || locations["7"].Latitude != 38.98 {
+		t.Fatalf("getVanLocations replied %v, want vans 1 and 7", locations)
+	}
+	if tmp := ts.newPickup(testRider, testDevice, "38.981", "-76.481"); tmp.SuggestedVanId != 7 {
+		t.Fatalf("got suggested van %v, want the nearest van 7", tmp.SuggestedVanId)
+	}
+
+	//taking the van out of service stops its reports and offers its pickup to another van
+	if body := ts.request(admin, "/updateVan", url.Values{"vanId": {"7"}, "active": {"false"}}); body != successResponse {
+		t.Fatalf("updateVan replied %v", body)
+	}
+	if stored, _ := ts.store.ListVans(); stored[5].Active || stored[5].Callsign != "Navy 7" {
+		t.Errorf("store has van %+v, want it out of service with its details kept", stored[5])
+	}
+	if current, _ := ts.memoryPickup(testRider); current.SuggestedVanId != 1 {
+		t.Errorf("pickup suggested to van %v, want van 1", current.SuggestedVanId)
+	}
+	if body := ts.request(ts.driverToken(7, 7), "/updateVanLocation", url.Values{"vanNumber": {"7"}, "latitude": {"38.98"}, "longitude": {"-76.48"}}); body != failResponse {
+		t.Errorf("van out of service reported with reply %v, want %v", body, failResponse)
+	}
+	locations = nil
+	if err := json.Unmarshal([]byte(ts.request("", "/getVanLocations", url.Values{})), &locations); err != nil || len(locations) != 1 {
+		t.Errorf("getVanLocations replied %v, want only van 1", locations)
+	}
+
+	if body := ts.request(admin, "/updateVan", url.Values{"vanId": {"9"}, "active": {"true"}}); body != failResponse {
+		t.Errorf("updateVan for an unregistered van replied %v, want %v", body, failResponse)
+	}
+}
+
+//Drivers may only be given a van that is registered and in service
+func TestDriverVanMustBeActive(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.staffToken(adminRole, 9)
+	if body := ts.request(admin, "/updateVan", url.Values{"vanId": {"3"}, "active": {"false"}}); body != successResponse {
+		t.Fatalf("updateVan replied %v", body)
+	}
+
+	tests := []struct {
+		name     string
+		vanId    string
+		wantCode string
+	}{
+		{"active van", "2", ""},
+		{"off van", "0", ""},
+		{"unknown van", "9", unknownVanCode},
+		{"van out of service", "3", vanInactiveCode},
+		{"not a number", "two", invalidParameterCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDriver := Driver{Username: "driver", Enabled: true, Role: driverRole, VanId: 1}
+			err := ts.server.applyDriverParameters(&tmpDriver, url.Values{"vanId": {tt.vanId}})
+			if tt.wantCode == "" {
+				if err != nil || strconv.Itoa(tmpDriver.VanId) != tt.vanId {
+					t.Errorf("got van %v, %v, want van %v", tmpDriver.VanId, err, tt.vanId)
+				}
+				return
+			}
+
+			if e, ok := err.(*apiError); !ok || e.Code != tt.wantCode {
+				t.Fatalf("got %v, want %v", err, tt.wantCode)
+			}
+			if tmpDriver.VanId != 1 {
+				t.Errorf("refused change moved driver to van %v", tmpDriver.VanId)
+			}
+			want := failResponse
+			if tt.wantCode == invalidParameterCode {
+				want = invalidParameterResponse("vanId", "must be a whole number")
+			}
+			if body := ts.request(admin, "/createAccount", url.Values{"username": {"driver"}, "password": {"secret"}, "vanId": {tt.vanId}}); body != want {
+				t.Errorf("createAccount replied %v, want %v", body, want)
+			}
+		})
+	}
+}
+
+//Another instance changing a pickup bumps its version in the store, so a write made from the copy in memory must be refused and memory reloaded
+func TestStalePickupVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		prepare func(ts *testServer) //runs before the other instance's change
+	}{
+		{"confirm", "/confirmPickup", func(ts *testServer) {}},
+		{"complete", "/completePickup", func(ts *testServer) {
+			ts.request(ts.driverToken(3, 2), "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+		}},
+		{"claim", "/claimPickup", func(ts *testServer) {}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			tt.prepare(ts)
+
+			//another instance moves the pickup on without this instance hearing about it
+			stored, _ := ts.storedPickup(testRider)
+			other := stored
+			other.StatusActor = "dispatcher:7"
+			other.VanId = 5
+			if err := ts.store.UpdatePickup(other); err != nil {
+				t.Fatal(err)
+			}
+			if err := ts.store.UpdatePickup(stored); err != errStalePickup {
+				t.Fatalf("store accepted a write with version %v after it moved on, got %v", stored.version, err)
+			}
+
+			if body := ts.request(ts.driverToken(3, 2), tt.path, url.Values{"phoneNumber": {testRider}}); body != failResponse {
+				t.Fatalf("got %v, want %v", body, failResponse)
+			}
+
+			current, _ := ts.memoryPickup(testRider)
+			if current.version != stored.version+1 || current.VanId != 5 {
+				t.Errorf("memory has version %v for van %v, want version %v for van 5 from the store", current.version, current.VanId, stored.version+1)
+			}
+		})
+	}
+}
+
+//A finished pickup waiting to retire and a new pickup for the same phone number can have the same version, so a write must only change the pickup it was read from
+func TestUpdatePickupMatchesInitialTime(t *testing.T) {
+	store := newMemoryStore(time.Now)
+	finished := Pickup{PhoneNumber: testRider, devicePhrase: testDevice, InitialTime: testStartTime, Status: completed, StatusTime: testStartTime.Add(time.Minute), retireTime: testStartTime.Add(time.Hour)}
+	current := Pickup{PhoneNumber: testRider, devicePhrase: testOtherDevice, InitialTime: testStartTime.Add(2 * time.Minute), Status: pending}
+	for _, v := range []Pickup{finished, current} {
+		if err := store.CreatePickup(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated := current
+	updated.Status = confirmed
+	updated.VanId = 3
+	if err := store.UpdatePickup(updated); err != nil {
+		t.Fatal(err)
+	}
+
+	pickups, err := store.ListPickups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range pickups {
+		want := finished
+		if v.InitialTime.Equal(current.InitialTime) {
+			want = updated
+		}
+		if v.Status != want.Status || v.VanId != want.VanId {
+			t.Errorf("pickup from %v has status %v for van %v, want %v for van %v", v.InitialTime, v.Status, v.VanId, want.Status, want.VanId)
+		}
+	}
+
+	//the finished pickup still has version 0, the new pickup moved on
+	if err := store.UpdatePickup(current); err != errStalePickup {
+		t.Errorf("store accepted a stale write to the new pickup, got %v", err)
+	}
+}
+
+//Logins for unknown usernames compare against the precomputed hash, so it must cost as much as a real driver's hash
+func TestDummyPasswordHashCost(t *testing.T) {
+	if cost, err := bcrypt.Cost([]byte(dummyPasswordHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash has cost %v, %v, want %v", cost, err, bcrypt.DefaultCost)
+	}
+}
@@ -0,0 +1,240 @@
+package shipmate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//Clock the tests move forward by hand
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.lock.Lock()
+	c.now = now
+	c.lock.Unlock()
+}
+
+func (c *fakeClock) Advance(duration time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(duration)
+	c.lock.Unlock()
+}
+
+//Sessions and verified devices without Postgres
+type fakeSessionStore struct {
+	lock     sync.Mutex
+	sessions map[string]bool //session id to active
+	verified map[string]bool //phone number and device id
+}
+
+func (s *fakeSessionStore) IsSessionActive(sessionId string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.sessions[sessionId], nil
+}
+
+func (s *fakeSessionStore) IsPhoneNumberVerified(phoneNumber string, deviceId string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.verified[phoneNumber+":"+deviceId], nil
+}
+
+func (s *fakeSessionStore) addSession(sessionId string) {
+	s.lock.Lock()
+	s.sessions[sessionId] = true
+	s.lock.Unlock()
+}
+
+func (s *fakeSessionStore) verifyPhoneNumber(phoneNumber string, deviceId string) {
+	s.lock.Lock()
+	s.verified[phoneNumber+":"+deviceId] = true
+	s.lock.Unlock()
+}
+
+//Start of every test's fake clock
+var testStartTime = time.Date(2016, time.April, 1, 22, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+//A Server of its own for each test, running against the in-memory store, fake sessions and the fake clock
+type testServer struct {
+	t        *testing.T
+	server   *Server
+	store    *memoryStore
+	sessions *fakeSessionStore
+	clock    *fakeClock
+}
+
+//Start a server with empty stores and the clock at testStartTime. It is closed when the test ends.
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{t: t, sessions: &fakeSessionStore{sessions: make(map[string]bool), verified: make(map[string]bool)}, clock: &fakeClock{now: testStartTime}}
+	ts.store = newMemoryStore(ts.clock.Now)
+	ts.server = NewServer(WithPickupStore(ts.store), WithVanStore(ts.store), WithSessionStore(ts.sessions), WithClock(ts.clock.Now), WithLogger(log.New(io.Discard, "", 0)), WithTokenSecret([]byte("test token secret")))
+	ts.server.Start()
+	t.Cleanup(ts.server.Close)
+	return ts
+}
+
+//Sign an access token for a session the fake session store knows
+func (ts *testServer) token(targetSession Session) string {
+	ts.t.Helper()
+
+	sessionId, err := randomHex(16)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	targetSession.Id = sessionId
+	targetSession.ExpireTime = ts.clock.Now().Add(accessTokenLifetime)
+	ts.sessions.addSession(targetSession.Id)
+
+	token, err := ts.server.signAccessToken(targetSession)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return token
+}
+
+//Token of a rider whose phone number is verified on the device
+func (ts *testServer) riderToken(phoneNumber string, deviceId string) string {
+	ts.sessions.verifyPhoneNumber(phoneNumber, deviceId)
+	return ts.token(Session{Role: riderRole, PhoneNumber: phoneNumber, DeviceId: deviceId})
+}
+
+func (ts *testServer) driverToken(driverId int, vanId int) string {
+	return ts.token(Session{Role: driverRole, DriverId: driverId, VanId: vanId})
+}
+
+func (ts *testServer) staffToken(role string, driverId int) string {
+	return ts.token(Session{Role: role, DriverId: driverId})
+}
+
+//Send a request to a route and return the response body. An empty token sends no Authorization header.
+func (ts *testServer) request(token string, path string, parameters url.Values) string {
+	r := httptest.NewRequest("GET", path+"?"+parameters.Encode(), nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ts.server.ServeHTTP(w, r)
+	return w.Body.String()
+}
+
+//Send a JSON request to an /api/v1 route and return the status and response body. An empty token sends no Authorization header, a nil body sends no body.
+func (ts *testServer) apiRequest(token string, method string, path string, body interface{}) (int, string) {
+	ts.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		output, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		reader = bytes.NewReader(output)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.server.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+//Request a pickup for a rider and fail the test if it is not created
+func (ts *testServer) newPickup(phoneNumber string, deviceId string, latitude string, longitude string) Pickup {
+	ts.t.Helper()
+
+	body := ts.request(ts.riderToken(phoneNumber, deviceId), "/newPickup", url.Values{"latitude": {latitude}, "longitude": {longitude}})
+	tmp, ok := decodePickup(body)
+	if !ok || tmp.Status != pending {
+		ts.t.Fatalf("newPickup for %v replied %v", phoneNumber, body)
+	}
+	return tmp
+}
+
+//Report a van's location as its driver and fail the test if it is not accepted
+func (ts *testServer) reportVan(vanId int, latitude string, longitude string) {
+	ts.t.Helper()
+
+	body := ts.request(ts.driverToken(vanId, vanId), "/updateVanLocation", url.Values{"vanNumber": {strconv.Itoa(vanId)}, "latitude": {latitude}, "longitude": {longitude}})
+	if _, ok := decodeLocation(body); !ok {
+		ts.t.Fatalf("updateVanLocation for van %v replied %v", vanId, body)
+	}
+}
+
+//Pickup in memory for a phone number
+func (ts *testServer) memoryPickup(phoneNumber string) (Pickup, bool) {
+	ts.server.pickupsLock.RLock()
+	defer ts.server.pickupsLock.RUnlock()
+
+	tmp, exist := ts.server.pickups[phoneNumber]
+	return tmp, exist
+}
+
+//Pickup in the store for a phone number
+func (ts *testServer) storedPickup(phoneNumber string) (Pickup, bool) {
+	ts.t.Helper()
+
+	tmp, exist, err := ts.store.GetPickup(phoneNumber)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return tmp, exist
+}
+
+//Decode a pickup reply. Replies with a string status are canned responses, not pickups.
+func decodePickup(body string) (Pickup, bool) {
+	var tmp Pickup
+	if err := json.Unmarshal([]byte(body), &tmp); err != nil || tmp.PhoneNumber == "" {
+		return tmp, false
+	}
+	return tmp, true
+}
+
+//Decode a van location reply. Canned responses also decode as a location, so they are told apart by their status first.
+func decodeLocation(body string) (Location, bool) {
+	var tmp Location
+	if reply := decodeStatus(body); reply.Status != "" {
+		return tmp, false
+	}
+	if err := json.Unmarshal([]byte(body), &tmp); err != nil {
+		return tmp, false
+	}
+	return tmp, true
+}
+
+//Decode the status of a canned legacy reply. Status is empty for any other reply.
+func decodeStatus(body string) statusResponse {
+	var tmp statusResponse
+	json.Unmarshal([]byte(body), &tmp)
+	return tmp
+}
+
+//Legacy failure reply for one invalid parameter
+func invalidParameterResponse(field string, message string) string {
+	output, _ := json.Marshal(statusResponse{Status: "-1", Fields: []fieldError{{Field: field, Message: message}}})
+	return string(output)
+}
@@ -1,17 +1,13 @@
-package main
+package shipmate
 
 import (
-	"crypto/md5"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"github.com/lib/pq"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
-	"sync"
 	"time"
 )
 
@@ -20,67 +16,34 @@ type Location struct {
 	Longitude  float64 `json:"longitude"`
 	Heading    float64 `json:"heading"`
 	latestTime time.Time
+	driverId   int //driver that last reported a van location
 }
 
-//Status int constants
-const pending int = 1
-const confirmed int = 2
-const completed int = 3
-const inactive int = 0
-
-const canceled int = 5 //only used when copying into pastPickups table
-
 type Pickup struct {
-	PhoneNumber     string    `json:"phoneNumber"`
-	devicePhrase    string
-	InitialLocation Location  `json:"initialLocation"`
-	InitialTime     time.Time `json:"initialTime"`
-	LatestLocation  Location  `json:"latestLocation"`
-	LatestTime      time.Time `json:"latestTime"`
-	ConfirmTime     time.Time `json:"confirmTime"`
-	CompleteTime    time.Time `json:"completeTime"`
-	Status          int       `json:"status"`
-	version         int
-}
-
-var pickups map[string]Pickup
-var pickupsLock *sync.RWMutex
-
-var vanLocations []Location
-
-var startTime = time.Now()
-
-var successResponse string
-var failResponse string
-var wrongPasswordResponse string
-
-var db *(sql.DB)
-
-var serialChannel chan func()
-
-func generateSuccessResponse(targetString *string) {
-	tmp, err := json.Marshal(map[string]string{"status": "0"})
-	*targetString = string(tmp)
-	if err != nil {
-		fmt.Printf("Generating success response failed. %v", err)
-	}
-}
-
-func generateFailResponse(targetString *string) {
-	tmp, err := json.Marshal(map[string]string{"status": "-1"})
-	*targetString = string(tmp)
-	if err != nil {
-		fmt.Printf("Generating fail response failed. %v", err)
-	}
-}
-
-func generateWrongPasswordResponse(targetString *string) {
-	tmp, err := json.Marshal(map[string]string{"status": "-2"})
-	*targetString = string(tmp)
-	if err != nil {
-		fmt.Printf("Generating wrong password response failed. %v", err)
-	}
-}
+	PhoneNumber      string    `json:"phoneNumber"`
+	devicePhrase     string
+	InitialLocation  Location  `json:"initialLocation"`
+	InitialTime      time.Time `json:"initialTime"`
+	LatestLocation   Location  `json:"latestLocation"`
+	LatestTime       time.Time `json:"latestTime"`
+	ConfirmTime      time.Time `json:"confirmTime"`
+	CompleteTime     time.Time `json:"completeTime"`
+	Status           PickupStatus `json:"status"`
+	version          int
+	ConfirmDriverId  int       `json:"confirmDriverId"`
+	CompleteDriverId int       `json:"completeDriverId"` //driver that completed or canceled the pickup
+	StatusActor      string    `json:"statusActor"`      //who made the latest status change
+	StatusTime       time.Time `json:"statusTime"`
+	VanId            int       `json:"vanId"`    //van assigned to the pickup, 0 if unassigned
+	DriverId         int       `json:"driverId"` //driver of the assigned van, 0 if unknown
+	SuggestedVanId   int       `json:"suggestedVanId"` //van proposed by dispatch that has not claimed the pickup yet
+	retireTime       time.Time //when a finished pickup is deleted from inprogress, zero while it is active
+}
+
+//Replies shared by every endpoint
+const successResponse string = `{"status":"0"}`
+const failResponse string = `{"status":"-1"}`
+const wrongPasswordResponse string = `{"status":"-2"}`
 
 func doKeysExist(targetDictionary url.Values, targetKeys []string) bool {
 	for _, v := range targetKeys {
@@ -111,195 +74,8 @@ func isAsyncRequest(targetDictionary url.Values) bool {
 	return doKeysExist(targetDictionary, []string{"async"}) && !areFieldsEmpty(targetDictionary, []string{"async"})
 }
 
-func checkMD5(password []byte) bool {
-	digest := fmt.Sprintf("%x", md5.Sum(password))
-	/*
-	if digest == "34d1f8a7e29f3f3497ec05d0c9c8e4fc" {
-		return true
-	}
-	*/
-
-	if digest == os.Getenv("SHIPMATE_PHRASE_DIGEST") {
-		return true
-	}
-	return false
-}
-
-func isDriverPhraseCorrect(targetDictionary url.Values) bool {
-	if doKeysExist(targetDictionary, []string{"phrase"}) && !areFieldsEmpty(targetDictionary, []string{"phrase"}) {
-		if checkMD5([]byte(targetDictionary["phrase"][0])) {
-			return true
-		} else {
-			//fmt.Println("Wrong driver phrase \"" + targetDictionary["phrase"][0] + "\" received")
-		}
-	} else {
-		fmt.Println("No phrase HTTP parameter received.")
-	}
-	return false
-}
-
-//Determine if update has failed due to holding onto stale record and update memory. Return the updated rows (if any). 
-func updateIfStale(targetResult sql.Result, targetTable string, targetPhoneNumber string) *(sql.Rows) {
-	//if result is nil, reload data
-	if targetResult == nil {
-		log.Printf("Nil result passed to stale function (query error?). Load current pickups from database into memory.")
-		return selectRowsFromTableByPhoneNumber(targetTable, targetPhoneNumber); 
-	}
-
-	//If rows affected is 0, then NO row with the request "version" was found. Likely another instance has modifed it already.
-	if rowsAffected, _ := targetResult.RowsAffected(); rowsAffected == 0 { //Stop, get most recent version of table
-		log.Printf("%v rows affected. Instance had a stale entry. Load current pickups from database into memory.", rowsAffected)
-		return selectRowsFromTableByPhoneNumber(targetTable, targetPhoneNumber); 
-	}
-
-	return nil
-		/*
-		
-		if rows := selectRowsFromTableByPhoneNumber(targetTable, targetPhoneNumber); rows != nil {
-			if loadPickupRowsIntoMemory(rows) > 0 {
-				return true
-			} else {
-				setPickupToInactiveInMemory(&pickups, targetPhoneNumber)
-				return false
-			}
-		} else {
-			log.Println("selectRowsFromTableByPhoneNumber returned nil object")
-			return true
-		}
-	} else {
-		//set noIncrementVersion to true for thing like DELETE/INSERT so that the phone number in memory's version is not incremented because we may have a newer pickup in the map already
-		if !noIncrementVersion { 
-			var tmp = pickups[targetPhoneNumber]
-			tmp.version = tmp.version+1
-			pickups[targetPhoneNumber] = tmp
-			log.Printf("OK - %v version incremented to %v", targetPhoneNumber, tmp.version)
-		}
-	}
-	return false //no update needed, all good
-	*/
-}
-
-//INSERT new pickup row in inprogress table. Return boolean on success of operation as in changes written to DB.
-func databaseInsertPickupInCurrentTable(targetPickup Pickup) *(sql.Rows) {
-	if checkDatabaseHandleValid(db) {
-		var result sql.Result
-		var err error
-		if result, err = db.Exec(`INSERT INTO inprogress (PhoneNumber, DeviceId, InitialLatitude, InitialLongitude, InitialTime, LatestLatitude, LatestLongitude, LatestTime, ConfirmTime, CompleteTime, Status) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);`, targetPickup.PhoneNumber, targetPickup.devicePhrase, targetPickup.InitialLocation.Latitude, targetPickup.InitialLocation.Longitude, targetPickup.InitialTime, targetPickup.LatestLocation.Latitude, targetPickup.LatestLocation.Longitude, targetPickup.LatestTime, targetPickup.ConfirmTime, targetPickup.CompleteTime, targetPickup.Status); err != nil {
-			log.Println(err)
-		} else {
-			rowsAffected, _ := result.RowsAffected()
-			fmt.Printf("INSERT %v rows affected for databaseInsertPickupInCurrentTable()\n", rowsAffected)
-		}
-		return updateIfStale(result, "inprogress", targetPickup.PhoneNumber)
-	}
-	return nil		
-}
-
-//UPDATE pickup status in inprogress table
-func databaseUpdatePickupStatusInCurrentTable(targetPickup Pickup, newStatus int) *(sql.Rows) {
-	if checkDatabaseHandleValid(db) {
-		var result sql.Result
-		var err error
-		if result, err = db.Exec(`UPDATE inprogress 
-			SET Status = $1, Version = $4 
-			WHERE PhoneNumber = $2 AND Version = $3;`, newStatus, targetPickup.PhoneNumber, targetPickup.version, targetPickup.version+1); err != nil {
-			log.Println(err)
-		} else {
-			rowsAffected, _ := result.RowsAffected()
-			fmt.Printf("UPDATE %v rows affected for databaseUpdatePickupStatusInCurrentTable()\n", rowsAffected)
-		}
-		return updateIfStale(result, "inprogress", targetPickup.PhoneNumber)
-	}
-	return nil
-}
-
-//UPDATE pickup latestLocation in inprogress table
-func databaseUpdatePickupLatestLocationInCurrentTable(targetPickup Pickup) *(sql.Rows) {
-	if checkDatabaseHandleValid(db) {
-		var result sql.Result
-		var err error
-
-		log.Println("Phone number",targetPickup.PhoneNumber,"version", targetPickup.version)
-
-		if result, err = db.Exec(`UPDATE inprogress 
-			SET LatestLatitude = $1, LatestLongitude = $2, LatestTime = $3, Version = $6 
-			WHERE PhoneNumber = $4 AND Version = $5;`, targetPickup.LatestLocation.Latitude, targetPickup.LatestLocation.Longitude, targetPickup.LatestTime, targetPickup.PhoneNumber, targetPickup.version, targetPickup.version+1); err != nil {
-			log.Println(err)
-		} else {
-			rowsAffected, _ := result.RowsAffected()
-			fmt.Printf("UPDATE %v rows affected for databaseUpdatePickupLatestLocationInCurrentTable()\n", rowsAffected)
-		}
-		return updateIfStale(result, "inprogress", targetPickup.PhoneNumber)
-	}
-	return nil
-}
-
-//Copy over to pastpickups table and call function to delete from inprogress table
-func databaseInsertPickupInPastTable(targetPickup Pickup) bool {
-	if checkDatabaseHandleValid(db) {
-		var result sql.Result
-		var err error
-		if result, err = db.Exec(`INSERT INTO pastpickups (PhoneNumber, DeviceId, InitialLatitude, InitialLongitude, InitialTime, LatestLatitude, LatestLongitude, LatestTime, ConfirmTime, CompleteTime, Status) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);`, targetPickup.PhoneNumber, targetPickup.devicePhrase, targetPickup.InitialLocation.Latitude, targetPickup.InitialLocation.Longitude, targetPickup.InitialTime, targetPickup.LatestLocation.Latitude, targetPickup.LatestLocation.Longitude, targetPickup.LatestTime, targetPickup.ConfirmTime, targetPickup.CompleteTime, targetPickup.Status); err != nil {
-			log.Println(err)
-		} else {
-			rowsAffected, _ := result.RowsAffected()
-			fmt.Printf("INSERT %v rows affected for databaseInsertPickupInPastTable()\n", rowsAffected)
-			if rowsAffected == 1 {
-				return true
-			}
-		}
-	}
-	return false
-}
-
-//DELETE pickup from inprogress table
-func databaseDeletePickupInCurrentTable(targetPickup Pickup) *(sql.Rows) {
-	if checkDatabaseHandleValid(db) {
-		var result sql.Result
-		var err error
-		//Identify pickups by phoneNumber and initialTime instead of version since the phoneNumber might have another entry with new pickup
-		if result, err = db.Exec(`DELETE FROM inprogress 
-			WHERE PhoneNumber = $1 AND InitialTime = $2;`, targetPickup.PhoneNumber, targetPickup.InitialTime); err != nil {
-			log.Println("delete error")
-			log.Println(err)
-		} else {
-			rowsAffected, _ := result.RowsAffected()
-			fmt.Printf("DELETE %v rows affected for databaseDeletePickupInCurrentTable()\n", rowsAffected)
-		}
-		return updateIfStale(result, "inprogress", targetPickup.PhoneNumber)
-	}
-	return nil
-}
-
-//UPDATE new van location in vanlocations table
-func databaseUpdateVanLocations(vanId int, targetLocation Location) bool {
-	if checkDatabaseHandleValid(db) {
-		var result sql.Result
-		var err error
-		if result, err = db.Exec(`UPDATE vanlocations 
-			SET LatestLatitude = $1, LatestLongitude = $2, LatestTime = $3 
-			WHERE VanId = $4;`, targetLocation.Latitude, targetLocation.Longitude, targetLocation.latestTime, vanId); err != nil {
-			log.Println(err)
-		} else {
-			if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
-				if result, err = db.Exec("INSERT INTO vanlocations (VanId, LatestLatitude, LatestLongitude, LatestTime) VALUES ($1, $2, $3, $4);", vanId, targetLocation.Latitude, targetLocation.Longitude, targetLocation.latestTime); err != nil {
-					log.Println(err)
-				} else {
-					log.Println("Created new van row on DB.")
-				}
-			} else {
-				//log.Println("Updated van row on DB. %v", targetLocation)
-			}	
-		}
-		return true
-	}
-	return false		
-}
-
-func updateVanLocation(w http.ResponseWriter, r *http.Request) {
-	log.Println("updateVanLocation()")
+func (s *Server) updateVanLocation(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("updateVanLocation()")
 
 	//bypass same origin policy
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -307,89 +83,80 @@ func updateVanLocation(w http.ResponseWriter, r *http.Request) {
 	//parse http parameters
 	r.ParseForm()
 
-	//check passphrase in "phrase" parameter
-	if !isDriverPhraseCorrect(r.Form) {
-		fmt.Fprintf(w, failResponse)
-		return
+	var v validator
+	var vanNumber int
+	if value, exist := v.required(r.Form, "vanNumber"); exist {
+		vanNumber = v.vanId("vanNumber", value)
 	}
+	location := v.formLocation(r.Form)
+	location.Heading = v.formHeading(r.Form)
 
-	if !doKeysExist(r.Form, []string{"vanNumber", "latitude", "longitude"}) && areFieldsEmpty(r.Form, []string{"vanNumber", "latitude", "longitude"}) {
-		log.Println("required http parameters not found for updateVanLocation")
+	err := v.err()
+	if err == nil {
+		location, err = s.reportVanLocation(session, vanNumber, location)
 	}
-
-	var vanNumber int
-	var location Location
-
-	vanNumber, err := strconv.Atoi(r.Form["vanNumber"][0])
 	if err != nil {
-		log.Println(err)
-	}
-
-	//5 vans max, #1-5
-	if vanNumber < 1 || vanNumber > 5 {
-		if output, err := json.Marshal(Location{}); err == nil {
-			fmt.Fprintf(w, string(output[:]))
-		} else {
-			log.Println(err)
-		}
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
 		return
 	}
 
-	lat, err := strconv.ParseFloat(r.Form["latitude"][0], 64)
-	lon, err := strconv.ParseFloat(r.Form["longitude"][0], 64)
-	if err != nil {
-		log.Println(err)
+	//reply with van location on server
+	if output, err := json.Marshal(location); err == nil {
+		fmt.Fprint(w, string(output))
 	} else {
-		location = Location{Latitude: lat, Longitude: lon}
+		s.logger.Println(err)
 	}
+}
 
-	if doKeysExist(r.Form, []string{"heading"}) && !areFieldsEmpty(r.Form, []string{"heading"}) {
-		heading, err := strconv.ParseFloat(r.Form["heading"][0], 64)
-		if err != nil {
-			log.Println(err)
-		} else {
-			location.Heading = heading
-		}
-	} else {
-		location.Heading = -1
+//Record a driver's report of their van's location, heading -1 if the van has no heading. Returns the location as kept in memory.
+func (s *Server) reportVanLocation(session Session, vanId int, location Location) (Location, error) {
+	//drivers may only report the van they are assigned to
+	if !hasPermission(session.Role, updateAnyVanPermission) && session.VanId != vanId {
+		return Location{}, apiErrorf(http.StatusForbidden, vanNotAssignedCode, "driver %v is not assigned to van %v", session.DriverId, vanId)
 	}
 
-	for len(vanLocations) < vanNumber {
-		vanLocations = append(vanLocations, Location{})
+	//only registered vans in service may report
+	if _, err := s.activeVan(vanId); err != nil {
+		return Location{}, err
 	}
 
-	vanLocations[vanNumber-1] = location
+	location.latestTime = s.clock()
+	location.driverId = session.DriverId
 
-	vanLocations[vanNumber-1].latestTime = time.Now()
+	//commit the whole location at once so other requests never see it half written
+	previousLocation := s.vanLocations.set(vanId, location)
+	s.recordVanSpeed(vanId, previousLocation, location)
+	s.vanChanged(vanId)
 
-	//reply with van location on server
-	if output, err := json.Marshal(vanLocations[vanNumber-1]); err == nil {
-		fmt.Fprintf(w, string(output[:]))
-	} else {
-		log.Println(err)
+	if err := s.vanStore.UpdateVanLocation(vanId, location); err != nil {
+		s.logger.Println(err)
 	}
-
-	databaseUpdateVanLocations(vanNumber, vanLocations[vanNumber-1])
+	return location, nil
 }
 
-func aboutHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) aboutHandler(w http.ResponseWriter, r *http.Request) {
 	//bypass same origin policy
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	http.Redirect(w, r, "https://github.com/ansonl/shipmate", http.StatusFound)
 
-	log.Println("About requested")
+	s.logger.Println("About requested")
 }
 
-func uptimeHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) uptimeHandler(w http.ResponseWriter, r *http.Request) {
 	//bypass same origin policy
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	diff := time.Since(startTime)
+	diff := time.Since(s.startTime)
 
-	fmt.Fprintf(w, "Uptime:\t%v\nPickups total:\t%v\nVans total:\t%v", diff.String(), len(pickups), len(vanLocations))
+	s.pickupsLock.RLock()
+	pickupCount := len(s.pickups)
+	s.pickupsLock.RUnlock()
 
-	log.Println("Uptime requested")
+	fmt.Fprintf(w, "Uptime:\t%v\nPickups total:\t%v\nVans total:\t%v", diff.String(), pickupCount, s.vanLocations.count())
+
+	s.logger.Println("Uptime requested")
 }
 
 func asyncTest(w http.ResponseWriter, r *http.Request) {
@@ -402,74 +169,89 @@ func asyncTest(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "done")
 }
 
-func newPickup(w http.ResponseWriter, r *http.Request) {
-	pickupsLock.Lock()
-	defer pickupsLock.Unlock()
-
-	log.Println("newPickup()")
+func (s *Server) newPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("newPickup()")
 	//bypass same origin policy
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	r.ParseForm()
 
-	if !doKeysExist(r.Form, []string{"phoneNumber", "latitude", "longitude", "phrase"}) && areFieldsEmpty(r.Form, []string{"phoneNumber", "latitude", "longitude", "phrase"}) {
-		log.Println("required http parameters not found for newPickup")
+	//pickups are requested by riders for the phone number their session was registered with
+	var v validator
+	location := v.formLocation(r.Form)
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
 	}
 
-	var number, devicePhrase string
-	var location Location
-
-	/*
-		number, err := strconv.Atoi(r.Form["phoneNumber"][0]);
-		if  err != nil {
-			log.Println(err)
-		}
-	*/
+	tmp, requestId, err := s.createPickup(session, location, isAsyncRequest(r.Form))
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+	} else if requestId != "" {
+		fmt.Fprint(w, s.asyncResponse(requestId))
+	} else if output, err := json.Marshal(tmp); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
 
-	number = r.Form["phoneNumber"][0]
-	devicePhrase = r.Form["phrase"][0]
+//Request a pickup at location for the rider's phone number. Synchronous pickups are written and dispatched before returning. Async pickups are committed to memory straight away, written by the outbox and returned with the request id of the queued write.
+func (s *Server) createPickup(session Session, location Location, async bool) (Pickup, string, error) {
+	s.pickupsLock.Lock()
+	defer s.pickupsLock.Unlock()
 
-	lat, err := strconv.ParseFloat(r.Form["latitude"][0], 64)
-	lon, err := strconv.ParseFloat(r.Form["longitude"][0], 64)
+	number := session.PhoneNumber
+	devicePhrase := session.DeviceId
 
-	if err != nil {
-		log.Println(err)
-	} else {
-		location = Location{Latitude: lat, Longitude: lon}
+	//only accept pickups for a phone number that was verified on this device
+	if !s.isPhoneNumberVerified(number, devicePhrase) {
+		return Pickup{}, "", apiErrorf(http.StatusForbidden, phoneNotVerifiedCode, "phone number %v not verified for device", number)
 	}
 
-	//if someone else if already using that number and devicePhrase does not match, maybe the user reinstalled the app
-	//we want to allow the same device to continue using the phoneNumber if the app relaunched
-	if pickups[number].Status != 0 && pickups[number].devicePhrase != "" && pickups[number].devicePhrase != devicePhrase {
-		fmt.Fprintf(w, failResponse)
-		return
+	//a phone number has one active pickup at a time, whichever device asks. Another device gets the number once the rider's device stops reporting and removeInactivePickups releases it.
+	//a relaunched app on the same device keeps following its pickup with /getPickupInfo instead of creating another
+	if s.pickups[number].Status.isActive() && s.pickups[number].devicePhrase != "" && s.pickups[number].devicePhrase != devicePhrase {
+		return Pickup{}, "", apiErrorf(http.StatusConflict, pickupExistsCode, "phone number %v has an active pickup on another device", number)
+	}
+	if s.pickups[number].Status.isActive() && s.pickups[number].devicePhrase == devicePhrase {
+		return Pickup{}, "", apiErrorf(http.StatusConflict, pickupExistsCode, "phone number %v already has an active pickup", number)
 	}
 
-	tmp := Pickup{number, devicePhrase, location, time.Now(), location, time.Now(), time.Time{}, time.Time{}, pending, 0}
+	now := s.clock()
+	tmp := Pickup{PhoneNumber: number, devicePhrase: devicePhrase, InitialLocation: location, InitialTime: now, LatestLocation: location, LatestTime: now}
+	if err := s.transitionPickup(&tmp, pending, sessionActor(session), now); err != nil {
+		return Pickup{}, "", transitionError(err)
+	}
 
 	//Sync to database
-	if isAsyncRequest(r.Form) {
-		fmt.Println("async requested") //TO DO
-	} else { //Syncronous request
-		//INSERT pickup as new row into inprogress table
-		if newRows := databaseInsertPickupInCurrentTable(tmp); newRows != nil {
-			loadPickupRowsIntoMemory(&pickups, newRows, nil);
-			fmt.Fprintf(w, failResponse)
-		} else {
-			//commit changes to instance memory
-			pickups[number] = tmp
-			if output, err := json.Marshal(pickups[number]); err == nil {
-				fmt.Fprintf(w, string(output))
-			} else {
-				log.Println(err)
-			}
+	if async {
+		//commit changes to instance memory now, the INSERT is queued and the pickup is dispatched once it commits
+		requestId, err := s.pickupStore.QueuePickupWrite(insertPickupWrite, tmp, sessionActor(session))
+		if err != nil {
+			return Pickup{}, "", storeError(err)
 		}
-	} 
+		s.pickups[number] = tmp
+		go s.pickupChanged(number) //runs once this request releases pickupsLock
+		return tmp, requestId, nil
+	}
+
+	//INSERT pickup as new row into inprogress table
+	if err := s.pickupStore.CreatePickup(tmp); err != nil {
+		s.loadPickupIntoMemory(number)
+		return Pickup{}, "", storeError(err)
+	}
+
+	//commit changes to instance memory
+	s.pickups[number] = tmp
+	s.databaseInsertPickupEvent(tmp, createdEvent, sessionActor(session), tmp.InitialTime)
+	s.dispatchPickup(tmp, nil) //no van has declined a new pickup yet
+	return s.pickups[number], "", nil
 }
 
-func getPickupInfo(w http.ResponseWriter, r *http.Request) {
-	pickupsLock.Lock()
-	defer pickupsLock.Unlock()
+func (s *Server) getPickupInfo(w http.ResponseWriter, r *http.Request, session Session) {
 	/*
 		//Disable logging for getPickupInfo for brevity
 		log.Println("getPickupInfo()")
@@ -481,68 +263,128 @@ func getPickupInfo(w http.ResponseWriter, r *http.Request) {
 	//parse http parameters
 	r.ParseForm()
 
-	if !doKeysExist(r.Form, []string{"phoneNumber", "latitude", "longitude", "phrase"}) && areFieldsEmpty(r.Form, []string{"phoneNumber", "latitude", "longitude", "phrase"}) {
-		log.Println("required http parameters not found for getPickupInfo")
+	var number string
+
+	//staff may view any pickup, riders only the pickup for their own phone number
+	viewingOwnPickup := !hasPermission(session.Role, viewAnyPickupPermission)
+	if viewingOwnPickup {
+		number = session.PhoneNumber
+
+		//older apps report the rider's location while polling, a bad location does not keep them from getting their pickup
+		if _, exist := formValue(r.Form, "latitude"); exist {
+			var v validator
+			location := v.formLocation(r.Form)
+			if err := v.err(); err != nil {
+				s.logger.Println(err)
+			} else if err := s.recordRiderLocation(session, location); err != nil {
+				s.logger.Println(err)
+			}
+		}
+	} else {
+		var v validator
+		number = v.formPhoneNumber(r.Form)
+		if err := v.err(); err != nil {
+			s.logger.Println(err)
+			fmt.Fprint(w, legacyResponse(err))
+			return
+		}
 	}
 
-	var number string
-	var location Location
+	info, err := s.viewPickup(session, number)
+	if hasErrorCode(err, notFoundCode) {
+		//if the pickup does not exist, return status 0, so that monitorStatus on iOS will show pickupInactive
+		fmt.Fprint(w, successResponse)
+	} else if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+	} else if output, err := json.Marshal(info); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
 
-	number = r.Form["phoneNumber"][0]
+//Pickup for a phone number with its van, ETA and queue position. Staff may view any pickup, riders only the pickup for their own phone number on the device that requested it.
+func (s *Server) viewPickup(session Session, number string) (pickupInfo, error) {
+	s.pickupsLock.RLock()
+	defer s.pickupsLock.RUnlock()
 
-	//if the pickup does not exist, return status 0, so that monitorStatus on iOS will show pickupInactive
-	if _, exist := pickups[number]; !exist {
-		fmt.Fprintf(w, successResponse)
-		return
+	viewingOwnPickup := !hasPermission(session.Role, viewAnyPickupPermission)
+	if viewingOwnPickup && number != session.PhoneNumber {
+		return pickupInfo{}, apiErrorf(http.StatusForbidden, forbiddenCode, "riders may only view their own pickup")
 	}
 
-	//check passphrase in "phrase" parameter
-	if !isDriverPhraseCorrect(r.Form) {
-		if r.Form["phrase"][0] != pickups[number].devicePhrase && pickups[number].devicePhrase != "" {
-			fmt.Fprintf(w, wrongPasswordResponse)
-			return
-		}
+	tmp, exist := s.pickups[number]
+	if !exist {
+		return pickupInfo{}, apiErrorf(http.StatusNotFound, notFoundCode, "no pickup for %v", number)
+	}
+
+	//check rider session belongs to the device that requested the pickup
+	if viewingOwnPickup && session.DeviceId != tmp.devicePhrase && tmp.devicePhrase != "" {
+		return pickupInfo{}, apiErrorf(http.StatusForbidden, deviceMismatchCode, "pickup for %v was requested from another device", number)
 	}
 
-	var tmp = pickups[number]
+	return s.pickupInfoFor(tmp), nil
+}
 
-	if lat, err := strconv.ParseFloat(r.Form["latitude"][0], 64); err == nil {
-		if lon, err := strconv.ParseFloat(r.Form["longitude"][0], 64); err == nil {
-			location = Location{Latitude: lat, Longitude: lon}
+//Write the rider's reported location to their pickup
+func (s *Server) recordRiderLocation(session Session, location Location) error {
+	//only read under the lock, the database write happens without holding up other requests
+	s.pickupsLock.RLock()
+	tmp, exist := s.pickups[session.PhoneNumber]
+	s.pickupsLock.RUnlock()
 
-			tmp.LatestLocation = location
-			tmp.LatestTime = time.Now()
-		} else {
-			log.Println(err)
-		}
-	} else {
-			log.Println(err)
+	if !exist || !tmp.Status.isActive() {
+		return apiErrorf(http.StatusNotFound, notFoundCode, "no active pickup for %v", session.PhoneNumber)
+	}
+	if session.DeviceId != tmp.devicePhrase && tmp.devicePhrase != "" {
+		return apiErrorf(http.StatusForbidden, deviceMismatchCode, "pickup for %v was requested from another device", session.PhoneNumber)
 	}
 
-	//Sync to database
-	if isAsyncRequest(r.Form) {
-		fmt.Println("async requested") //TO DO
-	} else { //Syncronous request
-		//INSERT pickup as new row into inprogress table
-		if newRows := databaseUpdatePickupLatestLocationInCurrentTable(tmp); newRows != nil {
-			loadPickupRowsIntoMemory(&pickups, newRows, nil);
-			fmt.Fprintf(w, failResponse)
-		} else {
-			//increment pickup counter in tmp struct
-			tmp.version = tmp.version+1
-
-			//commit changes to instance memory
-			pickups[number] = tmp
-			if output, err := json.Marshal(pickups[number]); err == nil {
-				fmt.Fprintf(w, string(output))
-			} else {
-				log.Println(err)
-			}
-		}
-	} 
+	tmp.LatestLocation = location
+	tmp.LatestTime = s.clock()
+
+	if err := s.pickupStore.UpdatePickupLocation(tmp); err != nil {
+		return storeError(err)
+	}
+
+	//commit changes to instance memory unless the pickup was replaced in the meantime
+	s.pickupsLock.Lock()
+	if current, exist := s.pickups[tmp.PhoneNumber]; exist && current.InitialTime.Equal(tmp.InitialTime) {
+		current.LatestLocation = tmp.LatestLocation
+		current.LatestTime = tmp.LatestTime
+		s.pickups[tmp.PhoneNumber] = current
+	}
+	s.pickupsLock.Unlock()
+
+	s.pickupChanged(tmp.PhoneNumber)
+	s.databaseInsertPickupEvent(tmp, locationEvent, sessionActor(session), tmp.LatestTime)
+	return nil
+}
+
+//Rider reports their location without fetching the pickup
+func (s *Server) updatePickupLocation(w http.ResponseWriter, r *http.Request, session Session) {
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	location := v.formLocation(r.Form)
+	err := v.err()
+	if err == nil {
+		err = s.recordRiderLocation(session, location)
+	}
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+	fmt.Fprint(w, successResponse)
 }
 
-func getVanLocations(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getVanLocations(w http.ResponseWriter, r *http.Request) {
 	/*
 		//Disabled logging of getVanLocations for brevity
 		log.Println("getVanLocations()")
@@ -551,19 +393,16 @@ func getVanLocations(w http.ResponseWriter, r *http.Request) {
 	//bypass same origin policy
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	//reply with all van locations on server
-	if output, err := json.Marshal(vanLocations); err == nil {
-		fmt.Fprintf(w, string(output[:]))
+	//reply with the location of every van in service keyed by van id
+	if output, err := json.Marshal(s.activeVanLocations()); err == nil {
+		fmt.Fprint(w, string(output))
 	} else {
-		log.Println(err)
+		s.logger.Println(err)
 	}
 }
 
-func cancelPickup(w http.ResponseWriter, r *http.Request) {
-	pickupsLock.Lock()
-	defer pickupsLock.Unlock()
-
-	log.Println("cancelPickup()")
+func (s *Server) cancelPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("cancelPickup()")
 
 	//bypass same origin policy
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -571,25 +410,56 @@ func cancelPickup(w http.ResponseWriter, r *http.Request) {
 	//parse http parameters
 	r.ParseForm()
 
-	if !doKeysExist(r.Form, []string{"phoneNumber", "phrase"}) && areFieldsEmpty(r.Form, []string{"phoneNumber", "phrase"}) {
-		log.Println("required http parameters not found for getPickupInfo")
+	var number string
+
+	//dispatchers may cancel any pickup, riders only the pickup for their own phone number
+	if hasPermission(session.Role, cancelAnyPickupPermission) {
+		var v validator
+		number = v.formPhoneNumber(r.Form)
+		if err := v.err(); err != nil {
+			s.logger.Println(err)
+			fmt.Fprint(w, legacyResponse(err))
+			return
+		}
+	} else {
+		number = session.PhoneNumber
 	}
 
-	var number string
+	_, requestId, err := s.cancelPickupFor(session, number, isAsyncRequest(r.Form))
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+	} else if requestId != "" {
+		fmt.Fprint(w, s.asyncResponse(requestId))
+	} else {
+		fmt.Fprint(w, successResponse)
+	}
+}
 
-	number = r.Form["phoneNumber"][0]
+//Cancel the pickup for a phone number and move it to past pickups. Dispatchers may cancel any pickup, riders only the pickup for their own phone number on the device that requested it. Returns the canceled pickup, and the request id of the queued write for async requests.
+func (s *Server) cancelPickupFor(session Session, number string, async bool) (Pickup, string, error) {
+	s.pickupsLock.Lock()
+	defer s.pickupsLock.Unlock()
 
-	//check passphrase in "phrase" parameter
-	if !isDriverPhraseCorrect(r.Form) {
-		if r.Form["phrase"][0] != pickups[number].devicePhrase && pickups[number].devicePhrase != "" {
-			fmt.Fprintf(w, wrongPasswordResponse)
-			return
+	if !hasPermission(session.Role, cancelAnyPickupPermission) {
+		if number != session.PhoneNumber {
+			return Pickup{}, "", apiErrorf(http.StatusForbidden, forbiddenCode, "riders may only cancel their own pickup")
+		}
+		if session.DeviceId != s.pickups[number].devicePhrase && s.pickups[number].devicePhrase != "" {
+			return Pickup{}, "", apiErrorf(http.StatusForbidden, deviceMismatchCode, "pickup for %v was requested from another device", number)
 		}
 	}
 
-	var tmp = pickups[number]
-	tmp.Status = inactive
-	tmp.LatestTime = time.Now()
+	tmp, exist := s.pickups[number]
+	if !exist {
+		return Pickup{}, "", apiErrorf(http.StatusNotFound, notFoundCode, "no pickup to cancel for %v", number)
+	}
+
+	if err := s.transitionPickup(&tmp, canceled, sessionActor(session), s.clock()); err != nil {
+		return Pickup{}, "", transitionError(err)
+	}
+	tmp.CompleteDriverId = session.DriverId
+	tmp.LatestTime = s.clock()
 	tmp.devicePhrase = ""
 
 	/*
@@ -600,104 +470,130 @@ func cancelPickup(w http.ResponseWriter, r *http.Request) {
 	*/
 
 	//Sync to database
-	if isAsyncRequest(r.Form) {
-		fmt.Println("async requested") //TO DO
-	} else { //Syncronous request
-		//INSERT pickup as new row into inprogress table
-		if databaseInsertPickupInPastTable(tmp) {
-			if newRows := databaseDeletePickupInCurrentTable(tmp); newRows != nil {
-				loadPickupRowsIntoMemory(&pickups, newRows, nil);
-				fmt.Fprintf(w, failResponse)
-			} else {
-				//commit changes to instance memory
-				pickups[number] = tmp
-				delete(pickups, number)
-				fmt.Fprintf(w, successResponse)
-			}
-		} else {
-			fmt.Fprintf(w, failResponse)
+	if async {
+		//commit changes to instance memory now, the INSERT and DELETE are queued
+		requestId, err := s.pickupStore.QueuePickupWrite(cancelPickupWrite, tmp, sessionActor(session))
+		if err != nil {
+			return Pickup{}, "", storeError(err)
 		}
-	} 
+		delete(s.pickups, number)
+		go s.pickupChanged(number) //runs once this request releases pickupsLock
+		return tmp, requestId, nil
+	}
+
+	//INSERT pickup into pastpickups table and DELETE it from inprogress table together
+	if err := s.pickupStore.ArchivePickup(tmp); err != nil {
+		s.loadPickupIntoMemory(number)
+		return Pickup{}, "", storeError(err)
+	}
+
+	//commit changes to instance memory
+	delete(s.pickups, number)
+	s.databaseInsertPickupEvent(tmp, statusEvent, tmp.StatusActor, tmp.StatusTime)
+	return tmp, "", nil
 }
 
-func getPickupList(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getPickupList(w http.ResponseWriter, r *http.Request, session Session) {
 	//Use RLock which locks for reading only
-	pickupsLock.RLock()	
-	defer pickupsLock.RUnlock()
+	s.pickupsLock.RLock()	
+	defer s.pickupsLock.RUnlock()
 
 	//log.Println("getPickupList()")
 
 	//bypass same origin policy
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	//parse http parameters
-	r.ParseForm()
+	if output, err := json.Marshal(s.pickups); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
 
-	//check passphrase in "phrase" parameter
-	if !isDriverPhraseCorrect(r.Form) {
-		fmt.Fprintf(w, wrongPasswordResponse)
+//Move pickup in "phoneNumber" parameter to a new status on behalf of a driver and sync to database
+func (s *Server) changePickupStatus(w http.ResponseWriter, r *http.Request, session Session, to PickupStatus) {
+	var v validator
+	number := v.formPhoneNumber(r.Form)
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
 		return
 	}
 
-	if output, err := json.Marshal(pickups); err == nil {
-		fmt.Fprintf(w, string(output[:]))
+	_, requestId, err := s.setPickupStatus(session, number, to, isAsyncRequest(r.Form))
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+	} else if requestId != "" {
+		fmt.Fprint(w, s.asyncResponse(requestId))
 	} else {
-		log.Println(err)
+		fmt.Fprint(w, successResponse)
 	}
 }
 
-func confirmPickup(w http.ResponseWriter, r *http.Request) {
-
-	log.Println("confirmPickup()")
-
-	//bypass same origin policy
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+//Move the pickup for a phone number to a new status on behalf of a driver and sync to database. Returns the changed pickup, and the request id of the queued write for async requests.
+func (s *Server) setPickupStatus(session Session, number string, to PickupStatus, async bool) (Pickup, string, error) {
+	s.pickupsLock.Lock()
+	defer s.pickupsLock.Unlock()
 
-	//parse http parameters
-	r.ParseForm()
-
-	//check passphrase in "phrase" parameter
-	if !isDriverPhraseCorrect(r.Form) {
-		fmt.Fprintf(w, failResponse)
-		return
+	tmp, exist := s.pickups[number]
+	if !exist {
+		return Pickup{}, "", apiErrorf(http.StatusNotFound, notFoundCode, "no pickup for %v", number)
 	}
 
-	if !doKeysExist(r.Form, []string{"phoneNumber"}) && areFieldsEmpty(r.Form, []string{"phoneNumber"}) {
-		log.Println("required http parameters not found for confirmPickup")
+	//only the van holding the pickup may work it
+	if tmp.VanId != 0 && tmp.VanId != session.VanId {
+		return Pickup{}, "", apiErrorf(http.StatusConflict, pickupHeldCode, "pickup %v held by van %v, not van %v", number, tmp.VanId, session.VanId)
 	}
 
-	var number string
+	if err := s.transitionPickup(&tmp, to, sessionActor(session), s.clock()); err != nil {
+		return Pickup{}, "", transitionError(err)
+	}
 
-	number = r.Form["phoneNumber"][0]
+	if to == confirmed {
+		tmp.ConfirmDriverId = session.DriverId
 
-	var tmp = pickups[number]
-	tmp.Status = confirmed
-	tmp.ConfirmTime = time.Now()
+		//confirming an unassigned pickup claims it for the driver's van
+		if tmp.VanId == 0 && session.VanId != 0 {
+			tmp.VanId = session.VanId
+			tmp.DriverId = session.DriverId
+			tmp.SuggestedVanId = 0
+		}
+	} else if to.isTerminal() {
+		tmp.CompleteDriverId = session.DriverId
+		tmp.retireTime = tmp.StatusTime.Add(pickupRetireDelay)
+	}
 
 	//Sync to database
-	if isAsyncRequest(r.Form) {
-		fmt.Println("async requested") //TO DO
-	} else { //Syncronous request
-		//INSERT pickup as new row into inprogress table
-		if newRows :=  databaseUpdatePickupStatusInCurrentTable(tmp, confirmed); newRows != nil {
-			loadPickupRowsIntoMemory(&pickups, newRows, nil);
-			fmt.Fprintf(w, failResponse)
-		} else {
-			//increment pickup counter in tmp struct
-			tmp.version = tmp.version+1
-
-			//commit changes to instance memory
-			pickups[number] = tmp
-			fmt.Fprintf(w, successResponse)
+	if async {
+		//queue the UPDATE with the version the pickup was read with, then commit changes to instance memory
+		requestId, err := s.pickupStore.QueuePickupWrite(statusPickupWrite, tmp, sessionActor(session))
+		if err != nil {
+			return Pickup{}, "", storeError(err)
 		}
-	} 
+		tmp.version = tmp.version+1
+		s.pickups[number] = tmp
+		go s.pickupChanged(number) //runs once this request releases pickupsLock
+		return tmp, requestId, nil
+	}
+
+	//finished pickups are copied to pastpickups in the same transaction and deleted by the retire sweep
+	if err := s.pickupStore.UpdatePickup(tmp); err != nil {
+		s.loadPickupIntoMemory(number)
+		return Pickup{}, "", storeError(err)
+	}
+
+	//increment pickup counter in tmp struct
+	tmp.version = tmp.version+1
+
+	//commit changes to instance memory
+	s.pickups[number] = tmp
+	s.databaseInsertPickupEvent(tmp, statusEvent, tmp.StatusActor, tmp.StatusTime)
+	return tmp, "", nil
 }
 
-func completePickup(w http.ResponseWriter, r *http.Request) {
-	pickupsLock.Lock()
-	defer pickupsLock.Unlock()
-	
-	log.Println("completePickup()")
+func (s *Server) confirmPickup(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("confirmPickup()")
 
 	//bypass same origin policy
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -705,105 +601,61 @@ func completePickup(w http.ResponseWriter, r *http.Request) {
 	//parse http parameters
 	r.ParseForm()
 
-	//check passphrase in "phrase" parameter
-	if !isDriverPhraseCorrect(r.Form) {
-		fmt.Fprintf(w, failResponse)
-		return
-	}
+	s.changePickupStatus(w, r, session, confirmed)
+}
 
-	if !doKeysExist(r.Form, []string{"phoneNumber"}) && areFieldsEmpty(r.Form, []string{"phoneNumber"}) {
-		log.Println("required http parameters not found for completePickup")
-	}
+func (s *Server) completePickup(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("completePickup()")
 
-	var number string
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	number = r.Form["phoneNumber"][0]
+	//parse http parameters
+	r.ParseForm()
 
-	var tmp = pickups[number]
-	tmp.Status = completed
-	tmp.CompleteTime = time.Now()
+	s.changePickupStatus(w, r, session, completed)
+}
 
-	//Sync to database
-	if isAsyncRequest(r.Form) {
-		fmt.Println("async requested") //TO DO
-	} else { //Syncronous request
-		//INSERT pickup as new row into inprogress table
-		if newRows :=  databaseUpdatePickupStatusInCurrentTable(tmp, completed); newRows != nil {
-			loadPickupRowsIntoMemory(&pickups, newRows, nil);
-			fmt.Fprintf(w, failResponse)
-		} else {
-			databaseInsertPickupInPastTable(tmp)
-			//increment pickup counter in tmp struct
-			tmp.version = tmp.version+1
+//Report progress on a confirmed pickup with the "status" parameter set to enRoute, arrived or noShow
+func (s *Server) updatePickupStatus(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("updatePickupStatus()")
 
-			//commit changes to instance memory
-			pickups[number] = tmp
-			fmt.Fprintf(w, successResponse)
-		}
-	}
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	/*
-	//perform UPDATE, INSERT, DELETE in order
-	*/
-	//Deferred delete
-	go func() {
-		time.Sleep(1 * time.Minute) //DELETE from table after 1 minute to allow device to get completed status
-		if databaseDeletePickupInCurrentTable(tmp) != nil { 
-			log.Println("Deferred DELETE of completed pickup failed")
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	var to PickupStatus
+	if value, exist := v.required(r.Form, "status"); exist {
+		to = v.status("status", value)
+		if !v.failed("status") && to != enRoute && to != arrived && to != noShow {
+			v.addError("status", "must be enRoute, arrived or noShow")
 		}
+	}
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
 
-		//maybe should clear device phrase for phone number at this time, will test at some point to find issues
-	}()
+	s.changePickupStatus(w, r, session, to)
 }
 
-//Check *(sql.DB) handle initialized and connected
-func checkDatabaseHandleValid(targetHandle *(sql.DB)) bool {
-	if db != nil {
-		if err := db.Ping(); err == nil {
+//Check *(sql.DB) handle initialized and connected. Servers without a database have a nil handle.
+func checkDatabaseHandleValid(targetHandle *(sql.DB), logger *log.Logger) bool {
+	if targetHandle != nil {
+		if err := targetHandle.Ping(); err == nil {
 			return true
 		} else {
-			fmt.Println("DB ping failed.")
+			logger.Println("DB ping failed.")
 		}
-	} else {
-		log.Println("DB handle is nil")
 	}
 	return false
 }
 
-
-
-func server(wg *sync.WaitGroup) {
-	//general functions
-	http.HandleFunc("/", aboutHandler)
-	http.HandleFunc("/uptime", uptimeHandler)
-
-	//pickupee functions
-	http.HandleFunc("/newPickup", newPickup)
-	http.HandleFunc("/getPickupInfo", getPickupInfo)
-	http.HandleFunc("/getVanLocations", getVanLocations)
-
-	//shared functions
-	http.HandleFunc("/cancelPickup", cancelPickup)
-
-	//driver functions
-	http.HandleFunc("/getPickupList", getPickupList)
-	http.HandleFunc("/confirmPickup", confirmPickup)
-	http.HandleFunc("/completePickup", completePickup)
-	http.HandleFunc("/updateVanLocation", updateVanLocation)
-
-	//test functions
-	http.HandleFunc("/asyncTest", asyncTest)
-
-	//bind to $PORT environment variable
-	err := http.ListenAndServe(":"+os.Getenv("PORT"), nil)
-	fmt.Println("Listening on " + os.Getenv("PORT"))
-	if err != nil {
-		log.Println(err)
-	}
-
-	wg.Done()
-}
-
+//Mirror a pickup row deleted by another instance. This follows the database rather than making a status change, so it bypasses transitionPickup.
 func setPickupToInactiveInMemory(targetMap *map[string]Pickup, targetPhoneNumber string) {
 	tmp := (*targetMap)[targetPhoneNumber]
 	tmp.Status = inactive
@@ -811,414 +663,167 @@ func setPickupToInactiveInMemory(targetMap *map[string]Pickup, targetPhoneNumber
 	(*targetMap)[targetPhoneNumber] = tmp
 }
 
-//anything that is not inactive is set to inactive
-func removeInactivePickups(targetMap *map[string]Pickup, timeDifference time.Duration) {
-	//_ = k
-	for _, v := range *targetMap {
-		if v.Status != inactive && time.Since(v.LatestTime) > timeDifference { //only check active pickups
-			//delete(*targetMap, k) do not delete, because we want to preserve the pickup records
-			
-			/*
-			v.Status = inactive
-			*/
-			//we comment out above below and query db commands so that the device phrase is clear after a timeout, but the pickup is still there for accountability
-			//this way someone who reset theri phone or uses a new phone is get to use the same number after so many minutes
-			v.devicePhrase = "" 
-			/*
-			(*targetMap)[k] = v
-			*/
-
-			/*
-			//perform UPDATE, INSERT, DELETE in order
-			serialChannel <- func() { databaseUpdatePickupStatusInCurrentTable(v, inactive) }
-			serialChannel <- func() { databaseInsertPickupInPastTable(v) }
-			serialChannel <- func() { databaseDeletePickupInCurrentTable(v) }
-			*/
+//Expire pending and confirmed pickups whose rider has not reported within timeDifference, and free the phone number of other active pickups for a new device
+func (s *Server) removeInactivePickups(timeDifference time.Duration) {
+	var timedOut []Pickup
+	var expiring []Pickup
+	now := s.clock()
+
+	s.pickupsLock.Lock()
+	for k, v := range s.pickups {
+		if v.Status.isActive() && v.devicePhrase != "" && now.Sub(v.LatestTime) > timeDifference { //only check active pickups that have not timed out yet
+			//clear the device phrase so someone who reset their phone or uses a new phone gets to use the same number after so many minutes
+			v.devicePhrase = ""
+
+			//pending and confirmed pickups expire and are retired like finished pickups, pickups a van is already on its way to are left for the driver to finish
+			if canTransitionPickup(v.Status, expired) {
+				s.transitionPickup(&v, expired, systemActor, now)
+				v.retireTime = v.StatusTime.Add(pickupRetireDelay)
+				expiring = append(expiring, v)
+				continue
+			}
+
+			s.pickups[k] = v
+			timedOut = append(timedOut, v)
 		}
 	}
-}
-
-func removeInactiveVanLocations(targetArray []Location, timeDifference time.Duration) {
-	var numberOfEmptyLocations int
-
-	for i := 0; i < len(targetArray); i++ {
-
-		fmt.Println(time.Since((targetArray)[i].latestTime))
+	s.pickupsLock.Unlock()
 
-		if (targetArray[i].latestTime != time.Time{} && time.Since((targetArray)[i].latestTime) > timeDifference) {
-			/*
-			fmt.Println(time.Since((targetArray)[i].latestTime))
-			fmt.Println(timeDifference)
-			*/
-
-			targetArray[i].Latitude = 0
-			targetArray[i].Longitude = 0
-			targetArray[i].latestTime = time.Time{}
-			numberOfEmptyLocations++
+	//write expirations and record timeouts after releasing the lock so handlers are not blocked on the database
+	var expiredNumbers []string
+	for _, v := range expiring {
+		if err := s.pickupStore.UpdatePickup(v); err != nil {
+			s.logger.Println(err)
+			s.reloadPickup(v.PhoneNumber)
+			continue
+		}
 
-		} else if (targetArray[i].latestTime == time.Time{}) {
-			numberOfEmptyLocations++
+		//keep the expiration in memory unless the pickup changed while it was written, then take what the store has
+		s.pickupsLock.Lock()
+		if current, exist := s.pickups[v.PhoneNumber]; exist && current.version == v.version && current.InitialTime.Equal(v.InitialTime) {
+			v.version = v.version + 1
+			s.pickups[v.PhoneNumber] = v
+		} else {
+			s.loadPickupIntoMemory(v.PhoneNumber)
 		}
-	}
+		s.pickupsLock.Unlock()
 
-	//if there are 'len' empty locations in the array, no vans are around so realloc array to size 0
-	if numberOfEmptyLocations == len(targetArray) {
-		vanLocations = make([]Location, 0)
+		timedOut = append(timedOut, v)
+		expiredNumbers = append(expiredNumbers, v.PhoneNumber)
 	}
-}
-
-func checkForInactive(wg *sync.WaitGroup) {
-	t := time.NewTicker(time.Duration(30) * time.Second)
-	for now := range t.C {
-		now = now
-		go removeInactivePickups(&pickups, time.Duration(5)*time.Minute)
-		go removeInactiveVanLocations(vanLocations, time.Duration(10)*time.Minute)
+	for _, v := range timedOut {
+		s.databaseInsertPickupEvent(v, timeoutEvent, systemActor, now)
 	}
-	wg.Done()
-}
-
-//Get updated table from database and return *(sql.Rows)
-func selectRowsFromTable(targetTable string) *(sql.Rows) {
-	//we construct the SELECT query in Go because SQL does not support ordinal marker for table names
-	query := fmt.Sprintf("SELECT * from %v;", targetTable)
-	rows, err := db.Query(query)
-	if err != nil {
-		log.Println(err)
-	} else {
-		return rows
+	for _, v := range expiredNumbers {
+		s.pickupChanged(v)
 	}
-	return nil
 }
 
-//Get specific updated row from table from database and return *(sql.Rows)
-func selectRowsFromTableByPhoneNumber(targetTable string, targetPhoneNumber string) *(sql.Rows) {
-	//we construct the SELECT query in Go because SQL does not support ordinal marker for table names
-	query := fmt.Sprintf("SELECT * from %v WHERE PhoneNumber = $1;", targetTable)
-	rows, err := db.Query(query, targetPhoneNumber)
-	if err != nil {
-		log.Println(err)
-	} else {
-		return rows
-	}
-	return nil
+//Clear locations of vans that stopped reporting and return the ids of those vans
+func (s *Server) removeInactiveVanLocations(timeDifference time.Duration) []int {
+	return s.vanLocations.clearInactive(s.clock(), timeDifference)
 }
 
-//Scan a passed in *(sql.Rows) and load into passed map. Don't lock, this should be called from some syncronous methods
-func loadPickupRowsIntoMemory(targetMap *map[string]Pickup, targetRows *(sql.Rows), notificationObj *pq.Notification) int {
-	var countOfRows = 0
-	for targetRows.Next() {
-		var tmpPickup Pickup
+//Load settings and vans changed on other instances, and sweep inactive pickups, vans, sessions, finished pickups and finished queued writes, every 30 seconds until the server is closed
+func (s *Server) checkForInactive() {
+	t := time.NewTicker(time.Duration(30) * time.Second)
+	defer t.Stop()
 
-		if err := targetRows.Scan(&tmpPickup.PhoneNumber, &tmpPickup.devicePhrase, &tmpPickup.InitialLocation.Latitude, &tmpPickup.InitialLocation.Longitude, &tmpPickup.InitialTime, &tmpPickup.LatestLocation.Latitude, &tmpPickup.LatestLocation.Longitude, &tmpPickup.LatestTime, &tmpPickup.ConfirmTime, &tmpPickup.CompleteTime, &tmpPickup.Status, &tmpPickup.version); err != nil {
-			log.Println(err)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
 		}
-		
-		fmt.Printf("Loaded existing pickup for %v\n", tmpPickup.PhoneNumber)
-		pickups[tmpPickup.PhoneNumber] = tmpPickup
-		countOfRows++
-	}
-	targetRows.Close()
-	log.Printf("Finished loading %v pickups.\n", countOfRows)
 
-	//Handle DELETE of a row. If no rows are returned, that means the row was deleted
-	if countOfRows == 0 && notificationObj != nil{
-		log.Println("Row", notificationObj.Extra, "deleted from database. Set to inactive in memory.")
-		setPickupToInactiveInMemory(&pickups, notificationObj.Extra);
+		s.loadConfig()
+		s.loadVans()
+		go s.removeInactivePickups(s.configMinutes("pickupTimeoutMinutes"))
+		go func() {
+			staleVanIds := s.removeInactiveVanLocations(s.configMinutes("vanTimeoutMinutes"))
+			s.publishBoardVansRemoved(staleVanIds)
+			s.redispatchPickups(staleVanIds)
+		}()
+		go s.databaseDeleteExpiredSessions()
+		go s.retireFinishedPickups()
+		go s.deleteFinishedWrites()
 	}
-	return countOfRows
-
-	/*
-			//Currently no built in  database/sql way to handle a column that can be time.Time or NULL. Need to write own method at some point.
-
-			var tmpConfirmTime sql.NullString
-			var tmpCompleteTime sql.NullString
-
-			layout := "2016-01-19 22:25:13.047371"
-			if tmpConfirmTime.Valid {
-				timeStamp, err := time.Parse(layout, tmpConfirmTime.String)
-				if err != nil {
-					tmpPickup.ConfirmTime = timeStamp
-				} else {
-					tmpPickup.ConfirmTime = time.Time{}
-				}
-			} else {
-				tmpPickup.ConfirmTime = time.Time{}
-			}
-
-			if tmpCompleteTime.Valid {
-				timeStamp, err := time.Parse(layout, tmpCompleteTime.String)
-				if err != nil {
-					tmpPickup.CompleteTime = timeStamp
-				} else {
-					tmpPickup.CompleteTime = time.Time{}
-				}
-			} else {
-				tmpPickup.CompleteTime = time.Time{}
-			}
-		*/
 }
 
-//Scan a passed in *(sql.Rows) and load into memory
-func loadVanLocationRowsIntoMemory(targetRows *(sql.Rows)) {
-	var countOfRows = 0
-	for targetRows.Next() {
-		var vanId int
-		var tmpLocation Location
-		var version int
-
-		if err := targetRows.Scan(&vanId, &tmpLocation.Latitude, &tmpLocation.Longitude, &tmpLocation.latestTime, &version); err != nil {
-			log.Println(err)
-		}
-
-		fmt.Printf("Loaded existing van location for van ID %v\n", vanId)
-
-		for len(vanLocations) < vanId {
-			vanLocations = append(vanLocations, Location{})
-		}
-
-		vanLocations[vanId-1] = tmpLocation
-		countOfRows++
+//A van's location changed in memory: wake rider streams that may show it and update the board
+func (s *Server) vanChanged(vanId int) {
+	if _, registered := s.vans.get(vanId); !registered {
+		return
 	}
-	targetRows.Close()
-	log.Printf("Finished loading %v van locations.\n", countOfRows)
+	vanLocation, _ := s.vanLocations.get(vanId)
+	s.notifyPickupSubscribers("")
+	s.publishBoardVan(vanId, vanLocation)
 }
 
-func setupTable(tableName string, query string) bool{
-	if !checkDatabaseHandleValid(db) {
-		return false
-	}
+//Run the shipmate command: the server on $PORT, or the driver and migrate subcommands when args starts with one. Returns the process exit code.
+func Main(args []string) int {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
 
-	var tableExist bool
-	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = 'public' AND table_name = $1);", tableName).Scan(&tableExist); err != nil {
-		log.Println(err)
+	//Create db handle
+	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	if err != nil {
+		logger.Println(err)
+		return 1
 	}
-	if !tableExist {
-		if _, err := db.Exec(query); err != nil {
-			log.Println(err)
-		} else {
-			return true
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Println(err)
 		}
-	} else {
-		return true
-	}
-	return false
-}
+	}()
 
-func setupRequiredTables() {
-	//setup Pickups in progress table
-	if setupTable("inprogress", `CREATE TABLE inprogress (PhoneNumber CHAR(10) NOT NULL,
-		DeviceId VARCHAR(36) NOT NULL,
-		InitialLatitude REAL NOT NULL,
-		InitialLongitude REAL NOT NULL,
-		InitialTime TIMESTAMP NOT NULL,
-		LatestLatitude REAL NOT NULL,
-		LatestLongitude REAL NOT NULL,
-		LatestTime TIMESTAMP NOT NULL,
-		ConfirmTime TIMESTAMP NOT NULL,
-		CompleteTime TIMESTAMP NOT NULL,
-		Status INT NOT NULL,
-		Version INT NOT NULL DEFAULT 0, 
-		CONSTRAINT inprogress_pkey PRIMARY KEY (PhoneNumber, DeviceId, InitialTime), 
-		CONSTRAINT Check_PhoneNumber_inprogress CHECK (CHAR_LENGTH(PhoneNumber) = 10));`) {
-		log.Println("Pickups in progress table already exists/created. ")
-
-		//load in inprogress pickups from database
-		if rows := selectRowsFromTable("inprogress"); rows != nil {
-			loadPickupRowsIntoMemory(&pickups, rows, nil)
-		} else {
-			log.Println("Loading inprogress table returned nil object")
-		}
-	}
+	options := []Option{WithLogger(logger), WithDatabase(db, os.Getenv("DATABASE_URL"))}
 
-	//setup Pickups past table
-	if setupTable("pastpickups", `CREATE TABLE pastpickups (PhoneNumber CHAR(10) NOT NULL,
-		DeviceId VARCHAR(36) NOT NULL,
-		InitialLatitude REAL NOT NULL,
-		InitialLongitude REAL NOT NULL,
-		InitialTime TIMESTAMP NOT NULL,
-		LatestLatitude REAL NOT NULL,
-		LatestLongitude REAL NOT NULL,
-		LatestTime TIMESTAMP NOT NULL,
-		ConfirmTime TIMESTAMP NOT NULL,
-		CompleteTime TIMESTAMP NOT NULL,
-		Status INT NOT NULL,
-		Version INT NOT NULL DEFAULT 0,
-		CONSTRAINT Check_PhoneNumber_pastpickups CHECK (CHAR_LENGTH(PhoneNumber) = 10));`) {
-		log.Println("Pickups past table already exists/created. ")
+	//Run account management subcommand instead of the server, e.g. "shipmate driver add jsmith"
+	if len(args) > 0 && args[0] == "driver" {
+		return NewServer(options...).driverCommand(args[1:])
 	}
 
-	//setup Van locations table
-	if setupTable("vanlocations", `CREATE TABLE vanlocations (VanId INT NOT NULL PRIMARY KEY,
-		LatestLatitude REAL NOT NULL,
-		LatestLongitude REAL NOT NULL,
-		LatestTime TIMESTAMP NOT NULL,
-		Version INT NOT NULL DEFAULT 0);`) {
-		log.Println("Van locations table already exists/created.")
-
-		//load in van locations from database
-		if rows := selectRowsFromTable("vanlocations"); rows != nil {
-			loadVanLocationRowsIntoMemory(rows)
-			//5hr10min time difference due to server 
-			removeInactiveVanLocations(vanLocations, time.Duration(10)*time.Minute)
-		} else {
-			log.Println("Loading vanlocations table returned nil object")
-		}
-	}
-}
-
-func setupDatabaseListener() {
-	if !checkDatabaseHandleValid(db) {
-		return
+	//Run schema subcommand instead of the server, e.g. "shipmate migrate status"
+	if len(args) > 0 && args[0] == "migrate" {
+		return NewServer(options...).migrateCommand(args[1:])
 	}
 
-	//create/replace function for notifyPhoneNumber()
-	if _, err := db.Exec(`CREATE or REPLACE FUNCTION notifyPhoneNumber() RETURNS trigger AS $$
- 			BEGIN  
-  			  IF TG_OP='DELETE' THEN
-    				EXECUTE FORMAT('NOTIFY notifyphonenumber, ''%s''', OLD.PhoneNumber); 
-  				ELSE
-    				EXECUTE FORMAT('NOTIFY notifyphonenumber, ''%s''', NEW.PhoneNumber); 
-  				END IF;
-  			RETURN NULL;
- 			END;  
-		$$ LANGUAGE plpgsql;`); err != nil {
-		log.Println(err)
+	//Load signing key for session access tokens
+	if secret := os.Getenv("SHIPMATE_TOKEN_SECRET"); !isFieldEmpty(secret) {
+		options = append(options, WithTokenSecret([]byte(secret)))
 	} else {
-		log.Println("Successfully create/replace function notifyPhoneNumber()")
+		logger.Println("SHIPMATE_TOKEN_SECRET not set.")
 	}
 
-	//check for trigger existence
-	var triggerExist bool
-	if err := db.QueryRow(`SELECT EXISTS(
-		SELECT 1
-			FROM pg_trigger
-			WHERE tgname='inprogresschange')`).Scan(&triggerExist); err != nil {
-		log.Println(err)
-	}
-	if !triggerExist {
-		if _, err := db.Exec(`CREATE TRIGGER inprogresschange AFTER INSERT OR UPDATE OR DELETE
- 			ON inprogress
- 			FOR EACH ROW 
- 			EXECUTE PROCEDURE notifyPhoneNumber();`); err != nil {
-			log.Println(err)
-		} else {
-			log.Println("Successfully create trigger inprogresschange")
-		}
-	} else {
-		log.Println("Trigger inprogresschange exists.")
-	}
+	//Choose how verification codes are texted
+	options = append(options, WithSMSSender(smsSenderFromEnvironment(logger)))
 
-	//Create handler for logging listener errors
-	reportProblem := func(ev pq.ListenerEventType, err error) {
-		if err != nil {
-			fmt.Println(err.Error())
-		}
+	//Choose where pickups and van locations are kept with SHIPMATE_STORE
+	if os.Getenv("SHIPMATE_STORE") == "memory" {
+		options = append(options, WithMemoryStore())
+		logger.Println("Keeping pickups and van locations in memory. They are lost on restart and not shared between instances.")
 	}
 
-	//Listen for table updates
-	var listenerObj *pq.Listener
-	listenerObj = pq.NewListener(os.Getenv("DATABASE_URL"), 10 * time.Second, time.Minute, reportProblem);
+	s := NewServer(options...)
 
-	err := listenerObj.Listen("notifyphonenumber")
-	if err != nil {
-		log.Println(err)
-	}
+	//Migrate, load pickups and van locations into memory, follow changes made by other instances and start the sweeps
+	s.Start()
+	defer s.Close()
 
-	//Find our session PID so we can ignore notifications from ourselves
-	var pid int
-	fmt.Println("Getting session PID...")
-	if rows, err := db.Query(`SELECT * 
-		FROM pg_stat_activity 
-		WHERE pid = pg_backend_pid();`); err != nil {
-		log.Println(err)
-	} else {
-		var i interface{}; //empty interface to read unneeded columns into
-		for rows.Next() {
-			if err := rows.Scan(&i, &i, &pid, &i, &i, &i, &i, &i, &i, &i, &i, &i, &i, &i, &i, &i, &i, &i); err != nil {
-				log.Println(err)
-			} else {
-				fmt.Println("Session PID:", pid)
-			}
-		}
-	}
+	fmt.Println("Finished setting up.")
 
-	//Monitor for noitification in background
-	go func() {
-		for {
-			var notificationObj *pq.Notification
-			notificationObj = <-listenerObj.Notify
-			fmt.Printf("Backend PID %v\nChannel %v\nPayload %v\n", notificationObj.BePid, notificationObj.Channel, notificationObj.Extra)
-			//Get updated row from database if the notifying PID is not this instance's PID
-			if pid != notificationObj.BePid {
-				if updatedRows := selectRowsFromTableByPhoneNumber("inprogress", notificationObj.Extra); updatedRows == nil {
-					log.Println(err)
-				} else {
-					//We handle the possibility of deleted rows in laod pickups rows into memory since we can only enumerate over rows object once
-					loadPickupRowsIntoMemory(&pickups, updatedRows, notificationObj);
-				}
+	//serve the gRPC service for driver tablets on $GRPC_PORT
+	if port := os.Getenv("GRPC_PORT"); !isFieldEmpty(port) {
+		go func() {
+			if err := s.ListenAndServeGRPC(":" + port); err != nil {
+				logger.Println(err)
 			}
-		}
-	}()
-}
-
-func main() {
-	pickups = make(map[string]Pickup)
-	pickupsLock = new(sync.RWMutex)
-
-	vanLocations = make([]Location, 0)
-	generateSuccessResponse(&successResponse)
-	generateFailResponse(&failResponse)
-	generateWrongPasswordResponse(&wrongPasswordResponse)
-
-	//Create global db handle
-	var err error //define err because mixing it with the global db var and := operator creates local scoped db
-	db, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
+		}()
 	}
 
-	//Create inprogress, pastpickups, vanlocations tables and local existing pickups
-	setupRequiredTables()
-
-	//Create listener for database
-	setupDatabaseListener()
-
-	//create channel of function type
-	serialChannel = make(chan func())
-	//spawn go routine to continuously read and run functions in the channel
-	go func() {
-		for true {
-			tmp := <-serialChannel
-			tmp()
-		}
-	}()
-	/*
-		//test the serialChannel
-		serialChannel <- func() { log.Println("i=1")}
-		serialChannel <- func() { log.Println("i=2")}
-		serialChannel <- func() { log.Println("i=3")}
-		serialChannel <- func() { log.Println("i=4")}
-	*/
-
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go server(&wg)
-	go checkForInactive(&wg)
-
-	/*
-		result, err = db.Exec("INSERT INTO inprogress (PhoneNumber, DeviceId, InitialLatitude, InitialLongitude, InitialTime, LatestLatitude, LatestLongitude, LatestTime, ConfirmTime, CompleteTime, Status) VALUES ('5103868680', '68753A44-4D6F-1226-9C60-0050E4C00067', 38.9844, 76.4889, '2002-10-02T10:00:00-05:00', 38.9844, 76.4889, '2002-10-02T10:00:00-05:00', DEFAULT, DEFAULT, 0); ")
-		fmt.Println(result)
-	*/
-
-	
-
-	fmt.Println("Finished setting up.")
-
-	wg.Wait()
-
-	err = db.Close()
-	if err != nil {
-		log.Println(err)
+	//bind to $PORT environment variable
+	if err := s.ListenAndServe(":" + os.Getenv("PORT")); err != nil {
+		logger.Println(err)
 	}
+	return 1
 }
@@ -0,0 +1,320 @@
+package shipmate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Pickups, vans and van locations kept in process, for tests and local development without Postgres. Writes follow the same version rules as the Postgres store.
+type memoryStore struct {
+	lock           sync.Mutex
+	current        []Pickup //rows of the inprogress table
+	past           []Pickup //rows of the pastpickups table
+	vans           map[int]Van //rows of the vans table
+	vanLocations   map[int]Location
+	queuedWrites   map[string]queuedWrite
+	clock          func() time.Time //time of queued writes
+	pickupsChanged []func(phoneNumber string, local bool)
+	vansChanged    []func(vanId int, local bool)
+	writesFinished []func(targetWrite queuedWrite, targetPickup Pickup)
+}
+
+//Start with vans 1 to 5 registered, like the migration that created the vans table
+func newMemoryStore(clock func() time.Time) *memoryStore {
+	s := &memoryStore{clock: clock, current: make([]Pickup, 0), past: make([]Pickup, 0), vans: make(map[int]Van), vanLocations: make(map[int]Location), queuedWrites: make(map[string]queuedWrite)}
+	for i := 1; i <= 5; i++ {
+		s.vans[i] = Van{Id: i, Callsign: fmt.Sprintf("Van %v", i), Active: true, Accessibility: make([]string, 0)}
+	}
+	return s
+}
+
+//Tell watchers about a write. Watchers run in the background like notifications do, so writers may hold pickupsLock.
+func (s *memoryStore) pickupWritten(phoneNumber string) {
+	for _, changed := range s.pickupsChanged {
+		go changed(phoneNumber, true)
+	}
+}
+
+func (s *memoryStore) CreatePickup(targetPickup Pickup) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, v := range s.current {
+		if v.PhoneNumber == targetPickup.PhoneNumber && v.devicePhrase == targetPickup.devicePhrase && v.InitialTime.Equal(targetPickup.InitialTime) {
+			return errStalePickup
+		}
+	}
+
+	targetPickup.version = 0
+	s.current = append(s.current, targetPickup)
+	s.pickupWritten(targetPickup.PhoneNumber)
+	return nil
+}
+
+func (s *memoryStore) UpdatePickup(targetPickup Pickup) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var updated int
+	for i, v := range s.current {
+		if v.PhoneNumber != targetPickup.PhoneNumber || v.version != targetPickup.version || !v.InitialTime.Equal(targetPickup.InitialTime) {
+			continue
+		}
+		v.Status = targetPickup.Status
+		v.version = targetPickup.version + 1
+		v.ConfirmDriverId = targetPickup.ConfirmDriverId
+		v.CompleteDriverId = targetPickup.CompleteDriverId
+		v.ConfirmTime = targetPickup.ConfirmTime
+		v.CompleteTime = targetPickup.CompleteTime
+		v.StatusActor = targetPickup.StatusActor
+		v.StatusTime = targetPickup.StatusTime
+		v.VanId = targetPickup.VanId
+		v.DriverId = targetPickup.DriverId
+		v.SuggestedVanId = targetPickup.SuggestedVanId
+		v.retireTime = targetPickup.retireTime
+		s.current[i] = v
+		updated++
+	}
+	if updated == 0 {
+		return errStalePickup
+	}
+
+	if targetPickup.Status.isTerminal() {
+		s.past = append(s.past, targetPickup)
+	}
+	s.pickupWritten(targetPickup.PhoneNumber)
+	return nil
+}
+
+func (s *memoryStore) UpdatePickupLocation(targetPickup Pickup) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, v := range s.current {
+		if v.PhoneNumber == targetPickup.PhoneNumber && v.InitialTime.Equal(targetPickup.InitialTime) {
+			s.current[i].LatestLocation = targetPickup.LatestLocation
+			s.current[i].LatestTime = targetPickup.LatestTime
+			s.pickupWritten(targetPickup.PhoneNumber)
+			return nil
+		}
+	}
+	return errStalePickup
+}
+
+func (s *memoryStore) ArchivePickup(targetPickup Pickup) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, v := range s.current {
+		if v.PhoneNumber == targetPickup.PhoneNumber && v.InitialTime.Equal(targetPickup.InitialTime) {
+			s.current = append(s.current[:i], s.current[i+1:]...)
+			s.past = append(s.past, targetPickup)
+			s.pickupWritten(targetPickup.PhoneNumber)
+			return nil
+		}
+	}
+	return errStalePickup
+}
+
+func (s *memoryStore) RetireFinishedPickups(now time.Time) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	kept := make([]Pickup, 0, len(s.current))
+	retired := make([]string, 0)
+	for _, v := range s.current {
+		if (v.retireTime != time.Time{}) && !v.retireTime.After(now) {
+			s.pickupWritten(v.PhoneNumber)
+			retired = append(retired, v.PhoneNumber)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	s.current = kept
+	return retired, nil
+}
+
+func (s *memoryStore) GetPickup(phoneNumber string) (Pickup, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var newest Pickup
+	var exist bool
+	for _, v := range s.current {
+		if v.PhoneNumber == phoneNumber && (!exist || v.InitialTime.After(newest.InitialTime)) {
+			newest = v
+			exist = true
+		}
+	}
+	return newest, exist, nil
+}
+
+func (s *memoryStore) ListPickups() ([]Pickup, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Pickup{}, s.current...), nil
+}
+
+//Pickups moved to past pickups, oldest first
+func (s *memoryStore) ListPastPickups() []Pickup {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Pickup{}, s.past...)
+}
+
+//Apply the write straight away, there is no database to wait for. Its outcome is handled in the background like the outbox worker does.
+func (s *memoryStore) QueuePickupWrite(operation string, targetPickup Pickup, actor string) (string, error) {
+	requestId, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	tmp := queuedWrite{RequestId: requestId, Operation: operation, PhoneNumber: targetPickup.PhoneNumber, State: outboxCommitted, Attempts: 1, CreatedTime: s.clock(), actor: actor}
+
+	if err := writePickupTo(s, operation, targetPickup); err == errStalePickup {
+		tmp.State = outboxRolledBack
+		tmp.Error = "stale version"
+	} else if err != nil {
+		tmp.State = outboxFailed
+		tmp.Error = err.Error()
+	}
+	tmp.ProcessedTime = s.clock()
+
+	s.lock.Lock()
+	s.queuedWrites[tmp.RequestId] = tmp
+	for _, finished := range s.writesFinished {
+		go finished(tmp, targetPickup)
+	}
+	s.lock.Unlock()
+
+	return tmp.RequestId, nil
+}
+
+func (s *memoryStore) GetQueuedWrite(requestId string) (queuedWrite, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tmp, exist := s.queuedWrites[requestId]
+	return tmp, exist, nil
+}
+
+func (s *memoryStore) DeleteFinishedWrites(before time.Time) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var deleted int
+	for k, v := range s.queuedWrites {
+		if v.State != outboxQueued && v.ProcessedTime.Before(before) {
+			delete(s.queuedWrites, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *memoryStore) WatchPickups(changed func(phoneNumber string, local bool)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pickupsChanged = append(s.pickupsChanged, changed)
+	return nil
+}
+
+func (s *memoryStore) WatchQueuedWrites(finished func(targetWrite queuedWrite, targetPickup Pickup)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.writesFinished = append(s.writesFinished, finished)
+	return nil
+}
+
+//Nothing runs in the background, watchers are called per write
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) ListVans() ([]Van, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]Van, 0, len(s.vans))
+	for _, v := range s.vans {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	return list, nil
+}
+
+func (s *memoryStore) CreateVan(targetVan Van) error {
+	if targetVan.Id < 1 {
+		return errors.New("van ids start at 1")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, exist := s.vans[targetVan.Id]; exist {
+		return errVanExists
+	}
+	s.vans[targetVan.Id] = targetVan
+	return nil
+}
+
+func (s *memoryStore) UpdateVan(targetVan Van) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, exist := s.vans[targetVan.Id]; !exist {
+		return errNoSuchVan
+	}
+	s.vans[targetVan.Id] = targetVan
+	return nil
+}
+
+func (s *memoryStore) UpdateVanLocation(vanId int, vanLocation Location) error {
+	if vanId < 1 {
+		return errors.New("van ids start at 1")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.vanLocations[vanId] = vanLocation
+	for _, changed := range s.vansChanged {
+		go changed(vanId, true)
+	}
+	return nil
+}
+
+func (s *memoryStore) ListVanLocations() (map[int]Location, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make(map[int]Location)
+	for k, v := range s.vanLocations {
+		list[k] = v
+	}
+	return list, nil
+}
+
+func (s *memoryStore) GetVanLocation(vanId int) (Location, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tmp, exist := s.vanLocations[vanId]
+	return tmp, exist, nil
+}
+
+func (s *memoryStore) WatchVanLocations(changed func(vanId int, local bool)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.vansChanged = append(s.vansChanged, changed)
+	return nil
+}
@@ -0,0 +1,534 @@
+package shipmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+//Key of the Postgres advisory lock held while migrating, so dynos starting together take turns
+const migrationLockKey int64 = 7460278
+
+//A numbered schema change. Up applies it and Down reverses it, each in one transaction. Migrations with an empty Down cannot be rolled back.
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+//A migration recorded in the schema_migrations table
+type appliedMigration struct {
+	Version     int
+	Name        string
+	AppliedTime time.Time
+}
+
+//Every schema change in order. Never edit a migration that has shipped, add a new one instead.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		//Tables as they were created before migrations existed. Older deployments already have some of them, so everything is created only if missing.
+		Up: `CREATE TABLE IF NOT EXISTS inprogress (PhoneNumber CHAR(10) NOT NULL,
+			DeviceId VARCHAR(36) NOT NULL,
+			InitialLatitude REAL NOT NULL,
+			InitialLongitude REAL NOT NULL,
+			InitialTime TIMESTAMP NOT NULL,
+			LatestLatitude REAL NOT NULL,
+			LatestLongitude REAL NOT NULL,
+			LatestTime TIMESTAMP NOT NULL,
+			ConfirmTime TIMESTAMP NOT NULL,
+			CompleteTime TIMESTAMP NOT NULL,
+			Status INT NOT NULL,
+			Version INT NOT NULL DEFAULT 0,
+			CONSTRAINT inprogress_pkey PRIMARY KEY (PhoneNumber, DeviceId, InitialTime),
+			CONSTRAINT Check_PhoneNumber_inprogress CHECK (CHAR_LENGTH(PhoneNumber) = 10));
+		ALTER TABLE inprogress ADD COLUMN IF NOT EXISTS ConfirmDriverId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS CompleteDriverId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS StatusActor VARCHAR(32) NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS StatusTime TIMESTAMP NOT NULL DEFAULT '0001-01-01',
+			ADD COLUMN IF NOT EXISTS VanId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS DriverId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS SuggestedVanId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS RetireTime TIMESTAMP NOT NULL DEFAULT '0001-01-01';
+
+		CREATE TABLE IF NOT EXISTS pastpickups (PhoneNumber CHAR(10) NOT NULL,
+			DeviceId VARCHAR(36) NOT NULL,
+			InitialLatitude REAL NOT NULL,
+			InitialLongitude REAL NOT NULL,
+			InitialTime TIMESTAMP NOT NULL,
+			LatestLatitude REAL NOT NULL,
+			LatestLongitude REAL NOT NULL,
+			LatestTime TIMESTAMP NOT NULL,
+			ConfirmTime TIMESTAMP NOT NULL,
+			CompleteTime TIMESTAMP NOT NULL,
+			Status INT NOT NULL,
+			Version INT NOT NULL DEFAULT 0,
+			CONSTRAINT Check_PhoneNumber_pastpickups CHECK (CHAR_LENGTH(PhoneNumber) = 10));
+		ALTER TABLE pastpickups ADD COLUMN IF NOT EXISTS ConfirmDriverId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS CompleteDriverId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS StatusActor VARCHAR(32) NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS StatusTime TIMESTAMP NOT NULL DEFAULT '0001-01-01',
+			ADD COLUMN IF NOT EXISTS VanId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS DriverId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS SuggestedVanId INT NOT NULL DEFAULT 0;
+
+		CREATE TABLE IF NOT EXISTS vanlocations (VanId INT NOT NULL PRIMARY KEY,
+			LatestLatitude REAL NOT NULL,
+			LatestLongitude REAL NOT NULL,
+			LatestTime TIMESTAMP NOT NULL,
+			Version INT NOT NULL DEFAULT 0);
+		ALTER TABLE vanlocations ADD COLUMN IF NOT EXISTS DriverId INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS Heading REAL NOT NULL DEFAULT -1;
+
+		CREATE TABLE IF NOT EXISTS drivers (DriverId SERIAL PRIMARY KEY,
+			Username VARCHAR(64) NOT NULL UNIQUE,
+			PasswordHash VARCHAR(60) NOT NULL,
+			Enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			CreatedTime TIMESTAMP NOT NULL DEFAULT NOW());
+		ALTER TABLE drivers ADD COLUMN IF NOT EXISTS Role VARCHAR(16) NOT NULL DEFAULT 'driver',
+			ADD COLUMN IF NOT EXISTS VanId INT NOT NULL DEFAULT 0;
+
+		CREATE TABLE IF NOT EXISTS sessions (SessionId CHAR(32) NOT NULL PRIMARY KEY,
+			Role VARCHAR(16) NOT NULL,
+			DriverId INT NOT NULL DEFAULT 0,
+			PhoneNumber VARCHAR(10) NOT NULL DEFAULT '',
+			DeviceId VARCHAR(36) NOT NULL DEFAULT '',
+			RefreshTokenHash CHAR(64) NOT NULL,
+			CreatedTime TIMESTAMP NOT NULL,
+			RefreshExpireTime TIMESTAMP NOT NULL,
+			Revoked BOOLEAN NOT NULL DEFAULT FALSE);
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS VanId INT NOT NULL DEFAULT 0;
+
+		CREATE TABLE IF NOT EXISTS phoneverifications (PhoneNumber CHAR(10) NOT NULL,
+			DeviceId VARCHAR(36) NOT NULL,
+			CodeHash VARCHAR(64) NOT NULL,
+			SentTime TIMESTAMP NOT NULL,
+			ExpireTime TIMESTAMP NOT NULL,
+			Attempts INT NOT NULL DEFAULT 0,
+			VerifiedTime TIMESTAMP,
+			CONSTRAINT phoneverifications_pkey PRIMARY KEY (PhoneNumber, DeviceId),
+			CONSTRAINT Check_PhoneNumber_phoneverifications CHECK (CHAR_LENGTH(PhoneNumber) = 10));
+
+		CREATE TABLE IF NOT EXISTS pickup_events (EventId BIGSERIAL PRIMARY KEY,
+			PhoneNumber CHAR(10) NOT NULL,
+			InitialTime TIMESTAMP NOT NULL,
+			EventType VARCHAR(16) NOT NULL,
+			Status INT NOT NULL,
+			Latitude REAL NOT NULL,
+			Longitude REAL NOT NULL,
+			Actor VARCHAR(32) NOT NULL,
+			EventTime TIMESTAMP NOT NULL,
+			CONSTRAINT Check_PhoneNumber_pickup_events CHECK (CHAR_LENGTH(PhoneNumber) = 10));
+		ALTER TABLE pickup_events ADD COLUMN IF NOT EXISTS VanId INT NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS pickup_events_pickup ON pickup_events (PhoneNumber, InitialTime);
+
+		CREATE TABLE IF NOT EXISTS pickup_outbox (RequestId VARCHAR(32) NOT NULL PRIMARY KEY,
+			Sequence BIGSERIAL NOT NULL,
+			Operation VARCHAR(16) NOT NULL,
+			PhoneNumber CHAR(10) NOT NULL,
+			Payload TEXT NOT NULL,
+			Actor VARCHAR(32) NOT NULL DEFAULT '',
+			State VARCHAR(16) NOT NULL DEFAULT 'queued',
+			Attempts INT NOT NULL DEFAULT 0,
+			Error TEXT NOT NULL DEFAULT '',
+			CreatedTime TIMESTAMP NOT NULL,
+			NextAttemptTime TIMESTAMP NOT NULL,
+			ProcessedTime TIMESTAMP);
+
+		CREATE TABLE IF NOT EXISTS config (Key VARCHAR(64) NOT NULL PRIMARY KEY,
+			Value VARCHAR(255) NOT NULL,
+			UpdatedTime TIMESTAMP NOT NULL,
+			UpdatedDriverId INT NOT NULL DEFAULT 0);`,
+		//Never drop these tables, deployments from before migrations keep all of their data in them
+		Down: ``,
+	},
+	{
+		Version: 2,
+		Name:    "inprogress primary key includes InitialTime",
+		//Tables created with setupDatabase.sql were keyed by phone number and device only, so a rider could never request a second pickup from the same device while the first was still in the table
+		Up: `ALTER TABLE inprogress DROP CONSTRAINT IF EXISTS inprogress_pkey;
+		ALTER TABLE inprogress ADD CONSTRAINT inprogress_pkey PRIMARY KEY (PhoneNumber, DeviceId, InitialTime);`,
+		Down: `ALTER TABLE inprogress DROP CONSTRAINT IF EXISTS inprogress_pkey;
+		ALTER TABLE inprogress ADD CONSTRAINT inprogress_pkey PRIMARY KEY (PhoneNumber, DeviceId);`,
+	},
+	{
+		Version: 3,
+		Name:    "notify triggers",
+		//Announce changes to the inprogress and vanlocations tables to every instance. setupDatabase.sql also created inprogressdelete, which announced every delete twice.
+		Up: `CREATE OR REPLACE FUNCTION notifyPhoneNumber() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP='DELETE' THEN
+					EXECUTE FORMAT('NOTIFY notifyphonenumber, ''%s''', OLD.PhoneNumber);
+				ELSE
+					EXECUTE FORMAT('NOTIFY notifyphonenumber, ''%s''', NEW.PhoneNumber);
+				END IF;
+				RETURN NULL;
+			END;
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS inprogressdelete ON inprogress;
+		DROP TRIGGER IF EXISTS inprogresschange ON inprogress;
+		CREATE TRIGGER inprogresschange AFTER INSERT OR UPDATE OR DELETE
+			ON inprogress
+			FOR EACH ROW
+			EXECUTE PROCEDURE notifyPhoneNumber();
+
+		CREATE OR REPLACE FUNCTION notifyVanLocation() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP='DELETE' THEN
+					EXECUTE FORMAT('NOTIFY notifyvanlocation, ''%s''', OLD.VanId);
+				ELSE
+					EXECUTE FORMAT('NOTIFY notifyvanlocation, ''%s''', NEW.VanId);
+				END IF;
+				RETURN NULL;
+			END;
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS vanlocationschange ON vanlocations;
+		CREATE TRIGGER vanlocationschange AFTER INSERT OR UPDATE OR DELETE
+			ON vanlocations
+			FOR EACH ROW
+			EXECUTE PROCEDURE notifyVanLocation();`,
+		Down: `DROP TRIGGER IF EXISTS inprogresschange ON inprogress;
+		DROP FUNCTION IF EXISTS notifyPhoneNumber();
+		DROP TRIGGER IF EXISTS vanlocationschange ON vanlocations;
+		DROP FUNCTION IF EXISTS notifyVanLocation();`,
+	},
+	{
+		Version: 4,
+		Name:    "vans table",
+		//Vans were numbered 1 to 5 before they were registered, so those vans are registered to keep existing drivers reporting
+		Up: `CREATE TABLE vans (VanId INT NOT NULL PRIMARY KEY,
+			Callsign VARCHAR(32) NOT NULL,
+			Capacity INT NOT NULL DEFAULT 0,
+			Active BOOLEAN NOT NULL DEFAULT TRUE,
+			Plate VARCHAR(16) NOT NULL DEFAULT '',
+			Accessibility VARCHAR(255) NOT NULL DEFAULT '',
+			CreatedTime TIMESTAMP NOT NULL DEFAULT NOW(),
+			CONSTRAINT Check_VanId_vans CHECK (VanId > 0),
+			CONSTRAINT Check_Capacity_vans CHECK (Capacity >= 0));
+		INSERT INTO vans (VanId, Callsign) SELECT VanId, 'Van ' || VanId FROM generate_series(1, 5) AS VanId;`,
+		Down: `DROP TABLE IF EXISTS vans;`,
+	},
+	{
+		Version: 5,
+		Name:    "notify payload names the writing instance",
+		//Notifications are sent from whichever pooled connection wrote, so the backend PID cannot tell an instance its own writes apart. Instances set shipmate.instance in their write transactions and the triggers send it after the key, separated by a space.
+		Up: `CREATE OR REPLACE FUNCTION notifyPhoneNumber() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP='DELETE' THEN
+					PERFORM pg_notify('notifyphonenumber', OLD.PhoneNumber || ' ' || COALESCE(current_setting('shipmate.instance', true), ''));
+				ELSE
+					PERFORM pg_notify('notifyphonenumber', NEW.PhoneNumber || ' ' || COALESCE(current_setting('shipmate.instance', true), ''));
+				END IF;
+				RETURN NULL;
+			END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE OR REPLACE FUNCTION notifyVanLocation() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP='DELETE' THEN
+					PERFORM pg_notify('notifyvanlocation', OLD.VanId || ' ' || COALESCE(current_setting('shipmate.instance', true), ''));
+				ELSE
+					PERFORM pg_notify('notifyvanlocation', NEW.VanId || ' ' || COALESCE(current_setting('shipmate.instance', true), ''));
+				END IF;
+				RETURN NULL;
+			END;
+		$$ LANGUAGE plpgsql;`,
+		Down: `CREATE OR REPLACE FUNCTION notifyPhoneNumber() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP='DELETE' THEN
+					EXECUTE FORMAT('NOTIFY notifyphonenumber, ''%s''', OLD.PhoneNumber);
+				ELSE
+					EXECUTE FORMAT('NOTIFY notifyphonenumber, ''%s''', NEW.PhoneNumber);
+				END IF;
+				RETURN NULL;
+			END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE OR REPLACE FUNCTION notifyVanLocation() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP='DELETE' THEN
+					EXECUTE FORMAT('NOTIFY notifyvanlocation, ''%s''', OLD.VanId);
+				ELSE
+					EXECUTE FORMAT('NOTIFY notifyvanlocation, ''%s''', NEW.VanId);
+				END IF;
+				RETURN NULL;
+			END;
+		$$ LANGUAGE plpgsql;`,
+	},
+	{
+		Version: 6,
+		Name:    "outbox records the queuing instance",
+		//Instances apply their own queued writes, and only take over writes of instances that stopped
+		Up:   `ALTER TABLE pickup_outbox ADD COLUMN IF NOT EXISTS InstanceId VARCHAR(32) NOT NULL DEFAULT '';`,
+		Down: `ALTER TABLE pickup_outbox DROP COLUMN IF EXISTS InstanceId;`,
+	},
+}
+
+//Latest migration version
+func latestMigrationVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+//Find a migration by version
+func findMigration(version int) (migration, bool) {
+	for _, v := range migrations {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return migration{}, false
+}
+
+//Begin a transaction holding the migration lock, and create schema_migrations if this is the first migration. The lock is released when the transaction ends.
+func (s *Server) beginMigrationTransaction() (*sql.Tx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1);`, migrationLockKey); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (Version INT NOT NULL PRIMARY KEY,
+		Name VARCHAR(128) NOT NULL,
+		AppliedTime TIMESTAMP NOT NULL);`); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+//SELECT applied migrations from schema_migrations table, oldest first
+func selectAppliedMigrations(tx *sql.Tx) ([]appliedMigration, error) {
+	rows, err := tx.Query(`SELECT Version, Name, AppliedTime FROM schema_migrations ORDER BY Version;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]appliedMigration, 0)
+	for rows.Next() {
+		var tmp appliedMigration
+		if err := rows.Scan(&tmp.Version, &tmp.Name, &tmp.AppliedTime); err != nil {
+			return nil, err
+		}
+		list = append(list, tmp)
+	}
+	return list, rows.Err()
+}
+
+//Apply the oldest pending migration up to targetVersion. Returns the migration applied, false if there was none.
+func (s *Server) applyNextMigration(targetVersion int) (migration, bool, error) {
+	tx, err := s.beginMigrationTransaction()
+	if err != nil {
+		return migration{}, false, err
+	}
+	defer tx.Rollback()
+
+	applied, err := selectAppliedMigrations(tx)
+	if err != nil {
+		return migration{}, false, err
+	}
+	isApplied := make(map[int]bool)
+	for _, v := range applied {
+		isApplied[v.Version] = true
+	}
+
+	for _, v := range migrations {
+		if v.Version > targetVersion {
+			break
+		}
+		if isApplied[v.Version] {
+			continue
+		}
+
+		if _, err := tx.Exec(v.Up); err != nil {
+			return v, false, fmt.Errorf("migration %v %v: %v", v.Version, v.Name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (Version, Name, AppliedTime) VALUES ($1, $2, $3);`, v.Version, v.Name, time.Now()); err != nil {
+			return v, false, err
+		}
+		return v, true, tx.Commit()
+	}
+	return migration{}, false, nil
+}
+
+//Reverse the latest applied migration. Returns the migration reversed, false if none are applied.
+func (s *Server) reverseLatestMigration() (migration, bool, error) {
+	tx, err := s.beginMigrationTransaction()
+	if err != nil {
+		return migration{}, false, err
+	}
+	defer tx.Rollback()
+
+	applied, err := selectAppliedMigrations(tx)
+	if err != nil || len(applied) == 0 {
+		return migration{}, false, err
+	}
+	latest := applied[len(applied)-1]
+
+	tmp, exist := findMigration(latest.Version)
+	if !exist {
+		return migration{}, false, fmt.Errorf("migration %v %v was applied by a newer version of shipmate", latest.Version, latest.Name)
+	}
+
+	if tmp.Down == "" {
+		return tmp, false, fmt.Errorf("migration %v %v cannot be rolled back", tmp.Version, tmp.Name)
+	}
+	if _, err := tx.Exec(tmp.Down); err != nil {
+		return tmp, false, fmt.Errorf("migration %v %v: %v", tmp.Version, tmp.Name, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE Version = $1;`, tmp.Version); err != nil {
+		return tmp, false, err
+	}
+	return tmp, true, tx.Commit()
+}
+
+//Apply pending migrations up to targetVersion, one transaction each. Dynos migrating at the same time wait for each other and skip what is already applied.
+func (s *Server) migrateDatabaseTo(targetVersion int) bool {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return false
+	}
+
+	for {
+		tmp, applied, err := s.applyNextMigration(targetVersion)
+		if err != nil {
+			s.logger.Println(err)
+			return false
+		}
+		if !applied {
+			return true
+		}
+		s.logger.Printf("Applied migration %v %v.\n", tmp.Version, tmp.Name)
+	}
+}
+
+//Bring the database schema up to date
+func (s *Server) migrateDatabase() bool {
+	return s.migrateDatabaseTo(latestMigrationVersion())
+}
+
+//Reverse the latest steps migrations, newest first
+func (s *Server) rollbackDatabase(steps int) bool {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return false
+	}
+
+	for i := 0; i < steps; i++ {
+		tmp, reversed, err := s.reverseLatestMigration()
+		if err != nil {
+			s.logger.Println(err)
+			return false
+		}
+		if !reversed {
+			s.logger.Println("No migrations left to roll back.")
+			return true
+		}
+		s.logger.Printf("Rolled back migration %v %v.\n", tmp.Version, tmp.Name)
+	}
+	return true
+}
+
+//Run a schema subcommand instead of the server, e.g. "shipmate migrate status". Returns the process exit code.
+func (s *Server) migrateCommand(args []string) int {
+	usage := `Usage: shipmate migrate [up [version]]
+       shipmate migrate down [steps]
+       shipmate migrate status`
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	if len(args) > 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	//optional version for up, number of migrations for down
+	number := 0
+	if len(args) == 2 {
+		var err error
+		if number, err = strconv.Atoi(args[1]); err != nil || number < 1 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+	}
+
+	switch command {
+	case "up":
+		if number == 0 {
+			number = latestMigrationVersion()
+		}
+		if !s.migrateDatabaseTo(number) {
+			return 1
+		}
+	case "down":
+		if number == 0 {
+			number = 1
+		}
+		if !s.rollbackDatabase(number) {
+			return 1
+		}
+	case "status":
+		if err := s.printMigrationStatus(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	return 0
+}
+
+//Print every migration with the time it was applied, or pending
+func (s *Server) printMigrationStatus() error {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return errors.New("database unavailable")
+	}
+
+	//only read, a database that was never migrated is reported as it is
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SET TRANSACTION READ ONLY;`); err != nil {
+		return err
+	}
+
+	var initialized bool
+	if err := tx.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL;`).Scan(&initialized); err != nil {
+		return err
+	}
+	applied := make([]appliedMigration, 0)
+	if initialized {
+		if applied, err = selectAppliedMigrations(tx); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("Database not initialized, schema_migrations table does not exist.")
+	}
+
+	appliedTimes := make(map[int]time.Time)
+	for _, v := range applied {
+		appliedTimes[v.Version] = v.AppliedTime
+		if _, exist := findMigration(v.Version); !exist {
+			fmt.Printf("%v\t%v\tapplied %v\tunknown to this version of shipmate\n", v.Version, v.Name, v.AppliedTime.Format(time.RFC3339))
+		}
+	}
+
+	for _, v := range migrations {
+		if appliedTime, exist := appliedTimes[v.Version]; exist {
+			fmt.Printf("%v\t%v\tapplied %v\n", v.Version, v.Name, appliedTime.Format(time.RFC3339))
+		} else {
+			fmt.Printf("%v\t%v\tpending\n", v.Version, v.Name)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,349 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//OpenAPI objects are built as maps so the document is encoded with sorted keys and does not change between requests
+type openAPIObject map[string]interface{}
+
+//Schemas of path segments in /api/v1 patterns
+var pathParameterSchemas = map[string]openAPIObject{
+	"phoneNumber": {"type": "string", "pattern": "^[0-9]{10}$"},
+	"vanId":       {"type": "integer", "minimum": 1},
+	"requestId":   {"type": "string", "pattern": requestIdPattern.String()},
+}
+
+//Status of a canned legacy reply: "0" for success, "-1" for failure and "-2" when the caller must sign in again
+type legacyStatus string
+
+//Canned reply of legacy routes, with the request id of an async request or the invalid parameters of a failed one
+type statusResponse struct {
+	Status    legacyStatus `json:"status"`
+	RequestId string       `json:"requestId,omitempty"`
+	Fields    []fieldError `json:"fields,omitempty"`
+}
+
+//Builds JSON schemas of Go types the way encoding/json encodes them. Named structs become components referenced by name.
+type schemaBuilder struct {
+	components openAPIObject
+}
+
+//Component name of a named type, apiVan is "Van" and pickupInfo is "PickupInfo"
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	if strings.HasPrefix(name, "api") && len(name) > 3 && unicode.IsUpper(rune(name[3])) {
+		name = name[3:]
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) openAPIObject {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return openAPIObject{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(PickupStatus(0)):
+		var values []int
+		var names []string
+		for k := range pickupStatusNames {
+			values = append(values, int(k))
+		}
+		sort.Ints(values)
+		for _, v := range values {
+			names = append(names, fmt.Sprintf("%v %v", v, PickupStatus(v)))
+		}
+		return openAPIObject{"type": "integer", "enum": values, "description": strings.Join(names, ", ")}
+	case t == reflect.TypeOf(legacyStatus("")):
+		return openAPIObject{"type": "string", "enum": []string{"0", "-1", "-2"}, "description": "0 success, -1 failure, -2 sign in again"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return openAPIObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openAPIObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return openAPIObject{"type": "number", "format": "double"}
+	case reflect.String:
+		return openAPIObject{"type": "string"}
+	case reflect.Ptr:
+		tmp := openAPIObject{"nullable": true}
+		if element := b.schema(t.Elem()); element["$ref"] != nil {
+			tmp["allOf"] = []openAPIObject{element}
+		} else {
+			for k, v := range element {
+				tmp[k] = v
+			}
+		}
+		return tmp
+	case reflect.Slice, reflect.Array:
+		return openAPIObject{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return openAPIObject{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		name := componentName(t)
+		if _, exist := b.components[name]; !exist {
+			b.components[name] = openAPIObject{} //placeholder so recursive types terminate
+			b.components[name] = b.structSchema(t)
+		}
+		return openAPIObject{"$ref": "#/components/schemas/" + name}
+	}
+	return openAPIObject{}
+}
+
+//Properties of a struct as encoding/json encodes them. Embedded structs without a tag are flattened and fields with omitempty are optional.
+func (b *schemaBuilder) structSchema(t reflect.Type) openAPIObject {
+	properties := openAPIObject{}
+	var required []string
+	b.addFields(t, properties, &required)
+
+	tmp := openAPIObject{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		sort.Strings(required)
+		tmp["required"] = required
+	}
+	return tmp
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties openAPIObject, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(field.Type, properties, required)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		options := strings.Split(tag, ",")
+		if options[0] != "" {
+			name = options[0]
+		}
+		properties[name] = b.schema(field.Type)
+
+		omitEmpty := false
+		for _, v := range options[1:] {
+			omitEmpty = omitEmpty || v == "omitempty"
+		}
+		if !omitEmpty && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
+
+//Schema of a value used in an endpoint definition
+func (b *schemaBuilder) schemaOf(value interface{}) openAPIObject {
+	return b.schema(reflect.TypeOf(value))
+}
+
+func (p endpointParameter) openAPI(in string) openAPIObject {
+	schema := openAPIObject{"type": p.kind}
+	if p.format != "" {
+		schema["format"] = p.format
+	}
+	tmp := openAPIObject{"name": p.name, "in": in, "required": p.required, "schema": schema}
+	if p.description != "" {
+		tmp["description"] = p.description
+	}
+	return tmp
+}
+
+var asyncParameter = endpointParameter{"async", "string", "", false, "Any value commits the change in memory and queues the database write. The reply carries the request id to look up its state with"}
+
+//Roles that hold at least one of the permissions
+func rolesWith(permissions []permission) []string {
+	var roles []string
+	for role := range rolePermissions {
+		for _, v := range permissions {
+			if hasPermission(role, v) {
+				roles = append(roles, role)
+				break
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+//Summary, description, security and parameters shared by legacy and /api/v1 operations
+func (b *schemaBuilder) operation(e endpoint, parameters []openAPIObject) openAPIObject {
+	tmp := openAPIObject{"summary": e.summary}
+	if len(e.permissions) > 0 {
+		tmp["description"] = "Roles: " + strings.Join(rolesWith(e.permissions), ", ")
+		var names []string
+		for _, v := range e.permissions {
+			names = append(names, string(v))
+		}
+		tmp["x-permissions"] = names
+	}
+	if len(e.permissions) > 0 || e.session {
+		tmp["security"] = []openAPIObject{{"bearer": []string{}}}
+	}
+
+	for _, v := range e.parameters {
+		parameters = append(parameters, v.openAPI("query"))
+	}
+	if e.async {
+		parameters = append(parameters, asyncParameter.openAPI("query"))
+	}
+	if len(parameters) > 0 {
+		tmp["parameters"] = parameters
+	}
+
+	if e.messages != nil {
+		tmp["x-message-schema"] = b.schemaOf(e.messages)
+	}
+	return tmp
+}
+
+//Successful reply of an endpoint
+func (b *schemaBuilder) reply(e endpoint, schema openAPIObject) openAPIObject {
+	status := e.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	tmp := openAPIObject{"description": http.StatusText(status)}
+	if status == http.StatusFound || status == http.StatusSwitchingProtocols {
+		return tmp
+	}
+
+	contentType := e.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	if contentType != "application/json" {
+		schema = openAPIObject{"type": "string"}
+	}
+	tmp["content"] = openAPIObject{contentType: openAPIObject{"schema": schema}}
+	return tmp
+}
+
+//Legacy route, documented as GET and POST since it accepts both with the same parameters
+func (b *schemaBuilder) legacyPath(e endpoint) openAPIObject {
+	statusSchema := b.schemaOf(statusResponse{})
+
+	schema := statusSchema
+	if e.reply != nil {
+		schema = openAPIObject{"anyOf": []openAPIObject{b.schemaOf(e.reply), statusSchema}}
+	}
+
+	operationId := strings.Trim(e.pattern, "/")
+	if operationId == "" {
+		operationId = "about"
+	}
+	operationId = strings.Replace(operationId, ".", "", -1)
+
+	pathItem := openAPIObject{}
+	for _, method := range []string{"get", "post"} {
+		tmp := b.operation(e, nil)
+		tmp["operationId"] = method + strings.ToUpper(operationId[:1]) + operationId[1:]
+		tmp["tags"] = []string{"legacy"}
+		tmp["responses"] = openAPIObject{statusKey(e.status): b.reply(e, schema)}
+		pathItem[method] = tmp
+	}
+	return pathItem
+}
+
+//Operation of an /api/v1 endpoint
+func (b *schemaBuilder) apiOperation(e endpoint) openAPIObject {
+	var parameters []openAPIObject
+	for _, v := range strings.Split(e.pattern, "/") {
+		if strings.HasPrefix(v, "{") && strings.HasSuffix(v, "}") {
+			name := v[1 : len(v)-1]
+			parameters = append(parameters, openAPIObject{"name": name, "in": "path", "required": true, "schema": pathParameterSchemas[name]})
+		}
+	}
+
+	tmp := b.operation(e, parameters)
+	operationId := strings.ToLower(e.method)
+	for _, v := range strings.Split(strings.TrimPrefix(e.pattern, "/api/v1/"), "/") {
+		v = strings.Trim(v, "{}")
+		operationId += strings.ToUpper(v[:1]) + v[1:]
+	}
+	tmp["operationId"] = operationId
+	tmp["tags"] = []string{"v1"}
+
+	if e.body != nil {
+		tmp["requestBody"] = openAPIObject{"required": true, "content": openAPIObject{"application/json": openAPIObject{"schema": b.schemaOf(e.body)}}}
+	}
+
+	responses := openAPIObject{
+		statusKey(e.status): b.reply(e, b.schemaOf(e.reply)),
+		"default": openAPIObject{"description": "Error", "content": openAPIObject{"application/json": openAPIObject{"schema": b.schemaOf(apiErrorEnvelope{})}}},
+	}
+	if e.async {
+		responses[statusKey(http.StatusAccepted)] = openAPIObject{"description": "Change committed in memory, database write queued", "content": openAPIObject{"application/json": openAPIObject{"schema": b.schemaOf(apiAsyncReply{})}}}
+	}
+	tmp["responses"] = responses
+	return tmp
+}
+
+//Status of a successful reply as an OpenAPI response key
+func statusKey(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return fmt.Sprint(status)
+}
+
+//OpenAPI 3 document of every route, generated from the endpoint definitions the routes are registered from
+func (s *Server) openAPIDocument() openAPIObject {
+	b := &schemaBuilder{components: openAPIObject{}}
+
+	paths := openAPIObject{}
+	for _, v := range s.endpoints() {
+		paths[v.pattern] = b.legacyPath(v)
+	}
+	for _, v := range s.apiEndpoints() {
+		pathItem, _ := paths[v.pattern].(openAPIObject)
+		if pathItem == nil {
+			pathItem = openAPIObject{}
+			paths[v.pattern] = pathItem
+		}
+		pathItem[strings.ToLower(v.method)] = b.apiOperation(v)
+	}
+
+	return openAPIObject{
+		"openapi": "3.0.3",
+		"info": openAPIObject{
+			"title":       "Shipmate",
+			"version":     "1",
+			"description": "Legacy routes reply with HTTP status 200 and a canned status of \"0\" for success, \"-1\" for failure or \"-2\" when the caller should sign in again. Routes under /api/v1 reply with HTTP statuses and an error envelope.",
+		},
+		"paths": paths,
+		"components": openAPIObject{
+			"schemas": b.components,
+			"securitySchemes": openAPIObject{
+				"bearer": openAPIObject{"type": "http", "scheme": "bearer", "description": "accessToken from /registerRider, /driverLogin or /refreshSession"},
+			},
+		},
+	}
+}
+
+func (s *Server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	output, err := json.Marshal(s.openAPIDocument())
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, failResponse)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+}
@@ -0,0 +1,608 @@
+package shipmate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Routes the conformance test does not call, and why
+var unexercisedRoutes = map[string]string{
+	"/pickupBoard": "needs a WebSocket connection, covered by the board tests",
+	"/asyncTest":   "sleeps for 5 seconds",
+}
+
+//Decode the document served at /openapi.json
+func servedOpenAPIDocument(t *testing.T, ts *testServer) map[string]interface{} {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	ts.server.ServeHTTP(w, r)
+
+	var document map[string]interface{}
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("/openapi.json replied %v with %v", w.Code, w.Header().Get("Content-Type"))
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatalf("/openapi.json is not JSON: %v", err)
+	}
+	return document
+}
+
+//Checks JSON values against the schemas of an OpenAPI document. Only the parts of JSON Schema the generator emits are supported.
+type schemaChecker struct {
+	schemas map[string]interface{}
+}
+
+func newSchemaChecker(document map[string]interface{}) schemaChecker {
+	components, _ := document["components"].(map[string]interface{})
+	schemas, _ := components["schemas"].(map[string]interface{})
+	return schemaChecker{schemas}
+}
+
+//Schema a $ref points to, nil if it does not resolve
+func (c schemaChecker) resolve(ref string) map[string]interface{} {
+	schema, _ := c.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+	if !strings.HasPrefix(ref, "#/components/schemas/") {
+		return nil
+	}
+	return schema
+}
+
+//Every way value does not match schema, empty if it matches
+func (c schemaChecker) check(schema map[string]interface{}, value interface{}, at string) []string {
+	if ref, exist := schema["$ref"].(string); exist {
+		resolved := c.resolve(ref)
+		if resolved == nil {
+			return []string{fmt.Sprintf("%v: %v does not resolve", at, ref)}
+		}
+		return c.check(resolved, value, at)
+	}
+
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		if _, typed := schema["type"]; typed {
+			return []string{fmt.Sprintf("%v: null where the schema has no nullable", at)}
+		}
+	}
+
+	var problems []string
+	if all, exist := schema["allOf"].([]interface{}); exist {
+		for _, v := range all {
+			problems = append(problems, c.check(v.(map[string]interface{}), value, at)...)
+		}
+	}
+	if any, exist := schema["anyOf"].([]interface{}); exist {
+		var matched bool
+		var all []string
+		for _, v := range any {
+			tmp := c.check(v.(map[string]interface{}), value, at)
+			matched = matched || len(tmp) == 0
+			all = append(all, tmp...)
+		}
+		if !matched {
+			problems = append(problems, fmt.Sprintf("%v: matches none of anyOf (%v)", at, strings.Join(all, "; ")))
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%v: got %v, want an object", at, value))
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, v := range required {
+			if _, exist := object[v.(string)]; !exist {
+				problems = append(problems, fmt.Sprintf("%v: required property %v is missing", at, v))
+			}
+		}
+		for k, v := range object {
+			if property, exist := properties[k].(map[string]interface{}); exist {
+				problems = append(problems, c.check(property, v, at+"."+k)...)
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				problems = append(problems, c.check(additional, v, at+"."+k)...)
+			} else if schema["additionalProperties"] == false {
+				problems = append(problems, fmt.Sprintf("%v: property %v is not in the schema", at, k))
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%v: got %v, want an array", at, value))
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, v := range array {
+			problems = append(problems, c.check(items, v, fmt.Sprintf("%v[%v]", at, i))...)
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return append(problems, fmt.Sprintf("%v: got %v, want a string", at, value))
+		}
+		if pattern, exist := schema["pattern"].(string); exist && !regexp.MustCompile(pattern).MatchString(text) {
+			problems = append(problems, fmt.Sprintf("%v: %q does not match %v", at, text, pattern))
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, text); err != nil {
+				problems = append(problems, fmt.Sprintf("%v: %q is not a date-time", at, text))
+			}
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok || (schema["type"] == "integer" && number != float64(int64(number))) {
+			return append(problems, fmt.Sprintf("%v: got %v, want %v", at, value, schema["type"]))
+		}
+		if minimum, exist := schema["minimum"].(float64); exist && number < minimum {
+			problems = append(problems, fmt.Sprintf("%v: %v is below %v", at, number, minimum))
+		}
+		if maximum, exist := schema["maximum"].(float64); exist && number > maximum {
+			problems = append(problems, fmt.Sprintf("%v: %v is above %v", at, number, maximum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return append(problems, fmt.Sprintf("%v: got %v, want a boolean", at, value))
+		}
+	}
+
+	if enum, exist := schema["enum"].([]interface{}); exist {
+		var found bool
+		for _, v := range enum {
+			found = found || v == value
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%v: %v is not one of %v", at, value, enum))
+		}
+	}
+	return problems
+}
+
+//Check a JSON body against a schema, reporting every mismatch
+func (c schemaChecker) checkBody(t *testing.T, schema map[string]interface{}, body string, what string) {
+	t.Helper()
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(body), &value); err != nil {
+		t.Errorf("%v is not JSON: %v", what, body)
+		return
+	}
+	for _, v := range c.check(schema, value, what) {
+		t.Error(v)
+	}
+}
+
+//Pattern of a documented /api/v1 path matching a request path
+func matchDocumentedPath(paths map[string]interface{}, path string) (string, map[string]string) {
+	for pattern := range paths {
+		if values, ok := matchAPIPath(pattern, path); ok {
+			return pattern, values
+		}
+	}
+	return "", nil
+}
+
+//Operation documented for a method and pattern
+func documentedOperation(paths map[string]interface{}, pattern string, method string) map[string]interface{} {
+	pathItem, _ := paths[pattern].(map[string]interface{})
+	operation, _ := pathItem[strings.ToLower(method)].(map[string]interface{})
+	return operation
+}
+
+//Parameters of an operation by name and location
+func documentedParameters(operation map[string]interface{}) map[string]map[string]interface{} {
+	tmp := make(map[string]map[string]interface{})
+	parameters, _ := operation["parameters"].([]interface{})
+	for _, v := range parameters {
+		parameter := v.(map[string]interface{})
+		tmp[parameter["in"].(string)+":"+parameter["name"].(string)] = parameter
+	}
+	return tmp
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	ts := newTestServer(t)
+	document := servedOpenAPIDocument(t, ts)
+	checker := newSchemaChecker(document)
+	paths := document["paths"].(map[string]interface{})
+
+	for _, v := range []string{"Pickup", "Location", "PickupInfo", "ErrorEnvelope"} {
+		if checker.schemas[v] == nil {
+			t.Errorf("schema %v is missing", v)
+		}
+	}
+
+	//canned legacy replies only have the documented statuses
+	statusSchema := map[string]interface{}{"$ref": "#/components/schemas/StatusResponse"}
+	for _, v := range []string{successResponse, failResponse, wrongPasswordResponse, invalidParameterResponse("phoneNumber", "is required")} {
+		checker.checkBody(t, statusSchema, v, "canned reply")
+	}
+	var value interface{}
+	json.Unmarshal([]byte(`{"status":"-3"}`), &value)
+	if problems := checker.check(statusSchema, value, "unknown status"); len(problems) == 0 {
+		t.Error("status -3 matches the StatusResponse schema")
+	}
+
+	//every $ref in the document resolves
+	var walk func(value interface{}, at string)
+	walk = func(value interface{}, at string) {
+		switch tmp := value.(type) {
+		case map[string]interface{}:
+			if ref, exist := tmp["$ref"].(string); exist && checker.resolve(ref) == nil {
+				t.Errorf("%v: %v does not resolve", at, ref)
+			}
+			for k, v := range tmp {
+				walk(v, at+"/"+k)
+			}
+		case []interface{}:
+			for i, v := range tmp {
+				walk(v, fmt.Sprintf("%v/%v", at, i))
+			}
+		}
+	}
+	walk(document, "#")
+
+	//every registered route is documented, and the server routes every documented path to it
+	for _, v := range ts.server.endpoints() {
+		for _, method := range []string{"get", "post"} {
+			if documentedOperation(paths, v.pattern, method) == nil {
+				t.Errorf("%v %v is not documented", method, v.pattern)
+			}
+		}
+		if _, pattern := ts.server.mux.Handler(httptest.NewRequest("GET", v.pattern, nil)); pattern != v.pattern {
+			t.Errorf("%v is routed to %q", v.pattern, pattern)
+		}
+	}
+	for _, v := range ts.server.apiEndpoints() {
+		operation := documentedOperation(paths, v.pattern, v.method)
+		if operation == nil {
+			t.Errorf("%v %v is not documented", v.method, v.pattern)
+			continue
+		}
+
+		//path templates declare each of their segments
+		parameters := documentedParameters(operation)
+		for _, segment := range strings.Split(v.pattern, "/") {
+			if strings.HasPrefix(segment, "{") && parameters["path:"+strings.Trim(segment, "{}")] == nil {
+				t.Errorf("%v %v does not declare %v", v.method, v.pattern, segment)
+			}
+		}
+	}
+
+	//operation ids are unique
+	operationIds := make(map[string]string)
+	for pattern, pathItem := range paths {
+		for method, v := range pathItem.(map[string]interface{}) {
+			id := v.(map[string]interface{})["operationId"].(string)
+			if other, exist := operationIds[id]; exist {
+				t.Errorf("%v %v and %v share operationId %v", method, pattern, other, id)
+			}
+			operationIds[id] = method + " " + pattern
+		}
+	}
+}
+
+//A request the conformance test sends, checking the reply against what the document says about the route
+type conformanceScenario struct {
+	name       string
+	setup      func(ts *testServer) string //returns the token to request with
+	method     string                      //empty for legacy routes, which are sent as GET
+	path       string
+	parameters url.Values
+	body       interface{}
+	succeeds   bool //legacy routes reply with something other than a canned failure, /api/v1 routes with a 2xx status
+}
+
+//Send a scenario's request to a fresh server. Streams are read until timeout.
+func (tt conformanceScenario) send(t *testing.T, parameters url.Values) *httptest.ResponseRecorder {
+	ts := newTestServer(t)
+	token := tt.setup(ts)
+
+	method := tt.method
+	if method == "" {
+		method = "GET"
+	}
+	target := tt.path
+	if len(parameters) > 0 {
+		target += "?" + parameters.Encode()
+	}
+
+	var body []byte
+	if tt.body != nil {
+		var err error
+		if body, err = json.Marshal(tt.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest(method, target, bytes.NewReader(body)).WithContext(ctx)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if tt.body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	ts.server.ServeHTTP(w, r)
+	return w
+}
+
+//Check a reply against the responses documented for an operation
+func checkReply(t *testing.T, checker schemaChecker, operation map[string]interface{}, w *httptest.ResponseRecorder) {
+	t.Helper()
+
+	responses := operation["responses"].(map[string]interface{})
+	response, documented := responses[strconv.Itoa(w.Code)].(map[string]interface{})
+	if !documented && w.Code >= http.StatusBadRequest {
+		response, documented = responses["default"].(map[string]interface{})
+	}
+	if !documented {
+		t.Fatalf("status %v is not documented, reply %v", w.Code, w.Body.String())
+	}
+
+	content, _ := response["content"].(map[string]interface{})
+	if len(content) == 0 {
+		if w.Body.Len() > 0 && w.Code != http.StatusFound {
+			t.Errorf("reply %v where none is documented", w.Body.String())
+		}
+		return
+	}
+
+	contentType := strings.Split(w.Header().Get("Content-Type"), ";")[0]
+	if contentType == "" {
+		contentType = "application/json" //legacy routes do not set it
+	}
+	media, exist := content[contentType].(map[string]interface{})
+	if !exist && contentType == "text/plain" {
+		//legacy routes do not set a Content-Type, so net/http sniffs their JSON as text
+		media, exist = content["application/json"].(map[string]interface{})
+		contentType = "application/json"
+	}
+	if !exist {
+		t.Fatalf("content type %v is not documented", contentType)
+	}
+	schema := media["schema"].(map[string]interface{})
+
+	switch contentType {
+	case "application/json":
+		checker.checkBody(t, schema, w.Body.String(), "reply")
+	case "text/event-stream":
+		messageSchema := operation["x-message-schema"].(map[string]interface{})
+		var messages int
+		event := ""
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: ") && event == "pickup":
+				checker.checkBody(t, messageSchema, strings.TrimPrefix(line, "data: "), "message")
+				messages++
+			}
+		}
+		if messages == 0 {
+			t.Errorf("stream sent no messages: %v", w.Body.String())
+		}
+	}
+}
+
+func TestOpenAPIConformance(t *testing.T) {
+	rider := func(ts *testServer) string {
+		return ts.riderToken(testRider, testDevice)
+	}
+	riderWithPickup := func(ts *testServer) string {
+		ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+		return ts.riderToken(testRider, testDevice)
+	}
+	driver := func(ts *testServer) string {
+		return ts.driverToken(3, 2)
+	}
+	driverWithPickup := func(ts *testServer) string {
+		ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+		ts.reportVan(2, "38.97", "-76.47")
+		return ts.driverToken(3, 2)
+	}
+	driverWithConfirmedPickup := func(ts *testServer) string {
+		ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+		token := ts.driverToken(3, 2)
+		ts.request(token, "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+		return token
+	}
+	dispatcherWithPickup := func(ts *testServer) string {
+		ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+		return ts.staffToken(dispatcherRole, 7)
+	}
+	admin := func(ts *testServer) string {
+		return ts.staffToken(adminRole, 1)
+	}
+	nobody := func(ts *testServer) string {
+		return ""
+	}
+	phoneNumber := url.Values{"phoneNumber": {testRider}}
+	location := map[string]float64{"latitude": 38.98, "longitude": -76.48}
+
+	tests := []conformanceScenario{
+		//legacy routes
+		{"about", nobody, "", "/", nil, nil, true},
+		{"uptime", nobody, "", "/uptime", nil, nil, true},
+		{"openapi", nobody, "", "/openapi.json", nil, nil, true},
+		{"verification code without Postgres", nobody, "", "/requestVerificationCode", url.Values{"phoneNumber": {testRider}, "deviceId": {"9B2E5C1A-4F0D-4E8B-9C3A-7D6E5F4A3B2C"}}, nil, false},
+		{"register with a wrong code", nobody, "", "/registerRider", url.Values{"phoneNumber": {testRider}, "deviceId": {"9B2E5C1A-4F0D-4E8B-9C3A-7D6E5F4A3B2C"}, "code": {"123456"}}, nil, false},
+		{"login without Postgres", nobody, "", "/driverLogin", url.Values{"username": {"driver"}, "password": {"secret"}}, nil, false},
+		{"refresh with a bad token", nobody, "", "/refreshSession", url.Values{"refreshToken": {"nonsense"}}, nil, false},
+		{"logout without Postgres", driver, "", "/logout", nil, nil, false},
+		{"new pickup", rider, "", "/newPickup", url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}}, nil, true},
+		{"new async pickup", rider, "", "/newPickup", url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}, "async": {"true"}}, nil, true},
+		{"rider pickup info", riderWithPickup, "", "/getPickupInfo", url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}}, nil, true},
+		{"staff pickup info", driverWithPickup, "", "/getPickupInfo", phoneNumber, nil, true},
+		{"pickup location", riderWithPickup, "", "/updatePickupLocation", url.Values{"latitude": {"38.99"}, "longitude": {"-76.49"}}, nil, true},
+		{"pickup stream", riderWithPickup, "", "/streamPickupInfo", nil, nil, true},
+		{"pickup events", riderWithPickup, "", "/getPickupEvents", nil, nil, true},
+		{"unknown request status", driver, "", "/getRequestStatus", url.Values{"requestId": {"0123456789abcdef0123456789abcdef"}}, nil, false},
+		{"van locations", func(ts *testServer) string {
+			ts.reportVan(2, "38.97", "-76.47")
+			return ""
+		}, "", "/getVanLocations", nil, nil, true},
+		{"rider cancels", riderWithPickup, "", "/cancelPickup", nil, nil, true},
+		{"pickup list", driverWithPickup, "", "/getPickupList", nil, nil, true},
+		{"confirm", driverWithPickup, "", "/confirmPickup", phoneNumber, nil, true},
+		{"complete", driverWithConfirmedPickup, "", "/completePickup", phoneNumber, nil, true},
+		{"progress", driverWithConfirmedPickup, "", "/updatePickupStatus", url.Values{"phoneNumber": {testRider}, "status": {"enRoute"}}, nil, true},
+		{"claim", driverWithPickup, "", "/claimPickup", phoneNumber, nil, true},
+		{"unassign", driverWithConfirmedPickup, "", "/unassignPickup", phoneNumber, nil, true},
+		{"reassign", dispatcherWithPickup, "", "/reassignPickup", url.Values{"phoneNumber": {testRider}, "vanId": {"4"}, "driverId": {"8"}}, nil, true},
+		{"van route", driverWithConfirmedPickup, "", "/getVanRoute", nil, nil, true},
+		{"dispatcher van route", dispatcherWithPickup, "", "/getVanRoute", url.Values{"vanId": {"3"}}, nil, true},
+		{"van location", driver, "", "/updateVanLocation", url.Values{"vanNumber": {"2"}, "latitude": {"38.97"}, "longitude": {"-76.47"}, "heading": {"90"}}, nil, true},
+		{"accounts without Postgres", admin, "", "/listAccounts", nil, nil, true},
+		{"create account without Postgres", admin, "", "/createAccount", url.Values{"username": {"driver"}, "password": {"secret"}, "role": {driverRole}, "vanId": {"2"}, "enabled": {"true"}}, nil, false},
+		{"update account without Postgres", admin, "", "/updateAccount", url.Values{"username": {"driver"}, "password": {"secret"}, "role": {driverRole}, "vanId": {"2"}, "enabled": {"true"}}, nil, false},
+		{"config", admin, "", "/getConfig", nil, nil, true},
+		{"set config without Postgres", admin, "", "/setConfig", url.Values{"key": {"inactivePickupMinutes"}, "value": {"30"}}, nil, false},
+		{"vans", admin, "", "/listVans", nil, nil, true},
+		{"create van", admin, "", "/createVan", url.Values{"vanId": {"7"}, "callsign": {"Navy 7"}, "capacity": {"8"}, "active": {"true"}, "plate": {"MD 1234"}, "accessibility": {"wheelchair,ramp"}}, nil, true},
+		{"update van", admin, "", "/updateVan", url.Values{"vanId": {"3"}, "callsign": {"Navy 3"}, "capacity": {"8"}, "active": {"false"}, "plate": {"MD 1234"}, "accessibility": {"none"}}, nil, true},
+		{"rider without permission", driver, "", "/newPickup", url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}}, nil, false},
+
+		//api routes
+		{"create pickup", rider, "POST", "/api/v1/pickups", nil, location, true},
+		{"create async pickup", rider, "POST", "/api/v1/pickups", url.Values{"async": {"true"}}, location, true},
+		{"create pickup without longitude", rider, "POST", "/api/v1/pickups", nil, map[string]float64{"latitude": 38.98}, false},
+		{"list pickups", driverWithPickup, "GET", "/api/v1/pickups", nil, nil, true},
+		{"get pickup", driverWithPickup, "GET", "/api/v1/pickups/" + testRider, nil, nil, true},
+		{"get missing pickup", driver, "GET", "/api/v1/pickups/" + testRider, nil, nil, false},
+		{"confirm pickup", driverWithPickup, "PATCH", "/api/v1/pickups/" + testRider, nil, map[string]string{"status": "confirmed"}, true},
+		{"move pickup", riderWithPickup, "PATCH", "/api/v1/pickups/" + testRider, nil, location, true},
+		{"cancel pickup", riderWithPickup, "DELETE", "/api/v1/pickups/" + testRider, nil, nil, true},
+		{"pickup events", driverWithPickup, "GET", "/api/v1/pickups/" + testRider + "/events", url.Values{"initialTime": {testStartTime.Format(time.RFC3339)}}, nil, true},
+		{"claim pickup", driverWithPickup, "PUT", "/api/v1/pickups/" + testRider + "/van", nil, map[string]int{}, true},
+		{"assign pickup", dispatcherWithPickup, "PUT", "/api/v1/pickups/" + testRider + "/van", nil, map[string]int{"vanId": 4, "driverId": 8}, true},
+		{"unassign pickup", driverWithConfirmedPickup, "DELETE", "/api/v1/pickups/" + testRider + "/van", nil, nil, true},
+		{"list vans", func(ts *testServer) string {
+			ts.reportVan(2, "38.97", "-76.47")
+			return ""
+		}, "GET", "/api/v1/vans", nil, nil, true},
+		{"van location", driver, "PUT", "/api/v1/vans/2/location", nil, map[string]float64{"latitude": 38.97, "longitude": -76.47, "heading": 90}, true},
+		{"van route", driverWithConfirmedPickup, "GET", "/api/v1/vans/2/route", nil, nil, true},
+		{"unknown request", driver, "GET", "/api/v1/requests/0123456789abcdef0123456789abcdef", nil, nil, false},
+		{"no token", nobody, "GET", "/api/v1/pickups", nil, nil, false},
+	}
+
+	exercised := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.name, func(t *testing.T) {
+			document := servedOpenAPIDocument(t, newTestServer(t))
+			checker := newSchemaChecker(document)
+			paths := document["paths"].(map[string]interface{})
+
+			pattern, pathValues := tt.path, map[string]string(nil)
+			if tt.method != "" {
+				pattern, pathValues = matchDocumentedPath(paths, tt.path)
+			}
+			operation := documentedOperation(paths, pattern, tt.method)
+			if tt.method == "" {
+				operation = documentedOperation(paths, pattern, "get")
+			}
+			if operation == nil {
+				t.Fatalf("%v %v is not documented", tt.method, tt.path)
+			}
+			exercised[tt.method+" "+pattern] = true
+
+			//everything sent is documented
+			parameters := documentedParameters(operation)
+			for k, v := range pathValues {
+				parameter := parameters["path:"+k]
+				if parameter == nil {
+					t.Fatalf("path parameter %v is not documented", k)
+				}
+				schema := parameter["schema"].(map[string]interface{})
+				var value interface{} = v
+				if schema["type"] == "integer" {
+					value, _ = strconv.ParseFloat(v, 64)
+				}
+				for _, problem := range checker.check(schema, value, k) {
+					t.Error(problem)
+				}
+			}
+			for k := range tt.parameters {
+				if parameters["query:"+k] == nil {
+					t.Errorf("parameter %v is not documented", k)
+				}
+			}
+			if tt.body != nil {
+				requestBody, _ := operation["requestBody"].(map[string]interface{})
+				if requestBody == nil {
+					t.Fatal("request body is not documented")
+				}
+				output, _ := json.Marshal(tt.body)
+				schema := requestBody["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+				checker.checkBody(t, schema, string(output), "request body")
+			}
+
+			w := tt.send(t, tt.parameters)
+			checkReply(t, checker, operation, w)
+
+			body := w.Body.String()
+			var succeeded bool
+			if tt.method == "" {
+				status := decodeStatus(body).Status
+				succeeded = status != "-1" && status != "-2"
+			} else {
+				succeeded = w.Code < http.StatusMultipleChoices
+			}
+			if succeeded != tt.succeeds {
+				t.Fatalf("got %v %v, want success %v", w.Code, body, tt.succeeds)
+			}
+
+			//a legacy route cannot do without a parameter the document says is required
+			if tt.method != "" || !tt.succeeds {
+				return
+			}
+			for _, v := range parameters {
+				name := v["name"].(string)
+				if v["required"] != true || tt.parameters.Get(name) == "" {
+					continue
+				}
+				without := url.Values{}
+				for k, values := range tt.parameters {
+					if k != name {
+						without[k] = values
+					}
+				}
+				if tmp := tt.send(t, without).Body.String(); tmp == body {
+					t.Errorf("without required parameter %v got the same reply %v", name, tmp)
+				}
+			}
+		})
+	}
+
+	var missing []string
+	for _, v := range newTestServer(t).server.endpoints() {
+		if !exercised[" "+v.pattern] && unexercisedRoutes[v.pattern] == "" {
+			missing = append(missing, v.pattern)
+		}
+	}
+	for _, v := range newTestServer(t).server.apiEndpoints() {
+		if !exercised[v.method+" "+v.pattern] {
+			missing = append(missing, v.method+" "+v.pattern)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("no conformance scenario for %v", missing)
+	}
+}
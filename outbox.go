@@ -0,0 +1,336 @@
+package shipmate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"math"
+	"net/http"
+	"time"
+)
+
+//Queued write states
+const outboxQueued string = "queued"
+const outboxCommitted string = "committed"
+const outboxRolledBack string = "rolledBack" //the pickup changed in the database first, memory was reloaded
+const outboxFailed string = "failed"         //the database kept failing, memory was reloaded
+
+const outboxMaxAttempts = 8
+const outboxMaxRetryDelay = time.Duration(5) * time.Minute
+const outboxRetention = time.Duration(24) * time.Hour //finished writes are kept this long so clients can look up their outcome
+const outboxTakeoverDelay = time.Duration(2) * time.Minute //writes overdue this long are applied by any instance, the instance that queued them has stopped
+
+//Pickup as stored in a queued write. Version, device phrase and retire time are unexported in Pickup, so they are carried next to it.
+type queuedPickup struct {
+	Pickup
+	Version      int       `json:"version"`
+	DevicePhrase string    `json:"devicePhrase"`
+	RetireTime   time.Time `json:"retireTime"`
+}
+
+//A database write accepted by an async request
+type queuedWrite struct {
+	RequestId     string    `json:"requestId"`
+	Operation     string    `json:"operation"`
+	PhoneNumber   string    `json:"phoneNumber"`
+	State         string    `json:"state"`
+	Attempts      int       `json:"attempts"`
+	Error         string    `json:"error"`
+	CreatedTime   time.Time `json:"createdTime"`
+	ProcessedTime time.Time `json:"processedTime"`
+	payload       string
+	actor         string
+	instanceId    string //instance that queued the write
+}
+
+//Reply to an async request with the id to look up its outcome with
+func (s *Server) asyncResponse(requestId string) string {
+	output, err := json.Marshal(map[string]string{"status": "0", "requestId": requestId})
+	if err != nil {
+		s.logger.Println(err)
+	}
+	return string(output)
+}
+
+//INSERT a write of a pickup in pickup_outbox table. targetPickup is the pickup as it should be written, with the version it was read with. Returns the request id, empty if the write could not be queued.
+func (s *postgresStore) databaseEnqueuePickupWrite(operation string, targetPickup Pickup, actor string) string {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return ""
+	}
+
+	payload, err := json.Marshal(queuedPickup{targetPickup, targetPickup.version, targetPickup.devicePhrase, targetPickup.retireTime})
+	if err != nil {
+		s.logger.Println(err)
+		return ""
+	}
+
+	requestId, err := randomHex(16)
+	if err != nil {
+		s.logger.Println(err)
+		return ""
+	}
+	now := time.Now()
+	if _, err := s.db.Exec(`INSERT INTO pickup_outbox (RequestId, Operation, PhoneNumber, Payload, Actor, CreatedTime, NextAttemptTime, InstanceId)
+		VALUES ($1, $2, $3, $4, $5, $6, $6, $7);`, requestId, operation, targetPickup.PhoneNumber, string(payload), actor, now, s.instanceId); err != nil {
+		s.logger.Println(err)
+		return ""
+	}
+
+	s.wakeOutboxWorker()
+	return requestId
+}
+
+//Ask the worker on serialChannel to process queued writes. Never blocks, a wake up already waiting covers this one.
+func (s *postgresStore) wakeOutboxWorker() {
+	select {
+	case s.serialChannel <- s.processOutbox:
+	default:
+	}
+}
+
+//Wait before retrying a write that failed attempts times, doubling from one second
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(math.Pow(2, float64(attempts-1))) * time.Second
+	if delay > outboxMaxRetryDelay {
+		return outboxMaxRetryDelay
+	}
+	return delay
+}
+
+//SELECT the oldest queued write of this instance that is due, or of any instance once it is outboxTakeoverDelay overdue. Writes for a phone number wait for earlier writes for it, so they reach the database in the order they were accepted.
+func (s *postgresStore) selectNextQueuedWrite() (queuedWrite, bool) {
+	var tmp queuedWrite
+	now := time.Now()
+	err := s.db.QueryRow(`SELECT RequestId, Operation, PhoneNumber, Payload, Actor, Attempts, InstanceId
+		FROM pickup_outbox queued
+		WHERE State = $1 AND ((InstanceId = $2 AND NextAttemptTime <= $3) OR NextAttemptTime <= $4) AND NOT EXISTS (
+			SELECT 1 FROM pickup_outbox earlier
+			WHERE earlier.PhoneNumber = queued.PhoneNumber AND earlier.State = $1 AND earlier.Sequence < queued.Sequence)
+		ORDER BY Sequence
+		LIMIT 1;`, outboxQueued, s.instanceId, now, now.Add(-outboxTakeoverDelay)).Scan(&tmp.RequestId, &tmp.Operation, &tmp.PhoneNumber, &tmp.payload, &tmp.actor, &tmp.Attempts, &tmp.instanceId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			s.logger.Println(err)
+		}
+		return tmp, false
+	}
+	return tmp, true
+}
+
+//SELECT a queued write by request id
+func (s *postgresStore) selectQueuedWrite(requestId string) (queuedWrite, bool) {
+	var tmp queuedWrite
+	var processedTime pq.NullTime
+	err := s.db.QueryRow(`SELECT RequestId, Operation, PhoneNumber, State, Attempts, Error, CreatedTime, ProcessedTime
+		FROM pickup_outbox
+		WHERE RequestId = $1;`, requestId).Scan(&tmp.RequestId, &tmp.Operation, &tmp.PhoneNumber, &tmp.State, &tmp.Attempts, &tmp.Error, &tmp.CreatedTime, &processedTime)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			s.logger.Println(err)
+		}
+		return tmp, false
+	}
+	tmp.ProcessedTime = processedTime.Time
+	return tmp, true
+}
+
+//UPDATE a queued write that will not be applied
+func (s *postgresStore) databaseFinishQueuedWrite(requestId string, state string, reason string) bool {
+	if _, err := s.db.Exec(`UPDATE pickup_outbox
+		SET State = $1, Error = $2, ProcessedTime = $3
+		WHERE RequestId = $4 AND State = $5;`, state, reason, time.Now(), requestId, outboxQueued); err != nil {
+		s.logger.Println(err)
+		return false
+	}
+	return true
+}
+
+//UPDATE a queued write that failed to be tried again later, or give up on it after outboxMaxAttempts
+func (s *postgresStore) databaseRetryQueuedWrite(targetWrite queuedWrite, targetPickup Pickup, cause error) bool {
+	attempts := targetWrite.Attempts + 1
+	if attempts >= outboxMaxAttempts {
+		s.logger.Printf("Giving up on queued write %v after %v attempts\n", targetWrite.RequestId, attempts)
+		if !s.databaseFinishQueuedWrite(targetWrite.RequestId, outboxFailed, cause.Error()) {
+			return false
+		}
+		targetWrite.State = outboxFailed
+		s.writeFinished(targetWrite, targetPickup)
+		return true
+	}
+
+	delay := outboxRetryDelay(attempts)
+	if _, err := s.db.Exec(`UPDATE pickup_outbox
+		SET Attempts = $1, Error = $2, NextAttemptTime = $3
+		WHERE RequestId = $4 AND State = $5;`, attempts, cause.Error(), time.Now().Add(delay), targetWrite.RequestId, outboxQueued); err != nil {
+		s.logger.Println(err)
+		return false
+	}
+	time.AfterFunc(delay, s.wakeOutboxWorker)
+	return true
+}
+
+//Apply one queued write and record its outcome in one transaction. Returns false if the outcome could not be recorded, so the worker stops until it is woken again.
+func (s *postgresStore) processQueuedWrite(targetWrite queuedWrite) bool {
+	var tmp queuedPickup
+	if err := json.Unmarshal([]byte(targetWrite.payload), &tmp); err != nil {
+		s.logger.Println(err)
+		return s.databaseFinishQueuedWrite(targetWrite.RequestId, outboxFailed, err.Error())
+	}
+	targetPickup := tmp.Pickup
+	targetPickup.version = tmp.Version
+	targetPickup.devicePhrase = tmp.DevicePhrase
+	targetPickup.retireTime = tmp.RetireTime
+
+	//announce the write as made by the instance that queued it, which already has it in memory. Other instances, including this one when it takes over a stopped instance's write, reload the pickup.
+	tx, err := s.beginWrite(targetWrite.instanceId)
+	if err != nil {
+		s.logger.Println(err)
+		return false
+	}
+
+	//lock the write so instances never apply it twice, another instance may have finished it since it was selected
+	var state string
+	if err := tx.QueryRow(`SELECT State FROM pickup_outbox WHERE RequestId = $1 FOR UPDATE;`, targetWrite.RequestId).Scan(&state); err != nil || state != outboxQueued {
+		tx.Rollback()
+		if err != nil {
+			s.logger.Println(err)
+			return false
+		}
+		return true
+	}
+
+	applied, err := applyPickupWrite(tx, targetWrite.Operation, targetPickup)
+	if err == nil && applied {
+		if _, err = tx.Exec(`UPDATE pickup_outbox SET State = $1, ProcessedTime = $2 WHERE RequestId = $3;`, outboxCommitted, time.Now(), targetWrite.RequestId); err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil || !applied {
+		tx.Rollback()
+	}
+
+	if err != nil {
+		s.logger.Printf("Queued write %v failed: %v\n", targetWrite.RequestId, err)
+		return s.databaseRetryQueuedWrite(targetWrite, targetPickup, err)
+	}
+
+	if !applied {
+		s.logger.Printf("Queued write %v rolled back, pickup %v changed in the database first\n", targetWrite.RequestId, targetWrite.PhoneNumber)
+		if !s.databaseFinishQueuedWrite(targetWrite.RequestId, outboxRolledBack, "stale version") {
+			return false
+		}
+		targetWrite.State = outboxRolledBack
+		s.writeFinished(targetWrite, targetPickup)
+		return true
+	}
+
+	s.logger.Printf("Queued write %v committed\n", targetWrite.RequestId)
+	targetWrite.State = outboxCommitted
+	s.writeFinished(targetWrite, targetPickup)
+	return true
+}
+
+//Pass the outcome of a queued write to the watcher
+func (s *postgresStore) writeFinished(targetWrite queuedWrite, targetPickup Pickup) {
+	s.watchLock.Lock()
+	finished := s.writesFinished
+	s.watchLock.Unlock()
+
+	if finished != nil {
+		finished(targetWrite, targetPickup)
+	}
+}
+
+//Finish what a synchronous request does after its write: record the event and dispatch new pickups
+func (s *Server) queuedWriteCommitted(targetWrite queuedWrite, targetPickup Pickup) {
+	switch targetWrite.Operation {
+	case insertPickupWrite:
+		s.databaseInsertPickupEvent(targetPickup, createdEvent, targetWrite.actor, targetPickup.InitialTime)
+
+		s.pickupsLock.Lock()
+		//a write taken over from a stopped instance is not in memory yet
+		if current, exist := s.pickups[targetPickup.PhoneNumber]; !exist || !current.InitialTime.Equal(targetPickup.InitialTime) {
+			s.loadPickupIntoMemory(targetPickup.PhoneNumber)
+		}
+		if current, exist := s.pickups[targetPickup.PhoneNumber]; exist && current.InitialTime.Equal(targetPickup.InitialTime) {
+			s.dispatchPickup(current, nil)
+		}
+		s.pickupsLock.Unlock()
+	case statusPickupWrite, cancelPickupWrite:
+		s.databaseInsertPickupEvent(targetPickup, statusEvent, targetPickup.StatusActor, targetPickup.StatusTime)
+	}
+}
+
+//Follow up on a queued write once its outcome is known. Memory is reloaded from the store if the write was dropped.
+func (s *Server) queuedWriteFinished(targetWrite queuedWrite, targetPickup Pickup) {
+	if targetWrite.State == outboxCommitted {
+		s.queuedWriteCommitted(targetWrite, targetPickup)
+	} else {
+		s.reloadPickup(targetWrite.PhoneNumber)
+	}
+}
+
+//Apply queued writes until none are due. Runs on serialChannel so one worker per instance processes writes in order.
+func (s *postgresStore) processOutbox() {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return
+	}
+
+	for {
+		targetWrite, exist := s.selectNextQueuedWrite()
+		if !exist || !s.processQueuedWrite(targetWrite) {
+			return
+		}
+	}
+}
+
+//Reply with the outcome of an async request: queued, committed, rolledBack or failed. Riders may only look up requests for their own phone number.
+func (s *Server) getRequestStatus(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("getRequestStatus()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	var requestId string
+	if value, exist := v.required(r.Form, "requestId"); exist {
+		requestId = v.requestId("requestId", value)
+	}
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	tmp, err := s.requestStatusFor(session, requestId)
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	if output, err := json.Marshal(tmp); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
+
+//Outcome of an async request. Riders may only look up requests for their own phone number.
+func (s *Server) requestStatusFor(session Session, requestId string) (queuedWrite, error) {
+	tmp, exist, err := s.pickupStore.GetQueuedWrite(requestId)
+	if err != nil {
+		return queuedWrite{}, storeError(err)
+	} else if !exist {
+		return queuedWrite{}, apiErrorf(http.StatusNotFound, notFoundCode, "no queued write %v", requestId)
+	}
+
+	if !hasPermission(session.Role, viewAnyPickupPermission) && tmp.PhoneNumber != session.PhoneNumber {
+		return queuedWrite{}, apiErrorf(http.StatusForbidden, forbiddenCode, "riders may only look up their own requests")
+	}
+	return tmp, nil
+}
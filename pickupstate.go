@@ -0,0 +1,125 @@
+package shipmate
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//Pickup status. Values are stored in the Status column and sent to the apps, so existing numbers must not change.
+type PickupStatus int
+
+const (
+	inactive  PickupStatus = 0 //no pickup for the phone number
+	pending   PickupStatus = 1
+	confirmed PickupStatus = 2
+	completed PickupStatus = 3
+	canceled  PickupStatus = 5
+	enRoute   PickupStatus = 6
+	arrived   PickupStatus = 7
+	noShow    PickupStatus = 8
+	expired   PickupStatus = 9
+)
+
+var pickupStatusNames = map[PickupStatus]string{
+	inactive:  "inactive",
+	pending:   "pending",
+	confirmed: "confirmed",
+	completed: "completed",
+	canceled:  "canceled",
+	enRoute:   "enRoute",
+	arrived:   "arrived",
+	noShow:    "noShow",
+	expired:   "expired",
+}
+
+//Allowed status changes. A new pickup starts from inactive, drivers may skip en route and arrived since the original driver app only confirms and completes. Unassigning a van returns the pickup to pending.
+var pickupTransitions = map[PickupStatus][]PickupStatus{
+	inactive:  {pending},
+	pending:   {confirmed, canceled, expired},
+	confirmed: {pending, enRoute, arrived, completed, canceled, noShow, expired},
+	enRoute:   {pending, arrived, completed, canceled, noShow},
+	arrived:   {completed, canceled, noShow},
+	completed: {},
+	canceled:  {},
+	noShow:    {},
+	expired:   {},
+}
+
+func (s PickupStatus) String() string {
+	if name, exist := pickupStatusNames[s]; exist {
+		return name
+	}
+	return "status(" + strconv.Itoa(int(s)) + ")"
+}
+
+//Terminal statuses end the pickup, after which the phone number may request a new one
+func (s PickupStatus) isTerminal() bool {
+	next, exist := pickupTransitions[s]
+	return exist && len(next) == 0
+}
+
+//Pickups that are waiting for or being served by a van
+func (s PickupStatus) isActive() bool {
+	return s != inactive && !s.isTerminal()
+}
+
+func parsePickupStatus(name string) (PickupStatus, bool) {
+	for k, v := range pickupStatusNames {
+		if v == name {
+			return k, true
+		}
+	}
+	return inactive, false
+}
+
+func canTransitionPickup(from PickupStatus, to PickupStatus) bool {
+	for _, v := range pickupTransitions[from] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
+
+//Returned when a handler asks for a status change the state machine does not allow
+type pickupTransitionError struct {
+	PhoneNumber string
+	From        PickupStatus
+	To          PickupStatus
+}
+
+func (e pickupTransitionError) Error() string {
+	return fmt.Sprintf("pickup %v cannot change from %v to %v", e.PhoneNumber, e.From, e.To)
+}
+
+//Who caused a status change, e.g. "driver:3", "rider:4105551234" or "system"
+func sessionActor(session Session) string {
+	if session.Role == riderRole {
+		return riderRole + ":" + session.PhoneNumber
+	}
+	return session.Role + ":" + strconv.Itoa(session.DriverId)
+}
+
+const systemActor string = "system"
+
+//Move a pickup to a new status and record who did it and when. This is the only place Pickup.Status is changed by request handlers.
+func (s *Server) transitionPickup(targetPickup *Pickup, to PickupStatus, actor string, now time.Time) error {
+	if !canTransitionPickup(targetPickup.Status, to) {
+		return pickupTransitionError{targetPickup.PhoneNumber, targetPickup.Status, to}
+	}
+
+	s.logger.Printf("Pickup %v %v -> %v by %v\n", targetPickup.PhoneNumber, targetPickup.Status, to, actor)
+
+	targetPickup.Status = to
+	targetPickup.StatusActor = actor
+	targetPickup.StatusTime = now
+
+	switch to {
+	case confirmed:
+		targetPickup.ConfirmTime = now
+	case completed, canceled, noShow, expired:
+		targetPickup.CompleteTime = now
+	}
+	return nil
+}
@@ -0,0 +1,601 @@
+package shipmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Pickups, vans and van locations in the inprogress, pastpickups, vans and vanlocations tables. Changes made by any instance are announced through LISTEN/NOTIFY.
+type postgresStore struct {
+	db          *sql.DB
+	databaseURL string
+	logger      *log.Logger
+
+	watchLock      sync.Mutex
+	listener       *pq.Listener
+	instanceId     string //sent with notifications of this instance's writes, to tell them apart from other instances' writes
+	pickupsChanged func(phoneNumber string, local bool)
+	vansChanged    func(vanId int, local bool)
+	writesFinished func(targetWrite queuedWrite, targetPickup Pickup)
+
+	//Runs the outbox worker, buffered so a queued write can wake the worker while it is busy
+	serialChannel chan func()
+
+	//Closed by Close to stop the outbox worker and its ticker
+	closed    chan bool
+	closeOnce sync.Once
+}
+
+func newPostgresStore(targetHandle *sql.DB, databaseURL string, logger *log.Logger) *postgresStore {
+	instanceId, err := randomHex(16)
+	if err != nil {
+		logger.Println(err)
+	}
+	return &postgresStore{db: targetHandle, databaseURL: databaseURL, logger: logger, instanceId: instanceId, serialChannel: make(chan func(), 1), closed: make(chan bool)}
+}
+
+//Begin a transaction whose changes to inprogress and vanlocations are announced as made by instanceId, usually this instance's. Notifications are sent from whichever pooled connection wrote, so the instance id travels in the payload rather than being told apart by backend PID.
+func (s *postgresStore) beginWrite(instanceId string) (*sql.Tx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`SELECT set_config('shipmate.instance', $1, true);`, instanceId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+//Split a notification payload into the key of the changed row and the id of the instance that changed it, empty for changes made outside an instance
+func parseNotificationPayload(payload string) (string, string) {
+	parts := strings.SplitN(payload, " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+//Statements that run either on the database handle or inside a transaction
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+//Rows of a query or the single row of QueryRow
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//INSERT pickup row into the inprogress or pastpickups table
+func execInsertPickup(executor sqlExecutor, targetTable string, targetPickup Pickup) (sql.Result, error) {
+	//we construct the INSERT query in Go because SQL does not support ordinal marker for table names
+	query := fmt.Sprintf(`INSERT INTO %v (PhoneNumber, DeviceId, InitialLatitude, InitialLongitude, InitialTime, LatestLatitude, LatestLongitude, LatestTime, ConfirmTime, CompleteTime, Status, ConfirmDriverId, CompleteDriverId, StatusActor, StatusTime, VanId, DriverId, SuggestedVanId)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18);`, targetTable)
+	return executor.Exec(query, targetPickup.PhoneNumber, targetPickup.devicePhrase, targetPickup.InitialLocation.Latitude, targetPickup.InitialLocation.Longitude, targetPickup.InitialTime, targetPickup.LatestLocation.Latitude, targetPickup.LatestLocation.Longitude, targetPickup.LatestTime, targetPickup.ConfirmTime, targetPickup.CompleteTime, targetPickup.Status, targetPickup.ConfirmDriverId, targetPickup.CompleteDriverId, targetPickup.StatusActor, targetPickup.StatusTime, targetPickup.VanId, targetPickup.DriverId, targetPickup.SuggestedVanId)
+}
+
+//UPDATE pickup row in inprogress table if it still has the version the pickup was read with. Match initialTime too since a finished pickup waiting to retire and a new pickup for the same phoneNumber can have the same version.
+func execUpdatePickupStatus(executor sqlExecutor, targetPickup Pickup) (sql.Result, error) {
+	return executor.Exec(`UPDATE inprogress
+		SET Status = $1, Version = $4, ConfirmDriverId = $5, CompleteDriverId = $6, ConfirmTime = $7, CompleteTime = $8, StatusActor = $9, StatusTime = $10, VanId = $11, DriverId = $12, SuggestedVanId = $13, RetireTime = $14
+		WHERE PhoneNumber = $2 AND Version = $3 AND InitialTime = $15;`, targetPickup.Status, targetPickup.PhoneNumber, targetPickup.version, targetPickup.version+1, targetPickup.ConfirmDriverId, targetPickup.CompleteDriverId, targetPickup.ConfirmTime, targetPickup.CompleteTime, targetPickup.StatusActor, targetPickup.StatusTime, targetPickup.VanId, targetPickup.DriverId, targetPickup.SuggestedVanId, targetPickup.retireTime, targetPickup.InitialTime)
+}
+
+//DELETE pickup row from inprogress table. Identify pickups by phoneNumber and initialTime instead of version since the phoneNumber might have another entry with new pickup
+func execDeletePickupInCurrentTable(executor sqlExecutor, targetPickup Pickup) (sql.Result, error) {
+	return executor.Exec(`DELETE FROM inprogress
+		WHERE PhoneNumber = $1 AND InitialTime = $2;`, targetPickup.PhoneNumber, targetPickup.InitialTime)
+}
+
+//Apply a pickup write with executor, which should be a transaction for writes that change both tables. Returns false without an error if the pickup changed in the database since it was read.
+func applyPickupWrite(executor sqlExecutor, operation string, targetPickup Pickup) (bool, error) {
+	var result sql.Result
+	var err error
+
+	switch operation {
+	case insertPickupWrite:
+		if result, err = execInsertPickup(executor, "inprogress", targetPickup); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" { //unique_violation
+				return false, nil
+			}
+			return false, err
+		}
+	case statusPickupWrite:
+		if result, err = execUpdatePickupStatus(executor, targetPickup); err != nil {
+			return false, err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 1 && targetPickup.Status.isTerminal() {
+			result, err = execInsertPickup(executor, "pastpickups", targetPickup)
+		}
+	case cancelPickupWrite:
+		if _, err = execInsertPickup(executor, "pastpickups", targetPickup); err != nil {
+			return false, err
+		}
+		result, err = execDeletePickupInCurrentTable(executor, targetPickup)
+	default:
+		return false, fmt.Errorf("unknown pickup write %v", operation)
+	}
+
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+//Apply a pickup write in one transaction, so a crash never leaves a pickup in both tables or in neither
+func (s *postgresStore) runPickupWrite(operation string, targetPickup Pickup) error {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return errors.New("database unavailable")
+	}
+
+	tx, err := s.beginWrite(s.instanceId)
+	if err != nil {
+		return err
+	}
+
+	applied, err := applyPickupWrite(tx, operation, targetPickup)
+	if err == nil && applied {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+
+	if err != nil {
+		return err
+	} else if !applied {
+		s.logger.Printf("%v write of pickup %v affected no rows. Instance had a stale entry.", operation, targetPickup.PhoneNumber)
+		return errStalePickup
+	}
+	s.logger.Printf("%v write of pickup %v committed\n", operation, targetPickup.PhoneNumber)
+	return nil
+}
+
+func (s *postgresStore) CreatePickup(targetPickup Pickup) error {
+	return s.runPickupWrite(insertPickupWrite, targetPickup)
+}
+
+func (s *postgresStore) UpdatePickup(targetPickup Pickup) error {
+	return s.runPickupWrite(statusPickupWrite, targetPickup)
+}
+
+func (s *postgresStore) ArchivePickup(targetPickup Pickup) error {
+	return s.runPickupWrite(cancelPickupWrite, targetPickup)
+}
+
+//UPDATE pickup latestLocation in inprogress table
+func (s *postgresStore) UpdatePickupLocation(targetPickup Pickup) error {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return errors.New("database unavailable")
+	}
+
+	tx, err := s.beginWrite(s.instanceId)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE inprogress
+		SET LatestLatitude = $1, LatestLongitude = $2, LatestTime = $3
+		WHERE PhoneNumber = $4 AND InitialTime = $5;`, targetPickup.LatestLocation.Latitude, targetPickup.LatestLocation.Longitude, targetPickup.LatestTime, targetPickup.PhoneNumber, targetPickup.InitialTime)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return errStalePickup
+	}
+	return tx.Commit()
+}
+
+//DELETE finished pickups from inprogress table once their retire time has passed. Runs on every instance, so it is safe to delete a pickup twice.
+func (s *postgresStore) RetireFinishedPickups(now time.Time) ([]string, error) {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return nil, errors.New("database unavailable")
+	}
+
+	tx, err := s.beginWrite(s.instanceId)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`DELETE FROM inprogress
+		WHERE RetireTime > '0001-01-01' AND RetireTime <= $1
+		RETURNING PhoneNumber;`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	retired := make([]string, 0)
+	for rows.Next() {
+		var phoneNumber string
+		if err := rows.Scan(&phoneNumber); err != nil {
+			s.logger.Println(err)
+			continue
+		}
+		retired = append(retired, phoneNumber)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if len(retired) > 0 {
+		s.logger.Printf("DELETE %v rows affected for RetireFinishedPickups()\n", len(retired))
+	}
+	return retired, nil
+}
+
+//Scan an inprogress row selected with SELECT *
+func scanPickup(row rowScanner) (Pickup, error) {
+	var tmpPickup Pickup
+	err := row.Scan(&tmpPickup.PhoneNumber, &tmpPickup.devicePhrase, &tmpPickup.InitialLocation.Latitude, &tmpPickup.InitialLocation.Longitude, &tmpPickup.InitialTime, &tmpPickup.LatestLocation.Latitude, &tmpPickup.LatestLocation.Longitude, &tmpPickup.LatestTime, &tmpPickup.ConfirmTime, &tmpPickup.CompleteTime, &tmpPickup.Status, &tmpPickup.version, &tmpPickup.ConfirmDriverId, &tmpPickup.CompleteDriverId, &tmpPickup.StatusActor, &tmpPickup.StatusTime, &tmpPickup.VanId, &tmpPickup.DriverId, &tmpPickup.SuggestedVanId, &tmpPickup.retireTime)
+	return tmpPickup, err
+}
+
+//SELECT the newest row for a phone number from inprogress table
+func (s *postgresStore) GetPickup(phoneNumber string) (Pickup, bool, error) {
+	tmpPickup, err := scanPickup(s.db.QueryRow(`SELECT * FROM inprogress
+		WHERE PhoneNumber = $1
+		ORDER BY InitialTime DESC
+		LIMIT 1;`, phoneNumber))
+	if err == sql.ErrNoRows {
+		return tmpPickup, false, nil
+	} else if err != nil {
+		return tmpPickup, false, err
+	}
+	return tmpPickup, true, nil
+}
+
+//SELECT every row from inprogress table, oldest first so the newest pickup for a phone number comes last
+func (s *postgresStore) ListPickups() ([]Pickup, error) {
+	rows, err := s.db.Query(`SELECT * FROM inprogress ORDER BY InitialTime;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]Pickup, 0)
+	for rows.Next() {
+		tmpPickup, err := scanPickup(rows)
+		if err != nil {
+			s.logger.Println(err)
+			continue
+		}
+		list = append(list, tmpPickup)
+	}
+	return list, rows.Err()
+}
+
+//INSERT the write in pickup_outbox table for the outbox worker
+func (s *postgresStore) QueuePickupWrite(operation string, targetPickup Pickup, actor string) (string, error) {
+	requestId := s.databaseEnqueuePickupWrite(operation, targetPickup, actor)
+	if requestId == "" {
+		return "", errors.New("write could not be queued")
+	}
+	return requestId, nil
+}
+
+func (s *postgresStore) GetQueuedWrite(requestId string) (queuedWrite, bool, error) {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return queuedWrite{}, false, errors.New("database unavailable")
+	}
+	tmp, exist := s.selectQueuedWrite(requestId)
+	return tmp, exist, nil
+}
+
+//DELETE rows of pickup_outbox table that were applied or given up on before the given time. Runs on every instance, so it is safe to delete a row twice.
+func (s *postgresStore) DeleteFinishedWrites(before time.Time) (int, error) {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return 0, errors.New("database unavailable")
+	}
+
+	result, err := s.db.Exec(`DELETE FROM pickup_outbox
+		WHERE State <> $1 AND ProcessedTime < $2;`, outboxQueued, before)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return int(rowsAffected), nil
+}
+
+//SELECT every row of vans table ordered by VanId. Accessibility features are kept separated by commas.
+func (s *postgresStore) ListVans() ([]Van, error) {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return nil, errors.New("database unavailable")
+	}
+
+	rows, err := s.db.Query(`SELECT VanId, Callsign, Capacity, Active, Plate, Accessibility FROM vans ORDER BY VanId;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]Van, 0)
+	for rows.Next() {
+		var tmpVan Van
+		var accessibility string
+		if err := rows.Scan(&tmpVan.Id, &tmpVan.Callsign, &tmpVan.Capacity, &tmpVan.Active, &tmpVan.Plate, &accessibility); err != nil {
+			s.logger.Println(err)
+			continue
+		}
+		tmpVan.Accessibility = make([]string, 0)
+		if accessibility != "" {
+			tmpVan.Accessibility = strings.Split(accessibility, ",")
+		}
+		list = append(list, tmpVan)
+	}
+	return list, rows.Err()
+}
+
+//INSERT new van row in vans table
+func (s *postgresStore) CreateVan(targetVan Van) error {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return errors.New("database unavailable")
+	}
+
+	if _, err := s.db.Exec(`INSERT INTO vans (VanId, Callsign, Capacity, Active, Plate, Accessibility)
+		VALUES ($1, $2, $3, $4, $5, $6);`, targetVan.Id, targetVan.Callsign, targetVan.Capacity, targetVan.Active, targetVan.Plate, strings.Join(targetVan.Accessibility, ",")); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" { //unique_violation
+			return errVanExists
+		}
+		return err
+	}
+	return nil
+}
+
+//UPDATE callsign, capacity, active flag, plate and accessibility of a van row in vans table
+func (s *postgresStore) UpdateVan(targetVan Van) error {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return errors.New("database unavailable")
+	}
+
+	result, err := s.db.Exec(`UPDATE vans
+		SET Callsign = $1, Capacity = $2, Active = $3, Plate = $4, Accessibility = $5
+		WHERE VanId = $6;`, targetVan.Callsign, targetVan.Capacity, targetVan.Active, targetVan.Plate, strings.Join(targetVan.Accessibility, ","), targetVan.Id)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return errNoSuchVan
+	}
+	return nil
+}
+
+//UPDATE new van location and reporting driver in vanlocations table, or INSERT the van's first row
+func (s *postgresStore) UpdateVanLocation(vanId int, vanLocation Location) error {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return errors.New("database unavailable")
+	}
+
+	tx, err := s.beginWrite(s.instanceId)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE vanlocations
+		SET LatestLatitude = $1, LatestLongitude = $2, LatestTime = $3, DriverId = $5, Heading = $6
+		WHERE VanId = $4;`, vanLocation.Latitude, vanLocation.Longitude, vanLocation.latestTime, vanId, vanLocation.driverId, vanLocation.Heading)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		if _, err := tx.Exec("INSERT INTO vanlocations (VanId, LatestLatitude, LatestLongitude, LatestTime, DriverId, Heading) VALUES ($1, $2, $3, $4, $5, $6);", vanId, vanLocation.Latitude, vanLocation.Longitude, vanLocation.latestTime, vanLocation.driverId, vanLocation.Heading); err != nil {
+			return err
+		}
+		s.logger.Println("Created new van row on DB.")
+	}
+	return tx.Commit()
+}
+
+//Scan a vanlocations row selected with SELECT *
+func scanVanLocation(row rowScanner) (int, Location, error) {
+	var vanId, version int
+	var tmpLocation Location
+	err := row.Scan(&vanId, &tmpLocation.Latitude, &tmpLocation.Longitude, &tmpLocation.latestTime, &version, &tmpLocation.driverId, &tmpLocation.Heading)
+	return vanId, tmpLocation, err
+}
+
+func (s *postgresStore) ListVanLocations() (map[int]Location, error) {
+	rows, err := s.db.Query(`SELECT * FROM vanlocations;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make(map[int]Location)
+	for rows.Next() {
+		vanId, tmpLocation, err := scanVanLocation(rows)
+		if err != nil {
+			s.logger.Println(err)
+			continue
+		}
+		list[vanId] = tmpLocation
+	}
+	return list, rows.Err()
+}
+
+func (s *postgresStore) GetVanLocation(vanId int) (Location, bool, error) {
+	_, tmpLocation, err := scanVanLocation(s.db.QueryRow(`SELECT * FROM vanlocations WHERE VanId = $1;`, vanId))
+	if err == sql.ErrNoRows {
+		return tmpLocation, false, nil
+	} else if err != nil {
+		return tmpLocation, false, err
+	}
+	return tmpLocation, true, nil
+}
+
+//Follow the notifyphonenumber channel
+func (s *postgresStore) WatchPickups(changed func(phoneNumber string, local bool)) error {
+	s.watchLock.Lock()
+	s.pickupsChanged = changed
+	s.watchLock.Unlock()
+	return s.listen("notifyphonenumber")
+}
+
+//Follow the notifyvanlocation channel
+func (s *postgresStore) WatchVanLocations(changed func(vanId int, local bool)) error {
+	s.watchLock.Lock()
+	s.vansChanged = changed
+	s.watchLock.Unlock()
+	return s.listen("notifyvanlocation")
+}
+
+//Start the outbox worker. It applies this instance's queued writes, and every 30 seconds looks for writes left behind by instances that stopped.
+func (s *postgresStore) WatchQueuedWrites(finished func(targetWrite queuedWrite, targetPickup Pickup)) error {
+	s.watchLock.Lock()
+	s.writesFinished = finished
+	s.watchLock.Unlock()
+
+	//spawn go routine to continuously read and run functions in the channel
+	go func() {
+		for {
+			select {
+			case tmp := <-s.serialChannel:
+				tmp()
+			case <-s.closed:
+				return
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(time.Duration(30) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.wakeOutboxWorker()
+			case <-s.closed:
+				return
+			}
+		}
+	}()
+
+	s.wakeOutboxWorker()
+	return nil
+}
+
+//Listen on a notification channel, starting the listener on first use
+func (s *postgresStore) listen(channel string) error {
+	s.watchLock.Lock()
+	defer s.watchLock.Unlock()
+
+	select {
+	case <-s.closed:
+		return errors.New("store closed")
+	default:
+	}
+
+	if s.listener == nil {
+		if !checkDatabaseHandleValid(s.db, s.logger) {
+			return errors.New("database unavailable")
+		}
+
+		//Create handler for logging listener errors
+		reportProblem := func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				s.logger.Println(err)
+			}
+			if ev == pq.ListenerEventReconnected {
+				s.logger.Println("Database listener reconnected")
+			}
+		}
+		s.listener = pq.NewListener(s.databaseURL, 10*time.Second, time.Minute, reportProblem)
+
+		go s.forwardNotifications()
+	}
+	return s.listener.Listen(channel)
+}
+
+//Stop the outbox worker and close the listener, which ends forwardNotifications. The database handle is left open for its owner to close.
+func (s *postgresStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		s.watchLock.Lock()
+		defer s.watchLock.Unlock()
+		if s.listener != nil {
+			err = s.listener.Close()
+		}
+	})
+	return err
+}
+
+//Monitor for notifications in background and pass them to the watchers until the listener is closed
+func (s *postgresStore) forwardNotifications() {
+	for notificationObj := range s.listener.Notify {
+		s.watchLock.Lock()
+		pickupsChanged := s.pickupsChanged
+		vansChanged := s.vansChanged
+		s.watchLock.Unlock()
+
+		//A nil notification means the listener reconnected and notifications may have been missed, so reload everything
+		if notificationObj == nil {
+			if pickupsChanged != nil {
+				pickupsChanged("", false)
+			}
+			if vansChanged != nil {
+				vansChanged(0, false)
+			}
+			continue
+		}
+
+		key, instanceId := parseNotificationPayload(notificationObj.Extra)
+		local := instanceId == s.instanceId
+
+		switch notificationObj.Channel {
+		case "notifyphonenumber":
+			if pickupsChanged != nil {
+				pickupsChanged(key, local)
+			}
+		case "notifyvanlocation":
+			if vanId, err := strconv.Atoi(key); err != nil {
+				s.logger.Println(err)
+			} else if vansChanged != nil {
+				vansChanged(vanId, local)
+			}
+		}
+	}
+}
+
+//SELECT whether a session in sessions table has been revoked
+func (s *postgresStore) IsSessionActive(sessionId string) (bool, error) {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return false, errors.New("database unavailable")
+	}
+
+	var revoked bool
+	if err := s.db.QueryRow("SELECT Revoked FROM sessions WHERE SessionId = $1;", sessionId).Scan(&revoked); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return !revoked, nil
+}
+
+//SELECT whether the phone number's row in phoneverifications table for the device has been verified
+func (s *postgresStore) IsPhoneNumberVerified(phoneNumber string, deviceId string) (bool, error) {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return false, errors.New("database unavailable")
+	}
+
+	var verifiedTime pq.NullTime
+	if err := s.db.QueryRow("SELECT VerifiedTime FROM phoneverifications WHERE PhoneNumber = $1 AND DeviceId = $2;", phoneNumber, deviceId).Scan(&verifiedTime); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return verifiedTime.Valid, nil
+}
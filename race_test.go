@@ -0,0 +1,309 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+//Riders, drivers, a dispatcher and the inactivity sweeps working the same pickups at once. Run with -race.
+func TestConcurrentPickupHandlers(t *testing.T) {
+	ts := newTestServer(t)
+	const riderCount = 24
+
+	numbers := make([]string, riderCount)
+	for i := range numbers {
+		numbers[i] = fmt.Sprintf("41055502%02d", i)
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan bool)
+
+	for i, number := range numbers {
+		wg.Add(1)
+		go func(i int, number string, token string) {
+			defer wg.Done()
+			<-start
+
+			ts.request(token, "/newPickup", url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}})
+			for j := 0; j < 5; j++ {
+				ts.request(token, "/getPickupInfo", url.Values{"latitude": {"38.98"}, "longitude": {fmt.Sprintf("-76.4%v", j)}})
+				ts.request(token, "/updatePickupLocation", url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}})
+			}
+			if i%4 == 0 {
+				ts.request(token, "/cancelPickup", url.Values{})
+			}
+		}(i, number, ts.riderToken(number, testDevice))
+	}
+
+	for van := 1; van <= 4; van++ {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			<-start
+
+			for j := 0; j < 3; j++ {
+				ts.request(token, "/getPickupList", url.Values{})
+				for _, number := range numbers {
+					ts.request(token, "/confirmPickup", url.Values{"phoneNumber": {number}})
+					ts.request(token, "/getPickupInfo", url.Values{"phoneNumber": {number}})
+					ts.request(token, "/completePickup", url.Values{"phoneNumber": {number}})
+				}
+			}
+		}(ts.driverToken(van, van))
+	}
+
+	wg.Add(1)
+	go func(token string) {
+		defer wg.Done()
+		<-start
+
+		for _, number := range numbers[1:] {
+			if number[len(number)-1] == '3' {
+				ts.request(token, "/cancelPickup", url.Values{"phoneNumber": {number}})
+			}
+		}
+	}(ts.staffToken(dispatcherRole, 9))
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-start
+
+		for j := 0; j < 10; j++ {
+			ts.clock.Advance(20 * time.Second)
+			ts.server.removeInactivePickups(ts.server.configMinutes("pickupTimeoutMinutes"))
+			ts.server.retireFinishedPickups()
+			ts.server.redispatchPickups(nil)
+		}
+	}()
+
+	close(start)
+	wg.Wait()
+
+	//every pickup in memory matches the store, whichever request won each race
+	for _, number := range numbers {
+		current, inMemory := ts.memoryPickup(number)
+		stored, inStore := ts.storedPickup(number)
+		if !inStore {
+			if inMemory && current.Status.isActive() {
+				t.Errorf("pickup %v is %v in memory but not in the store", number, current.Status)
+			}
+			continue
+		}
+		if !inMemory || current.Status != stored.Status || current.version != stored.version {
+			t.Errorf("pickup %v is %v version %v in memory, %v version %v in the store", number, current.Status, current.version, stored.Status, stored.version)
+		}
+	}
+}
+
+//Drivers racing for one pickup: exactly one confirm wins and the pickup belongs to that van
+func TestConcurrentConfirmSamePickup(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+
+	const driverCount = 8
+	responses := make([]string, driverCount+1)
+
+	var wg sync.WaitGroup
+	start := make(chan bool)
+	for van := 1; van <= driverCount; van++ {
+		wg.Add(1)
+		go func(van int, token string) {
+			defer wg.Done()
+			<-start
+			responses[van] = ts.request(token, "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+		}(van, ts.driverToken(van, van))
+	}
+	close(start)
+	wg.Wait()
+
+	var winner int
+	for van := 1; van <= driverCount; van++ {
+		if responses[van] == successResponse {
+			if winner != 0 {
+				t.Fatalf("vans %v and %v both confirmed the pickup", winner, van)
+			}
+			winner = van
+		}
+	}
+	if winner == 0 {
+		t.Fatal("no van confirmed the pickup")
+	}
+
+	current, _ := ts.memoryPickup(testRider)
+	stored, _ := ts.storedPickup(testRider)
+	if current.VanId != winner || stored.VanId != winner || current.ConfirmDriverId != winner {
+		t.Errorf("pickup held by van %v in memory, %v in store, want van %v", current.VanId, stored.VanId, winner)
+	}
+}
+
+//A rider reporting their location while drivers change the status never loses either write
+func TestConcurrentLocationAndStatus(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+	rider := ts.riderToken(testRider, testDevice)
+	driver := ts.driverToken(3, 2)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 50; j++ {
+			ts.request(rider, "/updatePickupLocation", url.Values{"latitude": {"38.99"}, "longitude": {"-76.49"}})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		ts.request(driver, "/confirmPickup", url.Values{"phoneNumber": {testRider}})
+		for _, v := range []string{"enRoute", "arrived"} {
+			ts.request(driver, "/updatePickupStatus", url.Values{"phoneNumber": {testRider}, "status": {v}})
+		}
+	}()
+	wg.Wait()
+
+	stored, _ := ts.storedPickup(testRider)
+	if stored.Status != arrived || stored.LatestLocation.Latitude != 38.99 {
+		t.Errorf("store has %v at %v, want arrived at 38.99", stored.Status, stored.LatestLocation)
+	}
+	if current, _ := ts.memoryPickup(testRider); current.Status != arrived || current.LatestLocation.Latitude != 38.99 {
+		t.Errorf("memory has %v at %v, want arrived at 38.99", current.Status, current.LatestLocation)
+	}
+}
+
+//Vans reporting while riders, dispatchers and the sweeps read van locations, plan routes and reload from the store. Run with -race.
+func TestConcurrentVanHandlers(t *testing.T) {
+	ts := newTestServer(t)
+	const vanCount = 4
+
+	numbers := make([]string, 12)
+	for i := range numbers {
+		numbers[i] = fmt.Sprintf("41055503%02d", i)
+		ts.newPickup(numbers[i], testDevice, "38.98", fmt.Sprintf("-76.4%v", i%10))
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan bool)
+
+	for van := 1; van <= vanCount; van++ {
+		wg.Add(1)
+		go func(van int, token string) {
+			defer wg.Done()
+			<-start
+
+			for j := 0; j < 20; j++ {
+				ts.request(token, "/updateVanLocation", url.Values{"vanNumber": {fmt.Sprint(van)}, "latitude": {fmt.Sprintf("38.9%v", j%10)}, "longitude": {"-76.48"}, "heading": {"90"}})
+				ts.request(token, "/getVanRoute", url.Values{})
+				ts.request(token, "/claimPickup", url.Values{"phoneNumber": {numbers[(van+j)%len(numbers)]}})
+				ts.request(token, "/unassignPickup", url.Values{"phoneNumber": {numbers[(van+j)%len(numbers)]}})
+			}
+		}(van, ts.driverToken(van, van))
+	}
+
+	for i, number := range numbers {
+		wg.Add(1)
+		go func(i int, token string) {
+			defer wg.Done()
+			<-start
+
+			for j := 0; j < 10; j++ {
+				ts.request(token, "/getVanLocations", url.Values{})
+				ts.request(token, "/getPickupInfo", url.Values{})
+				ts.request(token, "/updatePickupLocation", url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}})
+			}
+		}(i, ts.riderToken(number, testDevice))
+	}
+
+	wg.Add(1)
+	go func(token string) {
+		defer wg.Done()
+		<-start
+
+		for j := 0; j < 10; j++ {
+			ts.request(token, "/reassignPickup", url.Values{"phoneNumber": {numbers[j]}, "vanId": {fmt.Sprint(j%vanCount + 1)}})
+			ts.request(token, "/getVanRoute", url.Values{"vanId": {fmt.Sprint(j%vanCount + 1)}})
+			ts.request(token, "/getPickupList", url.Values{})
+			ts.request("", "/uptime", url.Values{})
+		}
+	}(ts.staffToken(dispatcherRole, 9))
+
+	//the sweeps and the reloads that follow changes made by other instances
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-start
+
+		for j := 0; j < 10; j++ {
+			ts.clock.Advance(time.Minute)
+			stale := ts.server.removeInactiveVanLocations(ts.server.configMinutes("vanTimeoutMinutes"))
+			ts.server.publishBoardVansRemoved(stale)
+			ts.server.redispatchPickups(stale)
+			ts.server.vanStoreChanged(j%vanCount+1, false)
+			ts.server.vanStoreChanged(0, false)
+			ts.server.pickupStoreChanged(numbers[j], false)
+			ts.server.pickupStoreChanged("", false)
+		}
+	}()
+
+	close(start)
+	wg.Wait()
+
+	//once the last reload settles, every van in memory is either cleared or where the store last saw it
+	ts.server.vanStoreChanged(0, false)
+	for van := 1; van <= vanCount; van++ {
+		current, inMemory := ts.server.vanLocations.get(van)
+		stored, inStore, _ := ts.store.GetVanLocation(van)
+		if inMemory && (!inStore || current.Latitude != stored.Latitude || !current.latestTime.Equal(stored.latestTime)) {
+			t.Errorf("van %v is at %+v in memory, %+v in the store", van, current, stored)
+		}
+	}
+}
+
+//Board messages for changes published from their own goroutines still reach clients in the order the changes were made. Run with -race.
+func TestConcurrentBoardPickupUpdates(t *testing.T) {
+	ts := newTestServer(t)
+	const changeCount = 40
+
+	client := ts.server.addBoardClient(Session{Role: dispatcherRole, DriverId: 7})
+	defer ts.server.removeBoardClient(client)
+
+	ts.server.pickupsLock.Lock()
+	ts.server.pickups[testRider] = Pickup{PhoneNumber: testRider, devicePhrase: testDevice, Status: pending}
+	ts.server.pickupsLock.Unlock()
+
+	//every change moves the pickup to the next van, the way handlers commit a change and publish it once they release pickupsLock
+	var wg sync.WaitGroup
+	for i := 0; i < changeCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ts.server.pickupsLock.Lock()
+			tmp := ts.server.pickups[testRider]
+			tmp.VanId++
+			ts.server.pickups[testRider] = tmp
+			ts.server.pickupsLock.Unlock()
+
+			ts.server.publishBoardPickup(testRider)
+		}()
+	}
+	wg.Wait()
+
+	<-client.send //snapshot
+	latest := 0
+	for len(client.send) > 0 {
+		var message boardMessage
+		if err := json.Unmarshal(<-client.send, &message); err != nil || message.Pickup == nil {
+			t.Fatalf("got board message %+v, %v", message, err)
+		}
+		if message.Pickup.VanId < latest {
+			t.Fatalf("board got van %v after van %v", message.Pickup.VanId, latest)
+		}
+		latest = message.Pickup.VanId
+	}
+	if latest != changeCount {
+		t.Errorf("board ended at van %v, want %v", latest, changeCount)
+	}
+}
@@ -0,0 +1,105 @@
+package shipmate
+
+import (
+	"fmt"
+	"net/http"
+)
+
+//Session roles
+const riderRole string = "rider"
+const driverRole string = "driver"
+const dispatcherRole string = "dispatcher"
+const adminRole string = "admin"
+
+//Roles that can be given to accounts in the drivers table. Riders register themselves by phone number instead.
+var staffRoles = []string{driverRole, dispatcherRole, adminRole}
+
+type permission string
+
+const (
+	createPickupPermission    permission = "pickup:create"
+	viewOwnPickupPermission   permission = "pickup:view:own"
+	locateOwnPickupPermission permission = "pickup:locate:own"
+	viewAnyPickupPermission   permission = "pickup:view:any"
+	cancelOwnPickupPermission permission = "pickup:cancel:own"
+	cancelAnyPickupPermission permission = "pickup:cancel:any"
+	listPickupsPermission     permission = "pickup:list"
+	confirmPickupPermission   permission = "pickup:confirm"
+	completePickupPermission  permission = "pickup:complete"
+	progressPickupPermission  permission = "pickup:progress"
+	claimPickupPermission     permission = "pickup:claim"
+	reassignPickupPermission  permission = "pickup:reassign"
+	updateOwnVanPermission    permission = "van:update:own"
+	updateAnyVanPermission    permission = "van:update:any"
+	manageAccountsPermission  permission = "accounts:manage"
+	manageConfigPermission    permission = "config:manage"
+	manageVansPermission      permission = "vans:manage"
+)
+
+//Permission matrix. Riders act on their own pickup, drivers work pickups and report their own van, dispatchers may override any pickup, admins additionally manage accounts, vans and configuration.
+var rolePermissions = map[string][]permission{
+	riderRole: {
+		createPickupPermission,
+		viewOwnPickupPermission,
+		locateOwnPickupPermission,
+		cancelOwnPickupPermission,
+	},
+	driverRole: {
+		viewAnyPickupPermission,
+		listPickupsPermission,
+		confirmPickupPermission,
+		completePickupPermission,
+		progressPickupPermission,
+		claimPickupPermission,
+		updateOwnVanPermission,
+	},
+	dispatcherRole: {
+		viewAnyPickupPermission,
+		listPickupsPermission,
+		cancelAnyPickupPermission,
+		reassignPickupPermission,
+	},
+	adminRole: {
+		viewAnyPickupPermission,
+		listPickupsPermission,
+		cancelAnyPickupPermission,
+		reassignPickupPermission,
+		updateAnyVanPermission,
+		manageAccountsPermission,
+		manageConfigPermission,
+		manageVansPermission,
+	},
+}
+
+func hasPermission(role string, targetPermission permission) bool {
+	for _, v := range rolePermissions[role] {
+		if v == targetPermission {
+			return true
+		}
+	}
+	return false
+}
+
+func isStaffRole(role string) bool {
+	for _, v := range staffRoles {
+		if v == role {
+			return true
+		}
+	}
+	return false
+}
+
+//Resolve the caller's session and only run the handler if their role holds at least one of the permissions
+func (s *Server) authorize(handler sessionHandlerFunc, permissions ...permission) http.HandlerFunc {
+	return s.withSession(func(w http.ResponseWriter, r *http.Request, session Session) {
+		for _, v := range permissions {
+			if hasPermission(session.Role, v) {
+				handler(w, r, session)
+				return
+			}
+		}
+
+		s.logger.Printf("Role %v denied %v %v\n", session.Role, r.URL.Path, permissions)
+		fmt.Fprint(w, wrongPasswordResponse)
+	})
+}
@@ -0,0 +1,236 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//One pickup in a van's route
+type routeStop struct {
+	PhoneNumber string       `json:"phoneNumber"`
+	Location    Location     `json:"location"`
+	Status      PickupStatus `json:"status"`
+	LegKm       float64      `json:"legKm"`   //distance from the previous stop
+	TotalKm     float64      `json:"totalKm"` //distance from the start of the route
+}
+
+//Order a van should serve its pickups in
+type VanRoute struct {
+	VanId        int         `json:"vanId"`
+	Start        Location    `json:"start"`
+	Stops        []routeStop `json:"stops"`
+	Deferred     []string    `json:"deferred"` //pickups that do not fit in the van until earlier stops are served
+	DistanceKm   float64     `json:"distanceKm"`
+	ComputedTime time.Time   `json:"computedTime"`
+	stopsKey     string
+}
+
+//Active pickups held by a van, oldest first, leaving out pickups whose rider has gone. Caller must hold pickupsLock.
+func (s *Server) vanPickups(vanId int) []Pickup {
+	held := make([]Pickup, 0)
+	for _, v := range s.pickups {
+		if v.VanId == vanId && v.Status.isActive() && !isPickupAbandoned(v) {
+			held = append(held, v)
+		}
+	}
+	sort.Slice(held, func(i, j int) bool {
+		return held[i].InitialTime.Before(held[j].InitialTime)
+	})
+	return held
+}
+
+//Identify a set of pickups so a route is only planned again when pickups are added, canceled or completed
+func routeStopsKey(held []Pickup) string {
+	keys := make([]string, len(held))
+	for i, v := range held {
+		keys[i] = v.PhoneNumber + "@" + strconv.FormatInt(v.InitialTime.UnixNano(), 10) + "#" + strconv.Itoa(int(v.Status))
+	}
+	return strings.Join(keys, ",")
+}
+
+//Total length of a path visiting locations in order
+func pathLengthKm(path []Location) float64 {
+	var total float64
+	for i := 1; i < len(path); i++ {
+		total += haversineKm(path[i-1], path[i])
+	}
+	return total
+}
+
+//Order stops starting from start by always driving to the nearest unvisited stop. Returns indexes into stops.
+func nearestNeighbourOrder(start Location, stops []Location) []int {
+	order := make([]int, 0, len(stops))
+	visited := make([]bool, len(stops))
+	current := start
+
+	for len(order) < len(stops) {
+		next := -1
+		for i, v := range stops {
+			if !visited[i] && (next == -1 || haversineKm(current, v) < haversineKm(current, stops[next])) {
+				next = i
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+		current = stops[next]
+	}
+	return order
+}
+
+//Shorten an open path from start by reversing segments while that removes distance (2-opt). The start stays first.
+func twoOptOrder(start Location, stops []Location, order []int) []int {
+	path := make([]Location, len(order)+1)
+	path[0] = start
+	for i, v := range order {
+		path[i+1] = stops[v]
+	}
+
+	improved := true
+	for improved {
+		improved = false
+		for i := 1; i < len(path)-1; i++ {
+			for k := i + 1; k < len(path); k++ {
+				before := haversineKm(path[i-1], path[i])
+				after := haversineKm(path[i-1], path[k])
+				if k+1 < len(path) {
+					before += haversineKm(path[k], path[k+1])
+					after += haversineKm(path[i], path[k+1])
+				}
+
+				//ignore rounding noise so the loop always ends
+				if after < before-1e-9 {
+					for a, b := i, k; a < b; a, b = a+1, b-1 {
+						path[a], path[b] = path[b], path[a]
+						order[a-1], order[b-1] = order[b-1], order[a-1]
+					}
+					improved = true
+				}
+			}
+		}
+	}
+	return order
+}
+
+//Plan the stop order for a van at start holding the pickups in held (oldest first). Riders the van has arrived for are served first, then as many of the oldest pickups as fit in the van, in the shortest order found.
+func planVanRoute(vanId int, start Location, held []Pickup, capacity int, now time.Time) VanRoute {
+	route := VanRoute{VanId: vanId, Start: start, Stops: make([]routeStop, 0), Deferred: make([]string, 0), ComputedTime: now}
+
+	var first, planned []Pickup
+	for _, v := range held {
+		if v.Status == arrived {
+			first = append(first, v)
+		} else if len(first)+len(planned) < capacity {
+			planned = append(planned, v)
+		} else {
+			route.Deferred = append(route.Deferred, v.PhoneNumber)
+		}
+	}
+
+	current := start
+	for _, v := range first {
+		current = v.LatestLocation
+	}
+
+	locations := make([]Location, len(planned))
+	for i, v := range planned {
+		locations[i] = v.LatestLocation
+	}
+	order := twoOptOrder(current, locations, nearestNeighbourOrder(current, locations))
+
+	ordered := append([]Pickup{}, first...)
+	for _, v := range order {
+		ordered = append(ordered, planned[v])
+	}
+
+	previous := start
+	for _, v := range ordered {
+		leg := haversineKm(previous, v.LatestLocation)
+		route.DistanceKm += leg
+		route.Stops = append(route.Stops, routeStop{v.PhoneNumber, v.LatestLocation, v.Status, leg, route.DistanceKm})
+		previous = v.LatestLocation
+	}
+	return route
+}
+
+//Return the planned route of a van, planning it again if its pickups changed. Caller must hold pickupsLock.
+func (s *Server) currentVanRoute(vanId int) VanRoute {
+	held := s.vanPickups(vanId)
+	key := routeStopsKey(held)
+
+	s.vanRoutesLock.Lock()
+	defer s.vanRoutesLock.Unlock()
+
+	if route, exist := s.vanRoutes[vanId]; exist && route.stopsKey == key {
+		return route
+	}
+
+	//start from the van, or from its oldest pickup if the van has not reported recently
+	var start Location
+	if vanLocation := s.currentVanLocation(vanId); vanLocation != nil && s.isVanActive(*vanLocation, s.clock()) {
+		start = *vanLocation
+	} else if len(held) > 0 {
+		start = held[0].LatestLocation
+	}
+
+	route := planVanRoute(vanId, start, held, s.vanCapacity(vanId), s.clock())
+	route.stopsKey = key
+	s.vanRoutes[vanId] = route
+	s.logger.Printf("Planned route for van %v with %v stops, %.2f km\n", vanId, len(route.Stops), route.DistanceKm)
+	return route
+}
+
+//Reply with the stop order for the driver's van. Dispatchers may pass "vanId" to see any van.
+func (s *Server) getVanRoute(w http.ResponseWriter, r *http.Request, session Session) {
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	vanId := session.VanId
+	if value, exist := formValue(r.Form, "vanId"); exist && hasPermission(session.Role, reassignPickupPermission) {
+		vanId = v.vanId("vanId", value)
+	}
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	route, err := s.vanRouteFor(session, vanId)
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	if output, err := json.Marshal(route); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
+
+//Stop order for a van. Drivers may only see their own van's route.
+func (s *Server) vanRouteFor(session Session, vanId int) (VanRoute, error) {
+	if vanId != session.VanId && !hasPermission(session.Role, reassignPickupPermission) {
+		return VanRoute{}, apiErrorf(http.StatusForbidden, vanNotAssignedCode, "driver %v is not assigned to van %v", session.DriverId, vanId)
+	}
+	if vanId < 1 {
+		return VanRoute{}, apiErrorf(http.StatusNotFound, notFoundCode, "no van to plan a route for")
+	}
+	if _, registered := s.vans.get(vanId); !registered {
+		return VanRoute{}, apiErrorf(http.StatusNotFound, unknownVanCode, "no van %v is registered", vanId)
+	}
+
+	s.pickupsLock.RLock()
+	defer s.pickupsLock.RUnlock()
+
+	return s.currentVanRoute(vanId), nil
+}
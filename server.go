@@ -0,0 +1,282 @@
+package shipmate
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"google.golang.org/grpc"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+//A Shipmate instance: the pickups and vans it keeps in memory, the stores they are kept in, and the routes serving them. Servers share nothing, so several may run in one process, each with its own stores.
+type Server struct {
+	//Pickups in memory. Handlers hold pickupsLock from reading a pickup until the change is committed, so a pickup is only ever changed by one request at a time. Take pickupsLock before any other lock.
+	pickups     map[string]Pickup
+	pickupsLock *sync.RWMutex
+
+	vanLocations vanTable
+	vans         vanRegistry
+
+	startTime time.Time
+
+	//Time of pickup and van changes. Tests replace it to move time forward without waiting.
+	clock func() time.Time
+
+	logger *log.Logger
+	mux    *http.ServeMux
+
+	db          *sql.DB
+	databaseURL string
+
+	pickupStore  PickupStore
+	vanStore     VanStore
+	sessionStore SessionStore
+	smsSender    SMSSender
+
+	//HMAC key shared by every instance so tokens issued by one dyno are accepted by the others
+	tokenSecret       []byte
+	randomTokenSecret bool
+
+	//Settings used until an admin changes them, and the changes loaded from the config table
+	configDefaults map[string]float64
+	configValues   map[string]float64
+	configLock     sync.RWMutex
+
+	//Connected board clients and the phone numbers they have been sent, so changes can be told apart from additions
+	boardClients     map[*boardClient]bool
+	boardPickupsSent map[string]bool
+	boardClientsLock sync.Mutex
+
+	//Streams waiting for changes, keyed by the channel each stream waits on, with the phone number it shows. Channels hold one value so a burst of changes wakes a stream once.
+	pickupSubscribers     map[chan bool]string
+	pickupSubscribersLock sync.Mutex
+
+	//Van speeds measured from consecutive location updates, smoothed so a single red light does not swing the estimate
+	vanSpeeds     map[int]float64
+	vanSpeedsLock sync.Mutex
+
+	//Last route planned for each van. A route is planned again when the van's set of pickups changes.
+	vanRoutes     map[int]VanRoute
+	vanRoutesLock sync.Mutex
+
+	//Shipmate gRPC service, served on its own port by ServeGRPC
+	grpc *grpc.Server
+
+	done      chan bool
+	closeOnce sync.Once
+}
+
+//Changes a Server from its defaults in NewServer
+type Option func(*Server)
+
+//Keep accounts, sessions, verification codes, configuration, pickup timelines and queued writes in Postgres, and pickups and van locations too unless another store is given. databaseURL is used to LISTEN for changes made by other instances. Servers without a database keep none of these.
+func WithDatabase(handle *sql.DB, databaseURL string) Option {
+	return func(s *Server) {
+		s.db = handle
+		s.databaseURL = databaseURL
+	}
+}
+
+//Keep pickups, vans and van locations in process, starting with vans 1 to 5 registered. They are lost on restart and not shared between instances.
+func WithMemoryStore() Option {
+	return func(s *Server) {
+		//options may replace the clock after this one, so read it when a write is queued
+		store := newMemoryStore(func() time.Time { return s.clock() })
+		s.pickupStore = store
+		s.vanStore = store
+	}
+}
+
+func WithPickupStore(store PickupStore) Option {
+	return func(s *Server) {
+		s.pickupStore = store
+	}
+}
+
+func WithVanStore(store VanStore) Option {
+	return func(s *Server) {
+		s.vanStore = store
+	}
+}
+
+func WithSessionStore(store SessionStore) Option {
+	return func(s *Server) {
+		s.sessionStore = store
+	}
+}
+
+//Read the time of pickup and van changes from clock instead of time.Now
+func WithClock(clock func() time.Time) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+//Replace the defaults of settings in configDefaults. Settings saved with /setConfig still take precedence.
+func WithConfig(values map[string]float64) Option {
+	return func(s *Server) {
+		for k, v := range values {
+			s.configDefaults[k] = v
+		}
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+//Register the routes on mux instead of a new one, to serve them next to routes of your own
+func WithMux(mux *http.ServeMux) Option {
+	return func(s *Server) {
+		s.mux = mux
+	}
+}
+
+//Sign access tokens with secret. It must be the same on every instance sharing a database.
+func WithTokenSecret(secret []byte) Option {
+	return func(s *Server) {
+		s.tokenSecret = secret
+	}
+}
+
+func WithSMSSender(sender SMSSender) Option {
+	return func(s *Server) {
+		s.smsSender = sender
+	}
+}
+
+//Create a server and register its routes. Without options it keeps pickups and van locations in memory, logs to stderr and signs tokens with a random secret. Call Start before serving requests.
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		pickups:           make(map[string]Pickup),
+		pickupsLock:       new(sync.RWMutex),
+		startTime:         time.Now(),
+		clock:             time.Now,
+		configDefaults:    make(map[string]float64),
+		boardClients:      make(map[*boardClient]bool),
+		boardPickupsSent:  make(map[string]bool),
+		pickupSubscribers: make(map[chan bool]string),
+		vanSpeeds:         make(map[int]float64),
+		vanRoutes:         make(map[int]VanRoute),
+		done:              make(chan bool),
+	}
+	for k, v := range configDefaults {
+		s.configDefaults[k] = v
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.logger == nil {
+		s.logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if s.mux == nil {
+		s.mux = http.NewServeMux()
+	}
+	for k := range s.configDefaults {
+		if _, exist := configDefaults[k]; !exist {
+			s.logger.Println("Ignoring unknown config key", k)
+			delete(s.configDefaults, k)
+		}
+	}
+
+	if s.tokenSecret == nil {
+		s.tokenSecret = make([]byte, 32)
+		s.randomTokenSecret = true
+		if _, err := rand.Read(s.tokenSecret); err != nil {
+			s.logger.Println(err)
+		}
+	}
+	if s.smsSender == nil {
+		s.smsSender = logSMSSender{s.logger}
+	}
+
+	//sessions and verification codes are kept in Postgres whichever store is chosen
+	if s.sessionStore == nil {
+		s.sessionStore = newPostgresStore(s.db, s.databaseURL, s.logger)
+	}
+	if s.pickupStore == nil || s.vanStore == nil {
+		var store interface {
+			PickupStore
+			VanStore
+		}
+		if s.db == nil {
+			store = newMemoryStore(s.clock)
+			s.logger.Println("Keeping pickups and van locations in memory. They are lost on restart and not shared between instances.")
+		} else {
+			store = newPostgresStore(s.db, s.databaseURL, s.logger)
+		}
+		if s.pickupStore == nil {
+			s.pickupStore = store
+		}
+		if s.vanStore == nil {
+			s.vanStore = store
+		}
+	}
+
+	s.registerRoutes(s.mux)
+	s.grpc = s.newGRPCServer()
+	return s
+}
+
+//Bring the database schema up to date, load pickups and van locations into memory, follow changes made by other instances and start the inactivity sweeps
+func (s *Server) Start() {
+	if s.randomTokenSecret {
+		s.logger.Println("No token secret given. Signing tokens with a random secret, they will not be accepted by other instances or after a restart.")
+	}
+
+	//Bring the database schema up to date, waiting for other dynos migrating at the same time
+	if s.db != nil && s.migrateDatabase() {
+		s.loadConfig()
+	}
+
+	//Load pickups and van locations into memory and follow changes made by other instances
+	s.setupStores()
+
+	go s.checkForInactive()
+}
+
+//Stop the inactivity sweeps, the gRPC service with its open streams, and the store's listener and outbox worker. HTTP requests are still served until the listener is closed.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.grpc.Stop()
+		if s.pickupStore != nil {
+			if err := s.pickupStore.Close(); err != nil {
+				s.logger.Println(err)
+			}
+		}
+		//a separate van store may have its own listener
+		if closer, ok := s.vanStore.(interface{ Close() error }); ok && interface{}(s.vanStore) != interface{}(s.pickupStore) {
+			if err := closer.Close(); err != nil {
+				s.logger.Println(err)
+			}
+		}
+	})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+//Serve the routes on addr until the listener fails
+func (s *Server) ListenAndServe(addr string) error {
+	s.logger.Println("Listening on " + addr)
+	return http.ListenAndServe(addr, s)
+}
+
+//Routes of every endpoint
+func (s *Server) registerRoutes(mux *http.ServeMux) {
+	for _, v := range s.endpoints() {
+		mux.HandleFunc(v.pattern, s.legacyHandler(v))
+	}
+
+	//versioned JSON API
+	s.registerAPIRoutes(mux)
+}
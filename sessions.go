@@ -0,0 +1,374 @@
+package shipmate
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const accessTokenLifetime = time.Duration(15) * time.Minute
+const refreshTokenLifetime = time.Duration(30*24) * time.Hour
+
+//Caller identity resolved from a bearer access token
+type Session struct {
+	Id          string
+	Role        string
+	DriverId    int
+	VanId       int
+	PhoneNumber string
+	DeviceId    string
+	ExpireTime  time.Time
+}
+
+//Payload signed into an access token
+type accessClaims struct {
+	SessionId   string `json:"sid"`
+	Role        string `json:"role"`
+	DriverId    int    `json:"drv,omitempty"`
+	VanId       int    `json:"van,omitempty"`
+	PhoneNumber string `json:"phn,omitempty"`
+	DeviceId    string `json:"dev,omitempty"`
+	ExpireTime  int64  `json:"exp"`
+}
+
+//Response to a successful login, registration or refresh
+type sessionTokens struct {
+	Status       string `json:"status"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` //seconds until access token expires
+	Role         string `json:"role"`
+	DriverId     int    `json:"driverId,omitempty"`
+	VanId        int    `json:"vanId,omitempty"`
+	PhoneNumber  string `json:"phoneNumber,omitempty"`
+}
+
+//Handler that runs after the caller's session has been resolved
+type sessionHandlerFunc func(http.ResponseWriter, *http.Request, Session)
+
+func randomHex(byteCount int) (string, error) {
+	tmp := make([]byte, byteCount)
+	if _, err := rand.Read(tmp); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(tmp), nil
+}
+
+func sha256Hex(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) tokenSignature(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, s.tokenSecret)
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
+
+//Sign session identity into an access token of the form payload.signature
+func (s *Server) signAccessToken(targetSession Session) (string, error) {
+	payload, err := json.Marshal(accessClaims{targetSession.Id, targetSession.Role, targetSession.DriverId, targetSession.VanId, targetSession.PhoneNumber, targetSession.DeviceId, targetSession.ExpireTime.Unix()})
+	if err != nil {
+		return "", err
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encodedPayload + "." + base64.RawURLEncoding.EncodeToString(s.tokenSignature(encodedPayload)), nil
+}
+
+//Verify access token signature and expiry. Does not check whether the session has been revoked.
+func (s *Server) parseAccessToken(token string) (Session, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return Session{}, errors.New("malformed access token")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, s.tokenSignature(parts[0])) {
+		return Session{}, errors.New("invalid access token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Session{}, err
+	}
+	var claims accessClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Session{}, err
+	}
+
+	expireTime := time.Unix(claims.ExpireTime, 0)
+	if s.clock().After(expireTime) {
+		return Session{}, errors.New("access token expired")
+	}
+
+	return Session{claims.SessionId, claims.Role, claims.DriverId, claims.VanId, claims.PhoneNumber, claims.DeviceId, expireTime}, nil
+}
+
+//INSERT new session row in sessions table
+func (s *Server) databaseInsertSession(targetSession Session, refreshTokenHash string, refreshExpireTime time.Time) bool {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if _, err := s.db.Exec(`INSERT INTO sessions (SessionId, Role, DriverId, PhoneNumber, DeviceId, RefreshTokenHash, CreatedTime, RefreshExpireTime, VanId)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`, targetSession.Id, targetSession.Role, targetSession.DriverId, targetSession.PhoneNumber, targetSession.DeviceId, refreshTokenHash, s.clock(), refreshExpireTime, targetSession.VanId); err != nil {
+			s.logger.Println(err)
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+//UPDATE refresh token hash of a session if the previous hash still matches. Return false if the refresh token was already rotated.
+func (s *Server) databaseRotateSessionRefreshToken(sessionId string, previousHash string, newHash string, refreshExpireTime time.Time) bool {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if result, err := s.db.Exec(`UPDATE sessions
+			SET RefreshTokenHash = $1, RefreshExpireTime = $2
+			WHERE SessionId = $3 AND RefreshTokenHash = $4 AND Revoked = FALSE;`, newHash, refreshExpireTime, sessionId, previousHash); err != nil {
+			s.logger.Println(err)
+		} else if rowsAffected, _ := result.RowsAffected(); rowsAffected == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+//UPDATE session to revoked in sessions table
+func (s *Server) databaseRevokeSession(sessionId string) bool {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if _, err := s.db.Exec("UPDATE sessions SET Revoked = TRUE WHERE SessionId = $1;", sessionId); err != nil {
+			s.logger.Println(err)
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+//UPDATE every session of a staff account to revoked, used when the account is changed or disabled
+func (s *Server) databaseRevokeDriverSessions(driverId int) bool {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if _, err := s.db.Exec("UPDATE sessions SET Revoked = TRUE WHERE Role <> $1 AND DriverId = $2;", riderRole, driverId); err != nil {
+			s.logger.Println(err)
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+//DELETE sessions whose refresh token has expired
+func (s *Server) databaseDeleteExpiredSessions() {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if result, err := s.db.Exec("DELETE FROM sessions WHERE RefreshExpireTime < $1;", s.clock()); err != nil {
+			s.logger.Println(err)
+		} else if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+			s.logger.Printf("DELETE %v expired sessions\n", rowsAffected)
+		}
+	}
+}
+
+//Check that the session exists and has not been revoked on any instance
+func (s *Server) isSessionActive(sessionId string) bool {
+	active, err := s.sessionStore.IsSessionActive(sessionId)
+	if err != nil {
+		s.logger.Println(err)
+	}
+	return active
+}
+
+//Create a session in the database and return its access and refresh tokens
+func (s *Server) issueSessionTokens(targetSession Session) (sessionTokens, bool) {
+	sessionId, err := randomHex(16)
+	if err != nil {
+		s.logger.Println(err)
+		return sessionTokens{}, false
+	}
+	refreshSecret, err := randomHex(32)
+	if err != nil {
+		s.logger.Println(err)
+		return sessionTokens{}, false
+	}
+
+	targetSession.Id = sessionId
+	targetSession.ExpireTime = s.clock().Add(accessTokenLifetime)
+	if !s.databaseInsertSession(targetSession, sha256Hex(refreshSecret), s.clock().Add(refreshTokenLifetime)) {
+		return sessionTokens{}, false
+	}
+
+	accessToken, err := s.signAccessToken(targetSession)
+	if err != nil {
+		s.logger.Println(err)
+		return sessionTokens{}, false
+	}
+
+	return sessionTokens{"0", accessToken, targetSession.Id + "." + refreshSecret, int(accessTokenLifetime.Seconds()), targetSession.Role, targetSession.DriverId, targetSession.VanId, targetSession.PhoneNumber}, true
+}
+
+func (s *Server) writeSessionTokens(w http.ResponseWriter, tokens sessionTokens) {
+	if output, err := json.Marshal(tokens); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
+
+//Read the token from an "Authorization: Bearer <token>" header, or the "accessToken" parameter when opening a stream
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	//browsers cannot set headers when opening a WebSocket or EventSource, so streams may pass the token in the URL
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return r.URL.Query().Get("accessToken")
+	}
+	return ""
+}
+
+//Resolve the caller's session from the bearer token before running the handler
+func (s *Server) withSession(handler sessionHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		//bypass same origin policy
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		//answer CORS preflight so browsers may send the Authorization header
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			return
+		}
+
+		targetSession, err := s.sessionFromRequest(r)
+		if err != nil {
+			s.logger.Println(err)
+			fmt.Fprint(w, wrongPasswordResponse)
+			return
+		}
+
+		handler(w, r, targetSession)
+	}
+}
+
+//Session of the bearer token sent with a request. Fails if the token is missing, invalid, expired or its session was revoked.
+func (s *Server) sessionFromRequest(r *http.Request) (Session, error) {
+	return s.sessionFromToken(bearerToken(r))
+}
+
+//Session of an access token, however it was sent
+func (s *Server) sessionFromToken(token string) (Session, error) {
+	if isFieldEmpty(token) {
+		return Session{}, apiErrorf(http.StatusUnauthorized, unauthorizedCode, "no access token")
+	}
+
+	targetSession, err := s.parseAccessToken(token)
+	if err != nil {
+		return Session{}, apiErrorf(http.StatusUnauthorized, unauthorizedCode, "%v", err)
+	}
+
+	if err := s.checkSessionCurrent(targetSession); err != nil {
+		return Session{}, err
+	}
+	return targetSession, nil
+}
+
+//Fail once a session has expired or been revoked. Streams call it again while open, so logout and disabled accounts end them too.
+func (s *Server) checkSessionCurrent(targetSession Session) error {
+	if s.clock().After(targetSession.ExpireTime) {
+		return apiErrorf(http.StatusUnauthorized, unauthorizedCode, "access token expired")
+	}
+	if !s.isSessionActive(targetSession.Id) {
+		return apiErrorf(http.StatusUnauthorized, unauthorizedCode, "session %v revoked or missing", targetSession.Id)
+	}
+	return nil
+}
+
+//Exchange a refresh token for a new access token and a rotated refresh token
+func (s *Server) refreshSession(w http.ResponseWriter, r *http.Request) {
+	s.logger.Println("refreshSession()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	if !doKeysExist(r.Form, []string{"refreshToken"}) || areFieldsEmpty(r.Form, []string{"refreshToken"}) {
+		fmt.Fprint(w, wrongPasswordResponse)
+		return
+	}
+
+	parts := strings.Split(r.Form["refreshToken"][0], ".")
+	if len(parts) != 2 || !checkDatabaseHandleValid(s.db, s.logger) {
+		fmt.Fprint(w, wrongPasswordResponse)
+		return
+	}
+
+	var targetSession Session
+	var storedHash string
+	var refreshExpireTime time.Time
+	var revoked bool
+	if err := s.db.QueryRow(`SELECT SessionId, Role, DriverId, VanId, PhoneNumber, DeviceId, RefreshTokenHash, RefreshExpireTime, Revoked
+		FROM sessions
+		WHERE SessionId = $1;`, parts[0]).Scan(&targetSession.Id, &targetSession.Role, &targetSession.DriverId, &targetSession.VanId, &targetSession.PhoneNumber, &targetSession.DeviceId, &storedHash, &refreshExpireTime, &revoked); err != nil {
+		if err != sql.ErrNoRows {
+			s.logger.Println(err)
+		}
+		fmt.Fprint(w, wrongPasswordResponse)
+		return
+	}
+
+	presentedHash := sha256Hex(parts[1])
+	if subtle.ConstantTimeCompare([]byte(presentedHash), []byte(storedHash)) != 1 {
+		//an old refresh token was replayed, assume it was stolen and end the session
+		s.logger.Println("Refresh token reuse for session", targetSession.Id, "- revoking")
+		s.databaseRevokeSession(targetSession.Id)
+		fmt.Fprint(w, wrongPasswordResponse)
+		return
+	}
+
+	if revoked || s.clock().After(refreshExpireTime) {
+		fmt.Fprint(w, wrongPasswordResponse)
+		return
+	}
+
+	refreshSecret, err := randomHex(32)
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, failResponse)
+		return
+	}
+	if !s.databaseRotateSessionRefreshToken(targetSession.Id, storedHash, sha256Hex(refreshSecret), s.clock().Add(refreshTokenLifetime)) {
+		fmt.Fprint(w, wrongPasswordResponse)
+		return
+	}
+
+	targetSession.ExpireTime = s.clock().Add(accessTokenLifetime)
+	accessToken, err := s.signAccessToken(targetSession)
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	s.writeSessionTokens(w, sessionTokens{"0", accessToken, targetSession.Id + "." + refreshSecret, int(accessTokenLifetime.Seconds()), targetSession.Role, targetSession.DriverId, targetSession.VanId, targetSession.PhoneNumber})
+}
+
+//Revoke the caller's session on every instance
+func (s *Server) logout(w http.ResponseWriter, r *http.Request, targetSession Session) {
+	s.logger.Println("logout()")
+
+	if s.databaseRevokeSession(targetSession.Id) {
+		fmt.Fprint(w, successResponse)
+	} else {
+		fmt.Fprint(w, failResponse)
+	}
+}
@@ -1,40 +1,9 @@
-DROP TABLE IF EXISTS inprogress;
-CREATE TABLE inprogress (PhoneNumber CHAR(10) NOT NULL,
- DeviceId VARCHAR(36) NOT NULL,
- InitialLatitude REAL NOT NULL,
- InitialLongitude REAL NOT NULL,
- InitialTime TIMESTAMP NOT NULL,
- LatestLatitude REAL NOT NULL,
- LatestLongitude REAL NOT NULL,
- LatestTime TIMESTAMP NOT NULL,
- ConfirmTime TIMESTAMP NOT NULL,
- CompleteTime TIMESTAMP NOT NULL,
- Status INT NOT NULL,
- Version INT NOT NULL DEFAULT 0,
- CONSTRAINT inprogress_pkey PRIMARY KEY (PhoneNumber, DeviceId),
- CONSTRAINT Check_PhoneNumber CHECK (CHAR_LENGTH(PhoneNumber) = 10));
-
-DROP TABLE IF EXISTS pastpickups;
-CREATE TABLE pastpickups (PhoneNumber CHAR(10) NOT NULL,
- DeviceId VARCHAR(36) NOT NULL,
- InitialLatitude REAL NOT NULL,
- InitialLongitude REAL NOT NULL,
- InitialTime TIMESTAMP NOT NULL,
- LatestLatitude REAL NOT NULL,
- LatestLongitude REAL NOT NULL,
- LatestTime TIMESTAMP NOT NULL,
- ConfirmTime TIMESTAMP NOT NULL,
- CompleteTime TIMESTAMP NOT NULL,
- Status INT NOT NULL,
- Version INT NOT NULL DEFAULT 0,
- CONSTRAINT Check_PhoneNumber CHECK (CHAR_LENGTH(PhoneNumber) = 10));
-
-DROP TABLE IF EXISTS vanlocations;
-CREATE TABLE vanlocations (VanId INT NOT NULL PRIMARY KEY,
- LatestLatitude REAL NOT NULL,
- LatestLongitude REAL NOT NULL,
- LatestTime TIMESTAMP NOT NULL,
- Version INT NOT NULL DEFAULT 0);
+#Tables and triggers are created by the numbered migrations in migrations.go. Apply them with
+#  shipmate migrate
+#instead of creating tables by hand, so every deployment ends up with the same schema.
+
+#View applied migrations
+SELECT * FROM schema_migrations ORDER BY Version;
 
 #View public schema tables
 SELECT table_schema,table_name
@@ -98,29 +67,7 @@ DELETE FROM inprogress
 SELECT * from inprogress;
 
 
-#trigger for telling when a phone number row has been changed
-DROP TRIGGER inprogresschange ON inprogress;
-
-CREATE or REPLACE FUNCTION notifyPhoneNumber() RETURNS trigger AS $$
- BEGIN  
-  IF TG_OP='DELETE' THEN
-    EXECUTE FORMAT('NOTIFY notifyphonenumber, ''%s''', OLD.PhoneNumber); 
-  ELSE
-    EXECUTE FORMAT('NOTIFY notifyphonenumber, ''%s''', NEW.PhoneNumber); 
-  END IF;
-  RETURN NULL;
- END;  
-$$ LANGUAGE plpgsql;  
-
-CREATE TRIGGER inprogresschange AFTER INSERT OR UPDATE OR DELETE
- ON inprogress
- FOR EACH ROW 
- EXECUTE PROCEDURE notifyPhoneNumber();
-
-CREATE TRIGGER inprogressdelete AFTER DELETE
- ON inprogress
- FOR EACH ROW 
- EXECUTE PROCEDURE notifyPhoneNumber();
+#triggers announcing changed phone numbers and van locations: see migration 3 in migrations.go
 
 #find own pid
 SELECT * FROM pg_stat_activity WHERE pid = pg_backend_pid();
@@ -0,0 +1,1160 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: shipmate.proto
+
+// gRPC service for driver tablets and riders, served next to the HTTP routes.
+// Calls authenticate with the same access tokens as HTTP, sent as
+// "authorization: Bearer <accessToken>" metadata, and are allowed by the same roles.
+
+package shipmatepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Numbers are the status numbers of the HTTP API.
+type PickupStatus int32
+
+const (
+	PickupStatus_PICKUP_STATUS_INACTIVE  PickupStatus = 0
+	PickupStatus_PICKUP_STATUS_PENDING   PickupStatus = 1
+	PickupStatus_PICKUP_STATUS_CONFIRMED PickupStatus = 2
+	PickupStatus_PICKUP_STATUS_COMPLETED PickupStatus = 3
+	PickupStatus_PICKUP_STATUS_CANCELED  PickupStatus = 5
+	PickupStatus_PICKUP_STATUS_EN_ROUTE  PickupStatus = 6
+	PickupStatus_PICKUP_STATUS_ARRIVED   PickupStatus = 7
+	PickupStatus_PICKUP_STATUS_NO_SHOW   PickupStatus = 8
+	PickupStatus_PICKUP_STATUS_EXPIRED   PickupStatus = 9
+)
+
+// Enum value maps for PickupStatus.
+var (
+	PickupStatus_name = map[int32]string{
+		0: "PICKUP_STATUS_INACTIVE",
+		1: "PICKUP_STATUS_PENDING",
+		2: "PICKUP_STATUS_CONFIRMED",
+		3: "PICKUP_STATUS_COMPLETED",
+		5: "PICKUP_STATUS_CANCELED",
+		6: "PICKUP_STATUS_EN_ROUTE",
+		7: "PICKUP_STATUS_ARRIVED",
+		8: "PICKUP_STATUS_NO_SHOW",
+		9: "PICKUP_STATUS_EXPIRED",
+	}
+	PickupStatus_value = map[string]int32{
+		"PICKUP_STATUS_INACTIVE":  0,
+		"PICKUP_STATUS_PENDING":   1,
+		"PICKUP_STATUS_CONFIRMED": 2,
+		"PICKUP_STATUS_COMPLETED": 3,
+		"PICKUP_STATUS_CANCELED":  5,
+		"PICKUP_STATUS_EN_ROUTE":  6,
+		"PICKUP_STATUS_ARRIVED":   7,
+		"PICKUP_STATUS_NO_SHOW":   8,
+		"PICKUP_STATUS_EXPIRED":   9,
+	}
+)
+
+func (x PickupStatus) Enum() *PickupStatus {
+	p := new(PickupStatus)
+	*p = x
+	return p
+}
+
+func (x PickupStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (PickupStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_shipmate_proto_enumTypes[0].Descriptor()
+}
+
+func (PickupStatus) Type() protoreflect.EnumType {
+	return &file_shipmate_proto_enumTypes[0]
+}
+
+func (x PickupStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use PickupStatus.Descriptor instead.
+func (PickupStatus) EnumDescriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{0}
+}
+
+type PickupUpdate_Type int32
+
+const (
+	PickupUpdate_TYPE_UNSPECIFIED PickupUpdate_Type = 0
+	// The pickup is new to this stream, including every pickup sent when the stream opens.
+	PickupUpdate_TYPE_ADDED   PickupUpdate_Type = 1
+	PickupUpdate_TYPE_UPDATED PickupUpdate_Type = 2
+	// The pickup ended or moved to another van. Only phone_number is set.
+	PickupUpdate_TYPE_REMOVED PickupUpdate_Type = 3
+)
+
+// Enum value maps for PickupUpdate_Type.
+var (
+	PickupUpdate_Type_name = map[int32]string{
+		0: "TYPE_UNSPECIFIED",
+		1: "TYPE_ADDED",
+		2: "TYPE_UPDATED",
+		3: "TYPE_REMOVED",
+	}
+	PickupUpdate_Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED": 0,
+		"TYPE_ADDED":       1,
+		"TYPE_UPDATED":     2,
+		"TYPE_REMOVED":     3,
+	}
+)
+
+func (x PickupUpdate_Type) Enum() *PickupUpdate_Type {
+	p := new(PickupUpdate_Type)
+	*p = x
+	return p
+}
+
+func (x PickupUpdate_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (PickupUpdate_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_shipmate_proto_enumTypes[1].Descriptor()
+}
+
+func (PickupUpdate_Type) Type() protoreflect.EnumType {
+	return &file_shipmate_proto_enumTypes[1]
+}
+
+func (x PickupUpdate_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use PickupUpdate_Type.Descriptor instead.
+func (PickupUpdate_Type) EnumDescriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{10, 0}
+}
+
+type Location struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Latitude  float64                `protobuf:"fixed64,1,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude float64                `protobuf:"fixed64,2,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	// Degrees from north, -1 if unknown. Left out of requests that have no heading.
+	Heading       *float64 `protobuf:"fixed64,3,opt,name=heading,proto3,oneof" json:"heading,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Location) Reset() {
+	*x = Location{}
+	mi := &file_shipmate_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Location) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Location) ProtoMessage() {}
+
+func (x *Location) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Location.ProtoReflect.Descriptor instead.
+func (*Location) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Location) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *Location) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *Location) GetHeading() float64 {
+	if x != nil && x.Heading != nil {
+		return *x.Heading
+	}
+	return 0
+}
+
+// Times that have not happened yet are left unset.
+type Pickup struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	PhoneNumber     string                 `protobuf:"bytes,1,opt,name=phone_number,json=phoneNumber,proto3" json:"phone_number,omitempty"`
+	InitialLocation *Location              `protobuf:"bytes,2,opt,name=initial_location,json=initialLocation,proto3" json:"initial_location,omitempty"`
+	InitialTime     *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=initial_time,json=initialTime,proto3" json:"initial_time,omitempty"`
+	LatestLocation  *Location              `protobuf:"bytes,4,opt,name=latest_location,json=latestLocation,proto3" json:"latest_location,omitempty"`
+	LatestTime      *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=latest_time,json=latestTime,proto3" json:"latest_time,omitempty"`
+	ConfirmTime     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=confirm_time,json=confirmTime,proto3" json:"confirm_time,omitempty"`
+	CompleteTime    *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=complete_time,json=completeTime,proto3" json:"complete_time,omitempty"`
+	Status          PickupStatus           `protobuf:"varint,8,opt,name=status,proto3,enum=shipmate.v1.PickupStatus" json:"status,omitempty"`
+	ConfirmDriverId int32                  `protobuf:"varint,9,opt,name=confirm_driver_id,json=confirmDriverId,proto3" json:"confirm_driver_id,omitempty"`
+	// Driver that completed or canceled the pickup.
+	CompleteDriverId int32 `protobuf:"varint,10,opt,name=complete_driver_id,json=completeDriverId,proto3" json:"complete_driver_id,omitempty"`
+	// Who made the latest status change.
+	StatusActor string                 `protobuf:"bytes,11,opt,name=status_actor,json=statusActor,proto3" json:"status_actor,omitempty"`
+	StatusTime  *timestamppb.Timestamp `protobuf:"bytes,12,opt,name=status_time,json=statusTime,proto3" json:"status_time,omitempty"`
+	// Van assigned to the pickup, 0 if unassigned.
+	VanId int32 `protobuf:"varint,13,opt,name=van_id,json=vanId,proto3" json:"van_id,omitempty"`
+	// Driver of the assigned van, 0 if unknown.
+	DriverId int32 `protobuf:"varint,14,opt,name=driver_id,json=driverId,proto3" json:"driver_id,omitempty"`
+	// Van proposed by dispatch that has not claimed the pickup yet.
+	SuggestedVanId int32 `protobuf:"varint,15,opt,name=suggested_van_id,json=suggestedVanId,proto3" json:"suggested_van_id,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Pickup) Reset() {
+	*x = Pickup{}
+	mi := &file_shipmate_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Pickup) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pickup) ProtoMessage() {}
+
+func (x *Pickup) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pickup.ProtoReflect.Descriptor instead.
+func (*Pickup) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Pickup) GetPhoneNumber() string {
+	if x != nil {
+		return x.PhoneNumber
+	}
+	return ""
+}
+
+func (x *Pickup) GetInitialLocation() *Location {
+	if x != nil {
+		return x.InitialLocation
+	}
+	return nil
+}
+
+func (x *Pickup) GetInitialTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.InitialTime
+	}
+	return nil
+}
+
+func (x *Pickup) GetLatestLocation() *Location {
+	if x != nil {
+		return x.LatestLocation
+	}
+	return nil
+}
+
+func (x *Pickup) GetLatestTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LatestTime
+	}
+	return nil
+}
+
+func (x *Pickup) GetConfirmTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ConfirmTime
+	}
+	return nil
+}
+
+func (x *Pickup) GetCompleteTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CompleteTime
+	}
+	return nil
+}
+
+func (x *Pickup) GetStatus() PickupStatus {
+	if x != nil {
+		return x.Status
+	}
+	return PickupStatus_PICKUP_STATUS_INACTIVE
+}
+
+func (x *Pickup) GetConfirmDriverId() int32 {
+	if x != nil {
+		return x.ConfirmDriverId
+	}
+	return 0
+}
+
+func (x *Pickup) GetCompleteDriverId() int32 {
+	if x != nil {
+		return x.CompleteDriverId
+	}
+	return 0
+}
+
+func (x *Pickup) GetStatusActor() string {
+	if x != nil {
+		return x.StatusActor
+	}
+	return ""
+}
+
+func (x *Pickup) GetStatusTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StatusTime
+	}
+	return nil
+}
+
+func (x *Pickup) GetVanId() int32 {
+	if x != nil {
+		return x.VanId
+	}
+	return 0
+}
+
+func (x *Pickup) GetDriverId() int32 {
+	if x != nil {
+		return x.DriverId
+	}
+	return 0
+}
+
+func (x *Pickup) GetSuggestedVanId() int32 {
+	if x != nil {
+		return x.SuggestedVanId
+	}
+	return 0
+}
+
+type PickupEta struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	VanId int32                  `protobuf:"varint,1,opt,name=van_id,json=vanId,proto3" json:"van_id,omitempty"`
+	// Pickups the van serves first.
+	StopsAhead    int32                  `protobuf:"varint,2,opt,name=stops_ahead,json=stopsAhead,proto3" json:"stops_ahead,omitempty"`
+	DistanceKm    float64                `protobuf:"fixed64,3,opt,name=distance_km,json=distanceKm,proto3" json:"distance_km,omitempty"`
+	SpeedKph      float64                `protobuf:"fixed64,4,opt,name=speed_kph,json=speedKph,proto3" json:"speed_kph,omitempty"`
+	Minutes       float64                `protobuf:"fixed64,5,opt,name=minutes,proto3" json:"minutes,omitempty"`
+	ArrivalTime   *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=arrival_time,json=arrivalTime,proto3" json:"arrival_time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PickupEta) Reset() {
+	*x = PickupEta{}
+	mi := &file_shipmate_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PickupEta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PickupEta) ProtoMessage() {}
+
+func (x *PickupEta) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PickupEta.ProtoReflect.Descriptor instead.
+func (*PickupEta) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PickupEta) GetVanId() int32 {
+	if x != nil {
+		return x.VanId
+	}
+	return 0
+}
+
+func (x *PickupEta) GetStopsAhead() int32 {
+	if x != nil {
+		return x.StopsAhead
+	}
+	return 0
+}
+
+func (x *PickupEta) GetDistanceKm() float64 {
+	if x != nil {
+		return x.DistanceKm
+	}
+	return 0
+}
+
+func (x *PickupEta) GetSpeedKph() float64 {
+	if x != nil {
+		return x.SpeedKph
+	}
+	return 0
+}
+
+func (x *PickupEta) GetMinutes() float64 {
+	if x != nil {
+		return x.Minutes
+	}
+	return 0
+}
+
+func (x *PickupEta) GetArrivalTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ArrivalTime
+	}
+	return nil
+}
+
+type PickupInfo struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Pickup *Pickup                `protobuf:"bytes,1,opt,name=pickup,proto3" json:"pickup,omitempty"`
+	// Unset if the van has not reported recently.
+	VanLocation *Location `protobuf:"bytes,2,opt,name=van_location,json=vanLocation,proto3" json:"van_location,omitempty"`
+	// Unset if no van is on the way.
+	Eta *PickupEta `protobuf:"bytes,3,opt,name=eta,proto3" json:"eta,omitempty"`
+	// Position among pickups waiting for a van, 0 once a van has it.
+	QueuePosition int32 `protobuf:"varint,4,opt,name=queue_position,json=queuePosition,proto3" json:"queue_position,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PickupInfo) Reset() {
+	*x = PickupInfo{}
+	mi := &file_shipmate_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PickupInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PickupInfo) ProtoMessage() {}
+
+func (x *PickupInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PickupInfo.ProtoReflect.Descriptor instead.
+func (*PickupInfo) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PickupInfo) GetPickup() *Pickup {
+	if x != nil {
+		return x.Pickup
+	}
+	return nil
+}
+
+func (x *PickupInfo) GetVanLocation() *Location {
+	if x != nil {
+		return x.VanLocation
+	}
+	return nil
+}
+
+func (x *PickupInfo) GetEta() *PickupEta {
+	if x != nil {
+		return x.Eta
+	}
+	return nil
+}
+
+func (x *PickupInfo) GetQueuePosition() int32 {
+	if x != nil {
+		return x.QueuePosition
+	}
+	return 0
+}
+
+type CreatePickupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Location      *Location              `protobuf:"bytes,1,opt,name=location,proto3" json:"location,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreatePickupRequest) Reset() {
+	*x = CreatePickupRequest{}
+	mi := &file_shipmate_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreatePickupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreatePickupRequest) ProtoMessage() {}
+
+func (x *CreatePickupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreatePickupRequest.ProtoReflect.Descriptor instead.
+func (*CreatePickupRequest) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CreatePickupRequest) GetLocation() *Location {
+	if x != nil {
+		return x.Location
+	}
+	return nil
+}
+
+type GetPickupRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required for staff, riders always get their own pickup.
+	PhoneNumber   string `protobuf:"bytes,1,opt,name=phone_number,json=phoneNumber,proto3" json:"phone_number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPickupRequest) Reset() {
+	*x = GetPickupRequest{}
+	mi := &file_shipmate_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPickupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPickupRequest) ProtoMessage() {}
+
+func (x *GetPickupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPickupRequest.ProtoReflect.Descriptor instead.
+func (*GetPickupRequest) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetPickupRequest) GetPhoneNumber() string {
+	if x != nil {
+		return x.PhoneNumber
+	}
+	return ""
+}
+
+type CancelPickupRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required for staff, riders always cancel their own pickup.
+	PhoneNumber   string `protobuf:"bytes,1,opt,name=phone_number,json=phoneNumber,proto3" json:"phone_number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelPickupRequest) Reset() {
+	*x = CancelPickupRequest{}
+	mi := &file_shipmate_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelPickupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelPickupRequest) ProtoMessage() {}
+
+func (x *CancelPickupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelPickupRequest.ProtoReflect.Descriptor instead.
+func (*CancelPickupRequest) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CancelPickupRequest) GetPhoneNumber() string {
+	if x != nil {
+		return x.PhoneNumber
+	}
+	return ""
+}
+
+type ConfirmPickupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PhoneNumber   string                 `protobuf:"bytes,1,opt,name=phone_number,json=phoneNumber,proto3" json:"phone_number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfirmPickupRequest) Reset() {
+	*x = ConfirmPickupRequest{}
+	mi := &file_shipmate_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfirmPickupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfirmPickupRequest) ProtoMessage() {}
+
+func (x *ConfirmPickupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfirmPickupRequest.ProtoReflect.Descriptor instead.
+func (*ConfirmPickupRequest) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ConfirmPickupRequest) GetPhoneNumber() string {
+	if x != nil {
+		return x.PhoneNumber
+	}
+	return ""
+}
+
+type CompletePickupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PhoneNumber   string                 `protobuf:"bytes,1,opt,name=phone_number,json=phoneNumber,proto3" json:"phone_number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompletePickupRequest) Reset() {
+	*x = CompletePickupRequest{}
+	mi := &file_shipmate_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompletePickupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompletePickupRequest) ProtoMessage() {}
+
+func (x *CompletePickupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompletePickupRequest.ProtoReflect.Descriptor instead.
+func (*CompletePickupRequest) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CompletePickupRequest) GetPhoneNumber() string {
+	if x != nil {
+		return x.PhoneNumber
+	}
+	return ""
+}
+
+type StreamPickupsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// 0 for every pickup.
+	VanId         int32 `protobuf:"varint,1,opt,name=van_id,json=vanId,proto3" json:"van_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamPickupsRequest) Reset() {
+	*x = StreamPickupsRequest{}
+	mi := &file_shipmate_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamPickupsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamPickupsRequest) ProtoMessage() {}
+
+func (x *StreamPickupsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamPickupsRequest.ProtoReflect.Descriptor instead.
+func (*StreamPickupsRequest) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *StreamPickupsRequest) GetVanId() int32 {
+	if x != nil {
+		return x.VanId
+	}
+	return 0
+}
+
+type PickupUpdate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          PickupUpdate_Type      `protobuf:"varint,1,opt,name=type,proto3,enum=shipmate.v1.PickupUpdate_Type" json:"type,omitempty"`
+	PhoneNumber   string                 `protobuf:"bytes,2,opt,name=phone_number,json=phoneNumber,proto3" json:"phone_number,omitempty"`
+	Pickup        *Pickup                `protobuf:"bytes,3,opt,name=pickup,proto3" json:"pickup,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PickupUpdate) Reset() {
+	*x = PickupUpdate{}
+	mi := &file_shipmate_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PickupUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PickupUpdate) ProtoMessage() {}
+
+func (x *PickupUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PickupUpdate.ProtoReflect.Descriptor instead.
+func (*PickupUpdate) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *PickupUpdate) GetType() PickupUpdate_Type {
+	if x != nil {
+		return x.Type
+	}
+	return PickupUpdate_TYPE_UNSPECIFIED
+}
+
+func (x *PickupUpdate) GetPhoneNumber() string {
+	if x != nil {
+		return x.PhoneNumber
+	}
+	return ""
+}
+
+func (x *PickupUpdate) GetPickup() *Pickup {
+	if x != nil {
+		return x.Pickup
+	}
+	return nil
+}
+
+func (x *PickupUpdate) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+type VanLocation struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VanId         int32                  `protobuf:"varint,1,opt,name=van_id,json=vanId,proto3" json:"van_id,omitempty"`
+	Location      *Location              `protobuf:"bytes,2,opt,name=location,proto3" json:"location,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VanLocation) Reset() {
+	*x = VanLocation{}
+	mi := &file_shipmate_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VanLocation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VanLocation) ProtoMessage() {}
+
+func (x *VanLocation) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VanLocation.ProtoReflect.Descriptor instead.
+func (*VanLocation) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *VanLocation) GetVanId() int32 {
+	if x != nil {
+		return x.VanId
+	}
+	return 0
+}
+
+func (x *VanLocation) GetLocation() *Location {
+	if x != nil {
+		return x.Location
+	}
+	return nil
+}
+
+type ReportVanLocationsReply struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Fixes recorded.
+	Locations int32 `protobuf:"varint,1,opt,name=locations,proto3" json:"locations,omitempty"`
+	// Location of the van as kept in memory after the last fix.
+	LatestLocation *Location `protobuf:"bytes,2,opt,name=latest_location,json=latestLocation,proto3" json:"latest_location,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ReportVanLocationsReply) Reset() {
+	*x = ReportVanLocationsReply{}
+	mi := &file_shipmate_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReportVanLocationsReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportVanLocationsReply) ProtoMessage() {}
+
+func (x *ReportVanLocationsReply) ProtoReflect() protoreflect.Message {
+	mi := &file_shipmate_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportVanLocationsReply.ProtoReflect.Descriptor instead.
+func (*ReportVanLocationsReply) Descriptor() ([]byte, []int) {
+	return file_shipmate_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ReportVanLocationsReply) GetLocations() int32 {
+	if x != nil {
+		return x.Locations
+	}
+	return 0
+}
+
+func (x *ReportVanLocationsReply) GetLatestLocation() *Location {
+	if x != nil {
+		return x.LatestLocation
+	}
+	return nil
+}
+
+var File_shipmate_proto protoreflect.FileDescriptor
+
+const file_shipmate_proto_rawDesc = "" +
+	"\n" +
+	"\x0eshipmate.proto\x12\vshipmate.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"o\n" +
+	"\bLocation\x12\x1a\n" +
+	"\blatitude\x18\x01 \x01(\x01R\blatitude\x12\x1c\n" +
+	"\tlongitude\x18\x02 \x01(\x01R\tlongitude\x12\x1d\n" +
+	"\aheading\x18\x03 \x01(\x01H\x00R\aheading\x88\x01\x01B\n" +
+	"\n" +
+	"\b_heading\"\xf4\x05\n" +
+	"\x06Pickup\x12!\n" +
+	"\fphone_number\x18\x01 \x01(\tR\vphoneNumber\x12@\n" +
+	"\x10initial_location\x18\x02 \x01(\v2\x15.shipmate.v1.LocationR\x0finitialLocation\x12=\n" +
+	"\finitial_time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\vinitialTime\x12>\n" +
+	"\x0flatest_location\x18\x04 \x01(\v2\x15.shipmate.v1.LocationR\x0elatestLocation\x12;\n" +
+	"\vlatest_time\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"latestTime\x12=\n" +
+	"\fconfirm_time\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\vconfirmTime\x12?\n" +
+	"\rcomplete_time\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\fcompleteTime\x121\n" +
+	"\x06status\x18\b \x01(\x0e2\x19.shipmate.v1.PickupStatusR\x06status\x12*\n" +
+	"\x11confirm_driver_id\x18\t \x01(\x05R\x0fconfirmDriverId\x12,\n" +
+	"\x12complete_driver_id\x18\n" +
+	" \x01(\x05R\x10completeDriverId\x12!\n" +
+	"\fstatus_actor\x18\v \x01(\tR\vstatusActor\x12;\n" +
+	"\vstatus_time\x18\f \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"statusTime\x12\x15\n" +
+	"\x06van_id\x18\r \x01(\x05R\x05vanId\x12\x1b\n" +
+	"\tdriver_id\x18\x0e \x01(\x05R\bdriverId\x12(\n" +
+	"\x10suggested_van_id\x18\x0f \x01(\x05R\x0esuggestedVanId\"\xda\x01\n" +
+	"\tPickupEta\x12\x15\n" +
+	"\x06van_id\x18\x01 \x01(\x05R\x05vanId\x12\x1f\n" +
+	"\vstops_ahead\x18\x02 \x01(\x05R\n" +
+	"stopsAhead\x12\x1f\n" +
+	"\vdistance_km\x18\x03 \x01(\x01R\n" +
+	"distanceKm\x12\x1b\n" +
+	"\tspeed_kph\x18\x04 \x01(\x01R\bspeedKph\x12\x18\n" +
+	"\aminutes\x18\x05 \x01(\x01R\aminutes\x12=\n" +
+	"\farrival_time\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\varrivalTime\"\xc4\x01\n" +
+	"\n" +
+	"PickupInfo\x12+\n" +
+	"\x06pickup\x18\x01 \x01(\v2\x13.shipmate.v1.PickupR\x06pickup\x128\n" +
+	"\fvan_location\x18\x02 \x01(\v2\x15.shipmate.v1.LocationR\vvanLocation\x12(\n" +
+	"\x03eta\x18\x03 \x01(\v2\x16.shipmate.v1.PickupEtaR\x03eta\x12%\n" +
+	"\x0equeue_position\x18\x04 \x01(\x05R\rqueuePosition\"H\n" +
+	"\x13CreatePickupRequest\x121\n" +
+	"\blocation\x18\x01 \x01(\v2\x15.shipmate.v1.LocationR\blocation\"5\n" +
+	"\x10GetPickupRequest\x12!\n" +
+	"\fphone_number\x18\x01 \x01(\tR\vphoneNumber\"8\n" +
+	"\x13CancelPickupRequest\x12!\n" +
+	"\fphone_number\x18\x01 \x01(\tR\vphoneNumber\"9\n" +
+	"\x14ConfirmPickupRequest\x12!\n" +
+	"\fphone_number\x18\x01 \x01(\tR\vphoneNumber\":\n" +
+	"\x15CompletePickupRequest\x12!\n" +
+	"\fphone_number\x18\x01 \x01(\tR\vphoneNumber\"-\n" +
+	"\x14StreamPickupsRequest\x12\x15\n" +
+	"\x06van_id\x18\x01 \x01(\x05R\x05vanId\"\x94\x02\n" +
+	"\fPickupUpdate\x122\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x1e.shipmate.v1.PickupUpdate.TypeR\x04type\x12!\n" +
+	"\fphone_number\x18\x02 \x01(\tR\vphoneNumber\x12+\n" +
+	"\x06pickup\x18\x03 \x01(\v2\x13.shipmate.v1.PickupR\x06pickup\x12.\n" +
+	"\x04time\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\"P\n" +
+	"\x04Type\x12\x14\n" +
+	"\x10TYPE_UNSPECIFIED\x10\x00\x12\x0e\n" +
+	"\n" +
+	"TYPE_ADDED\x10\x01\x12\x10\n" +
+	"\fTYPE_UPDATED\x10\x02\x12\x10\n" +
+	"\fTYPE_REMOVED\x10\x03\"W\n" +
+	"\vVanLocation\x12\x15\n" +
+	"\x06van_id\x18\x01 \x01(\x05R\x05vanId\x121\n" +
+	"\blocation\x18\x02 \x01(\v2\x15.shipmate.v1.LocationR\blocation\"w\n" +
+	"\x17ReportVanLocationsReply\x12\x1c\n" +
+	"\tlocations\x18\x01 \x01(\x05R\tlocations\x12>\n" +
+	"\x0flatest_location\x18\x02 \x01(\v2\x15.shipmate.v1.LocationR\x0elatestLocation*\x88\x02\n" +
+	"\fPickupStatus\x12\x1a\n" +
+	"\x16PICKUP_STATUS_INACTIVE\x10\x00\x12\x19\n" +
+	"\x15PICKUP_STATUS_PENDING\x10\x01\x12\x1b\n" +
+	"\x17PICKUP_STATUS_CONFIRMED\x10\x02\x12\x1b\n" +
+	"\x17PICKUP_STATUS_COMPLETED\x10\x03\x12\x1a\n" +
+	"\x16PICKUP_STATUS_CANCELED\x10\x05\x12\x1a\n" +
+	"\x16PICKUP_STATUS_EN_ROUTE\x10\x06\x12\x19\n" +
+	"\x15PICKUP_STATUS_ARRIVED\x10\a\x12\x19\n" +
+	"\x15PICKUP_STATUS_NO_SHOW\x10\b\x12\x19\n" +
+	"\x15PICKUP_STATUS_EXPIRED\x10\t2\xa6\x04\n" +
+	"\bShipmate\x12I\n" +
+	"\fCreatePickup\x12 .shipmate.v1.CreatePickupRequest\x1a\x17.shipmate.v1.PickupInfo\x12C\n" +
+	"\tGetPickup\x12\x1d.shipmate.v1.GetPickupRequest\x1a\x17.shipmate.v1.PickupInfo\x12E\n" +
+	"\fCancelPickup\x12 .shipmate.v1.CancelPickupRequest\x1a\x13.shipmate.v1.Pickup\x12O\n" +
+	"\rStreamPickups\x12!.shipmate.v1.StreamPickupsRequest\x1a\x19.shipmate.v1.PickupUpdate0\x01\x12V\n" +
+	"\x12ReportVanLocations\x12\x18.shipmate.v1.VanLocation\x1a$.shipmate.v1.ReportVanLocationsReply(\x01\x12K\n" +
+	"\rConfirmPickup\x12!.shipmate.v1.ConfirmPickupRequest\x1a\x17.shipmate.v1.PickupInfo\x12M\n" +
+	"\x0eCompletePickup\x12\".shipmate.v1.CompletePickupRequest\x1a\x17.shipmate.v1.PickupInfoB'Z%github.com/ansonl/shipmate/shipmatepbb\x06proto3"
+
+var (
+	file_shipmate_proto_rawDescOnce sync.Once
+	file_shipmate_proto_rawDescData []byte
+)
+
+func file_shipmate_proto_rawDescGZIP() []byte {
+	file_shipmate_proto_rawDescOnce.Do(func() {
+		file_shipmate_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_shipmate_proto_rawDesc), len(file_shipmate_proto_rawDesc)))
+	})
+	return file_shipmate_proto_rawDescData
+}
+
+var file_shipmate_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_shipmate_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_shipmate_proto_goTypes = []any{
+	(PickupStatus)(0),               // 0: shipmate.v1.PickupStatus
+	(PickupUpdate_Type)(0),          // 1: shipmate.v1.PickupUpdate.Type
+	(*Location)(nil),                // 2: shipmate.v1.Location
+	(*Pickup)(nil),                  // 3: shipmate.v1.Pickup
+	(*PickupEta)(nil),               // 4: shipmate.v1.PickupEta
+	(*PickupInfo)(nil),              // 5: shipmate.v1.PickupInfo
+	(*CreatePickupRequest)(nil),     // 6: shipmate.v1.CreatePickupRequest
+	(*GetPickupRequest)(nil),        // 7: shipmate.v1.GetPickupRequest
+	(*CancelPickupRequest)(nil),     // 8: shipmate.v1.CancelPickupRequest
+	(*ConfirmPickupRequest)(nil),    // 9: shipmate.v1.ConfirmPickupRequest
+	(*CompletePickupRequest)(nil),   // 10: shipmate.v1.CompletePickupRequest
+	(*StreamPickupsRequest)(nil),    // 11: shipmate.v1.StreamPickupsRequest
+	(*PickupUpdate)(nil),            // 12: shipmate.v1.PickupUpdate
+	(*VanLocation)(nil),             // 13: shipmate.v1.VanLocation
+	(*ReportVanLocationsReply)(nil), // 14: shipmate.v1.ReportVanLocationsReply
+	(*timestamppb.Timestamp)(nil),   // 15: google.protobuf.Timestamp
+}
+var file_shipmate_proto_depIdxs = []int32{
+	2,  // 0: shipmate.v1.Pickup.initial_location:type_name -> shipmate.v1.Location
+	15, // 1: shipmate.v1.Pickup.initial_time:type_name -> google.protobuf.Timestamp
+	2,  // 2: shipmate.v1.Pickup.latest_location:type_name -> shipmate.v1.Location
+	15, // 3: shipmate.v1.Pickup.latest_time:type_name -> google.protobuf.Timestamp
+	15, // 4: shipmate.v1.Pickup.confirm_time:type_name -> google.protobuf.Timestamp
+	15, // 5: shipmate.v1.Pickup.complete_time:type_name -> google.protobuf.Timestamp
+	0,  // 6: shipmate.v1.Pickup.status:type_name -> shipmate.v1.PickupStatus
+	15, // 7: shipmate.v1.Pickup.status_time:type_name -> google.protobuf.Timestamp
+	15, // 8: shipmate.v1.PickupEta.arrival_time:type_name -> google.protobuf.Timestamp
+	3,  // 9: shipmate.v1.PickupInfo.pickup:type_name -> shipmate.v1.Pickup
+	2,  // 10: shipmate.v1.PickupInfo.van_location:type_name -> shipmate.v1.Location
+	4,  // 11: shipmate.v1.PickupInfo.eta:type_name -> shipmate.v1.PickupEta
+	2,  // 12: shipmate.v1.CreatePickupRequest.location:type_name -> shipmate.v1.Location
+	1,  // 13: shipmate.v1.PickupUpdate.type:type_name -> shipmate.v1.PickupUpdate.Type
+	3,  // 14: shipmate.v1.PickupUpdate.pickup:type_name -> shipmate.v1.Pickup
+	15, // 15: shipmate.v1.PickupUpdate.time:type_name -> google.protobuf.Timestamp
+	2,  // 16: shipmate.v1.VanLocation.location:type_name -> shipmate.v1.Location
+	2,  // 17: shipmate.v1.ReportVanLocationsReply.latest_location:type_name -> shipmate.v1.Location
+	6,  // 18: shipmate.v1.Shipmate.CreatePickup:input_type -> shipmate.v1.CreatePickupRequest
+	7,  // 19: shipmate.v1.Shipmate.GetPickup:input_type -> shipmate.v1.GetPickupRequest
+	8,  // 20: shipmate.v1.Shipmate.CancelPickup:input_type -> shipmate.v1.CancelPickupRequest
+	11, // 21: shipmate.v1.Shipmate.StreamPickups:input_type -> shipmate.v1.StreamPickupsRequest
+	13, // 22: shipmate.v1.Shipmate.ReportVanLocations:input_type -> shipmate.v1.VanLocation
+	9,  // 23: shipmate.v1.Shipmate.ConfirmPickup:input_type -> shipmate.v1.ConfirmPickupRequest
+	10, // 24: shipmate.v1.Shipmate.CompletePickup:input_type -> shipmate.v1.CompletePickupRequest
+	5,  // 25: shipmate.v1.Shipmate.CreatePickup:output_type -> shipmate.v1.PickupInfo
+	5,  // 26: shipmate.v1.Shipmate.GetPickup:output_type -> shipmate.v1.PickupInfo
+	3,  // 27: shipmate.v1.Shipmate.CancelPickup:output_type -> shipmate.v1.Pickup
+	12, // 28: shipmate.v1.Shipmate.StreamPickups:output_type -> shipmate.v1.PickupUpdate
+	14, // 29: shipmate.v1.Shipmate.ReportVanLocations:output_type -> shipmate.v1.ReportVanLocationsReply
+	5,  // 30: shipmate.v1.Shipmate.ConfirmPickup:output_type -> shipmate.v1.PickupInfo
+	5,  // 31: shipmate.v1.Shipmate.CompletePickup:output_type -> shipmate.v1.PickupInfo
+	25, // [25:32] is the sub-list for method output_type
+	18, // [18:25] is the sub-list for method input_type
+	18, // [18:18] is the sub-list for extension type_name
+	18, // [18:18] is the sub-list for extension extendee
+	0,  // [0:18] is the sub-list for field type_name
+}
+
+func init() { file_shipmate_proto_init() }
+func file_shipmate_proto_init() {
+	if File_shipmate_proto != nil {
+		return
+	}
+	file_shipmate_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_shipmate_proto_rawDesc), len(file_shipmate_proto_rawDesc)),
+			NumEnums:      2,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_shipmate_proto_goTypes,
+		DependencyIndexes: file_shipmate_proto_depIdxs,
+		EnumInfos:         file_shipmate_proto_enumTypes,
+		MessageInfos:      file_shipmate_proto_msgTypes,
+	}.Build()
+	File_shipmate_proto = out.File
+	file_shipmate_proto_goTypes = nil
+	file_shipmate_proto_depIdxs = nil
+}
@@ -0,0 +1,368 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: shipmate.proto
+
+// gRPC service for driver tablets and riders, served next to the HTTP routes.
+// Calls authenticate with the same access tokens as HTTP, sent as
+// "authorization: Bearer <accessToken>" metadata, and are allowed by the same roles.
+
+package shipmatepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Shipmate_CreatePickup_FullMethodName       = "/shipmate.v1.Shipmate/CreatePickup"
+	Shipmate_GetPickup_FullMethodName          = "/shipmate.v1.Shipmate/GetPickup"
+	Shipmate_CancelPickup_FullMethodName       = "/shipmate.v1.Shipmate/CancelPickup"
+	Shipmate_StreamPickups_FullMethodName      = "/shipmate.v1.Shipmate/StreamPickups"
+	Shipmate_ReportVanLocations_FullMethodName = "/shipmate.v1.Shipmate/ReportVanLocations"
+	Shipmate_ConfirmPickup_FullMethodName      = "/shipmate.v1.Shipmate/ConfirmPickup"
+	Shipmate_CompletePickup_FullMethodName     = "/shipmate.v1.Shipmate/CompletePickup"
+)
+
+// ShipmateClient is the client API for Shipmate service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ShipmateClient interface {
+	// Request a pickup for the rider's phone number.
+	CreatePickup(ctx context.Context, in *CreatePickupRequest, opts ...grpc.CallOption) (*PickupInfo, error)
+	// Pickup with its van, arrival estimate and queue position.
+	GetPickup(ctx context.Context, in *GetPickupRequest, opts ...grpc.CallOption) (*PickupInfo, error)
+	// Cancel a pickup. Replies with the pickup as it was moved to past pickups.
+	CancelPickup(ctx context.Context, in *CancelPickupRequest, opts ...grpc.CallOption) (*Pickup, error)
+	// Every current pickup, then a message for each change. Tablets pass their
+	// van id to only receive the pickups assigned or suggested to their van.
+	StreamPickups(ctx context.Context, in *StreamPickupsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PickupUpdate], error)
+	// GPS fixes of a van, one message per fix. The stream ends with an error at
+	// the first fix that is rejected.
+	ReportVanLocations(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[VanLocation, ReportVanLocationsReply], error)
+	// Confirm a pending pickup, claiming it for the driver's van.
+	ConfirmPickup(ctx context.Context, in *ConfirmPickupRequest, opts ...grpc.CallOption) (*PickupInfo, error)
+	// Complete a pickup.
+	CompletePickup(ctx context.Context, in *CompletePickupRequest, opts ...grpc.CallOption) (*PickupInfo, error)
+}
+
+type shipmateClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShipmateClient(cc grpc.ClientConnInterface) ShipmateClient {
+	return &shipmateClient{cc}
+}
+
+func (c *shipmateClient) CreatePickup(ctx context.Context, in *CreatePickupRequest, opts ...grpc.CallOption) (*PickupInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PickupInfo)
+	err := c.cc.Invoke(ctx, Shipmate_CreatePickup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shipmateClient) GetPickup(ctx context.Context, in *GetPickupRequest, opts ...grpc.CallOption) (*PickupInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PickupInfo)
+	err := c.cc.Invoke(ctx, Shipmate_GetPickup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shipmateClient) CancelPickup(ctx context.Context, in *CancelPickupRequest, opts ...grpc.CallOption) (*Pickup, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Pickup)
+	err := c.cc.Invoke(ctx, Shipmate_CancelPickup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shipmateClient) StreamPickups(ctx context.Context, in *StreamPickupsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PickupUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Shipmate_ServiceDesc.Streams[0], Shipmate_StreamPickups_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamPickupsRequest, PickupUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Shipmate_StreamPickupsClient = grpc.ServerStreamingClient[PickupUpdate]
+
+func (c *shipmateClient) ReportVanLocations(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[VanLocation, ReportVanLocationsReply], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Shipmate_ServiceDesc.Streams[1], Shipmate_ReportVanLocations_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[VanLocation, ReportVanLocationsReply]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Shipmate_ReportVanLocationsClient = grpc.ClientStreamingClient[VanLocation, ReportVanLocationsReply]
+
+func (c *shipmateClient) ConfirmPickup(ctx context.Context, in *ConfirmPickupRequest, opts ...grpc.CallOption) (*PickupInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PickupInfo)
+	err := c.cc.Invoke(ctx, Shipmate_ConfirmPickup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shipmateClient) CompletePickup(ctx context.Context, in *CompletePickupRequest, opts ...grpc.CallOption) (*PickupInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PickupInfo)
+	err := c.cc.Invoke(ctx, Shipmate_CompletePickup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ShipmateServer is the server API for Shipmate service.
+// All implementations must embed UnimplementedShipmateServer
+// for forward compatibility.
+type ShipmateServer interface {
+	// Request a pickup for the rider's phone number.
+	CreatePickup(context.Context, *CreatePickupRequest) (*PickupInfo, error)
+	// Pickup with its van, arrival estimate and queue position.
+	GetPickup(context.Context, *GetPickupRequest) (*PickupInfo, error)
+	// Cancel a pickup. Replies with the pickup as it was moved to past pickups.
+	CancelPickup(context.Context, *CancelPickupRequest) (*Pickup, error)
+	// Every current pickup, then a message for each change. Tablets pass their
+	// van id to only receive the pickups assigned or suggested to their van.
+	StreamPickups(*StreamPickupsRequest, grpc.ServerStreamingServer[PickupUpdate]) error
+	// GPS fixes of a van, one message per fix. The stream ends with an error at
+	// the first fix that is rejected.
+	ReportVanLocations(grpc.ClientStreamingServer[VanLocation, ReportVanLocationsReply]) error
+	// Confirm a pending pickup, claiming it for the driver's van.
+	ConfirmPickup(context.Context, *ConfirmPickupRequest) (*PickupInfo, error)
+	// Complete a pickup.
+	CompletePickup(context.Context, *CompletePickupRequest) (*PickupInfo, error)
+	mustEmbedUnimplementedShipmateServer()
+}
+
+// UnimplementedShipmateServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedShipmateServer struct{}
+
+func (UnimplementedShipmateServer) CreatePickup(context.Context, *CreatePickupRequest) (*PickupInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreatePickup not implemented")
+}
+func (UnimplementedShipmateServer) GetPickup(context.Context, *GetPickupRequest) (*PickupInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPickup not implemented")
+}
+func (UnimplementedShipmateServer) CancelPickup(context.Context, *CancelPickupRequest) (*Pickup, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelPickup not implemented")
+}
+func (UnimplementedShipmateServer) StreamPickups(*StreamPickupsRequest, grpc.ServerStreamingServer[PickupUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamPickups not implemented")
+}
+func (UnimplementedShipmateServer) ReportVanLocations(grpc.ClientStreamingServer[VanLocation, ReportVanLocationsReply]) error {
+	return status.Errorf(codes.Unimplemented, "method ReportVanLocations not implemented")
+}
+func (UnimplementedShipmateServer) ConfirmPickup(context.Context, *ConfirmPickupRequest) (*PickupInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmPickup not implemented")
+}
+func (UnimplementedShipmateServer) CompletePickup(context.Context, *CompletePickupRequest) (*PickupInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompletePickup not implemented")
+}
+func (UnimplementedShipmateServer) mustEmbedUnimplementedShipmateServer() {}
+func (UnimplementedShipmateServer) testEmbeddedByValue()                  {}
+
+// UnsafeShipmateServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShipmateServer will
+// result in compilation errors.
+type UnsafeShipmateServer interface {
+	mustEmbedUnimplementedShipmateServer()
+}
+
+func RegisterShipmateServer(s grpc.ServiceRegistrar, srv ShipmateServer) {
+	// If the following call pancis, it indicates UnimplementedShipmateServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Shipmate_ServiceDesc, srv)
+}
+
+func _Shipmate_CreatePickup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePickupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShipmateServer).CreatePickup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shipmate_CreatePickup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShipmateServer).CreatePickup(ctx, req.(*CreatePickupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shipmate_GetPickup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPickupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShipmateServer).GetPickup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shipmate_GetPickup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShipmateServer).GetPickup(ctx, req.(*GetPickupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shipmate_CancelPickup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelPickupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShipmateServer).CancelPickup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shipmate_CancelPickup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShipmateServer).CancelPickup(ctx, req.(*CancelPickupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shipmate_StreamPickups_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamPickupsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ShipmateServer).StreamPickups(m, &grpc.GenericServerStream[StreamPickupsRequest, PickupUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Shipmate_StreamPickupsServer = grpc.ServerStreamingServer[PickupUpdate]
+
+func _Shipmate_ReportVanLocations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ShipmateServer).ReportVanLocations(&grpc.GenericServerStream[VanLocation, ReportVanLocationsReply]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Shipmate_ReportVanLocationsServer = grpc.ClientStreamingServer[VanLocation, ReportVanLocationsReply]
+
+func _Shipmate_ConfirmPickup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmPickupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShipmateServer).ConfirmPickup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shipmate_ConfirmPickup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShipmateServer).ConfirmPickup(ctx, req.(*ConfirmPickupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shipmate_CompletePickup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompletePickupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShipmateServer).CompletePickup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shipmate_CompletePickup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShipmateServer).CompletePickup(ctx, req.(*CompletePickupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Shipmate_ServiceDesc is the grpc.ServiceDesc for Shipmate service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Shipmate_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "shipmate.v1.Shipmate",
+	HandlerType: (*ShipmateServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreatePickup",
+			Handler:    _Shipmate_CreatePickup_Handler,
+		},
+		{
+			MethodName: "GetPickup",
+			Handler:    _Shipmate_GetPickup_Handler,
+		},
+		{
+			MethodName: "CancelPickup",
+			Handler:    _Shipmate_CancelPickup_Handler,
+		},
+		{
+			MethodName: "ConfirmPickup",
+			Handler:    _Shipmate_ConfirmPickup_Handler,
+		},
+		{
+			MethodName: "CompletePickup",
+			Handler:    _Shipmate_CompletePickup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamPickups",
+			Handler:       _Shipmate_StreamPickups_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReportVanLocations",
+			Handler:       _Shipmate_ReportVanLocations_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "shipmate.proto",
+}
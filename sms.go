@@ -0,0 +1,98 @@
+package shipmate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Delivers text messages to rider phones
+type SMSSender interface {
+	SendSMS(phoneNumber string, message string) error
+}
+
+//Writes messages to the log instead of sending them. Used in development when no provider is configured.
+type logSMSSender struct {
+	logger *log.Logger
+}
+
+func (s logSMSSender) SendSMS(phoneNumber string, message string) error {
+	s.logger.Printf("SMS to %v: %v\n", phoneNumber, message)
+	return nil
+}
+
+//Appends messages to a file so tests and local tooling can read the codes that were sent
+type fileSMSSender struct {
+	path string
+	lock sync.Mutex
+}
+
+func (s *fileSMSSender) SendSMS(phoneNumber string, message string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%v\t%v\t%v\n", time.Now().Format(time.RFC3339), phoneNumber, message)
+	return err
+}
+
+//Sends messages through the Twilio REST API
+type twilioSMSSender struct {
+	accountSid string
+	authToken  string
+	fromNumber string
+	client     *http.Client
+}
+
+func (s twilioSMSSender) SendSMS(phoneNumber string, message string) error {
+	form := url.Values{}
+	form.Set("To", "+1"+phoneNumber)
+	form.Set("From", s.fromNumber)
+	form.Set("Body", message)
+
+	request, err := http.NewRequest("POST", "https://api.twilio.com/2010-04-01/Accounts/"+s.accountSid+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth(s.accountSid, s.authToken)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New("twilio returned " + response.Status)
+	}
+	return nil
+}
+
+//Choose SMS sender from SHIPMATE_SMS_SENDER: "twilio", "file:<path>", or "log" (default)
+func smsSenderFromEnvironment(logger *log.Logger) SMSSender {
+	setting := os.Getenv("SHIPMATE_SMS_SENDER")
+
+	switch {
+	case setting == "twilio":
+		logger.Println("Sending SMS through Twilio.")
+		return twilioSMSSender{os.Getenv("TWILIO_ACCOUNT_SID"), os.Getenv("TWILIO_AUTH_TOKEN"), os.Getenv("TWILIO_FROM_NUMBER"), &http.Client{Timeout: time.Duration(10) * time.Second}}
+	case strings.HasPrefix(setting, "file:"):
+		logger.Println("Writing SMS to", strings.TrimPrefix(setting, "file:"))
+		return &fileSMSSender{path: strings.TrimPrefix(setting, "file:")}
+	default:
+		logger.Println("Writing SMS to log. Set SHIPMATE_SMS_SENDER to deliver verification codes.")
+		return logSMSSender{logger}
+	}
+}
@@ -0,0 +1,257 @@
+package shipmate
+
+import (
+	"errors"
+	"time"
+)
+
+//Returned by store writes when the pickup changed since it was read: its version moved on, it was deleted, or a pickup with the same key already exists
+var errStalePickup = errors.New("pickup changed in the store since it was read")
+
+//Returned by van registry writes when the van id is already taken, or when no van has the id
+var errVanExists = errors.New("a van is already registered with this id")
+var errNoSuchVan = errors.New("no van is registered with this id")
+
+//Pickup writes made by synchronous requests and queued by async requests
+const insertPickupWrite string = "insert"
+const statusPickupWrite string = "status" //finished pickups are also copied to past pickups
+const cancelPickupWrite string = "cancel" //moves the pickup to past pickups
+
+//Finished pickups stay in the store this long so devices get the final status
+const pickupRetireDelay = time.Duration(1) * time.Minute
+
+//Where pickups are kept. Every instance also keeps the active pickups in memory, the store is what they agree on.
+type PickupStore interface {
+	//Add a new pickup. Returns errStalePickup if the same pickup already exists.
+	CreatePickup(targetPickup Pickup) error
+
+	//Write status, status times, drivers, van and retire time if the pickup still has the version it was read with, and bump the version. Finished pickups are copied to past pickups in the same write.
+	UpdatePickup(targetPickup Pickup) error
+
+	//Write the rider's latest location without bumping the version, so location reports never conflict with status changes
+	UpdatePickupLocation(targetPickup Pickup) error
+
+	//Move a canceled pickup to past pickups in one write
+	ArchivePickup(targetPickup Pickup) error
+
+	//Delete finished pickups whose retire time has passed. Returns their phone numbers.
+	RetireFinishedPickups(now time.Time) ([]string, error)
+
+	//Latest pickup for a phone number, false if there is none
+	GetPickup(phoneNumber string) (Pickup, bool, error)
+
+	ListPickups() ([]Pickup, error)
+
+	//Accept a write for later. Returns the request id to look up its outcome with.
+	QueuePickupWrite(operation string, targetPickup Pickup, actor string) (string, error)
+
+	GetQueuedWrite(requestId string) (queuedWrite, bool, error)
+
+	//Delete queued writes that were applied or given up on before the given time. Returns how many were deleted.
+	DeleteFinishedWrites(before time.Time) (int, error)
+
+	//Call finished with every write queued through this store once it is applied or given up on
+	WatchQueuedWrites(finished func(targetWrite queuedWrite, targetPickup Pickup)) error
+
+	//Call changed with the phone number of every pickup written, and local set if the write was made through this store. An empty phone number means changes may have been missed.
+	WatchPickups(changed func(phoneNumber string, local bool)) error
+
+	//Stop watching and stop background work started by the store
+	Close() error
+}
+
+//Where registered vans and the latest location of each van are kept
+type VanStore interface {
+	//Registered vans, lowest id first
+	ListVans() ([]Van, error)
+
+	//Register a van. Returns errVanExists if a van already has its id.
+	CreateVan(targetVan Van) error
+
+	//Replace a registered van's details. Returns errNoSuchVan if no van has its id.
+	UpdateVan(targetVan Van) error
+
+	//Write a van's location, heading and reporting driver
+	UpdateVanLocation(vanId int, vanLocation Location) error
+
+	ListVanLocations() (map[int]Location, error)
+
+	GetVanLocation(vanId int) (Location, bool, error)
+
+	//Call changed with the id of every van written, and local set if the write was made through this store. Van id 0 means changes may have been missed.
+	WatchVanLocations(changed func(vanId int, local bool)) error
+}
+
+//Where each request's session and rider device are checked
+type SessionStore interface {
+	//False if the session was revoked on any instance or does not exist
+	IsSessionActive(sessionId string) (bool, error)
+
+	//True once the phone number has been verified on the device
+	IsPhoneNumberVerified(phoneNumber string, deviceId string) (bool, error)
+}
+
+//Load pickups, vans and van locations into memory and follow changes made by any instance
+func (s *Server) setupStores() {
+	s.loadVans()
+	s.reloadPickups()
+	s.reloadVanLocations()
+
+	if err := s.pickupStore.WatchPickups(s.pickupStoreChanged); err != nil {
+		s.logger.Println(err)
+	}
+	if err := s.vanStore.WatchVanLocations(s.vanStoreChanged); err != nil {
+		s.logger.Println(err)
+	}
+	if err := s.pickupStore.WatchQueuedWrites(s.queuedWriteFinished); err != nil {
+		s.logger.Println(err)
+	}
+}
+
+//Apply a pickup write to a store
+func writePickupTo(targetStore PickupStore, operation string, targetPickup Pickup) error {
+	switch operation {
+	case insertPickupWrite:
+		return targetStore.CreatePickup(targetPickup)
+	case statusPickupWrite:
+		return targetStore.UpdatePickup(targetPickup)
+	case cancelPickupWrite:
+		return targetStore.ArchivePickup(targetPickup)
+	}
+	return errors.New("unknown pickup write " + operation)
+}
+
+//Replace a pickup in memory with the store's copy, after a write to it failed or another instance changed it. Pickups no longer in the store are set to inactive. Caller must hold pickupsLock.
+func (s *Server) loadPickupIntoMemory(targetPhoneNumber string) {
+	tmp, exist, err := s.pickupStore.GetPickup(targetPhoneNumber)
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+
+	if exist {
+		s.pickups[targetPhoneNumber] = tmp
+	} else if _, exist := s.pickups[targetPhoneNumber]; exist {
+		s.logger.Println("Pickup", targetPhoneNumber, "no longer in store. Set to inactive in memory.")
+		setPickupToInactiveInMemory(&s.pickups, targetPhoneNumber)
+	}
+}
+
+//Load a pickup from the store and push it to rider streams and the pickup board
+func (s *Server) reloadPickup(targetPhoneNumber string) {
+	s.pickupsLock.Lock()
+	s.loadPickupIntoMemory(targetPhoneNumber)
+	s.pickupsLock.Unlock()
+
+	s.pickupChanged(targetPhoneNumber)
+}
+
+//Replace pickups in memory with the store. Pickups no longer in the store are set to inactive.
+func (s *Server) reloadPickups() {
+	list, err := s.pickupStore.ListPickups()
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+
+	reloaded := make(map[string]Pickup)
+	for _, v := range list {
+		reloaded[v.PhoneNumber] = v
+	}
+	s.logger.Printf("Finished loading %v pickups.\n", len(reloaded))
+
+	s.pickupsLock.Lock()
+	var changed []string
+	for k := range s.pickups {
+		if _, exist := reloaded[k]; !exist {
+			setPickupToInactiveInMemory(&s.pickups, k)
+			changed = append(changed, k)
+		}
+	}
+	for k, v := range reloaded {
+		s.pickups[k] = v
+		changed = append(changed, k)
+	}
+	s.pickupsLock.Unlock()
+
+	for _, v := range changed {
+		s.pickupChanged(v)
+	}
+}
+
+//Replace van locations in memory with the store
+func (s *Server) reloadVanLocations() {
+	list, err := s.vanStore.ListVanLocations()
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+	s.logger.Printf("Finished loading %v van locations.\n", len(list))
+
+	s.vanLocations.replace(list)
+	for k := range list {
+		s.vanChanged(k)
+	}
+	s.removeInactiveVanLocations(s.configMinutes("vanTimeoutMinutes"))
+}
+
+//Delete finished pickups from the store once their retire time has passed, and set them to inactive in memory
+func (s *Server) retireFinishedPickups() {
+	retired, err := s.pickupStore.RetireFinishedPickups(s.clock())
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+	for _, v := range retired {
+		s.reloadPickup(v)
+	}
+}
+
+//Delete queued writes finished longer than outboxRetention ago so the outbox does not grow without limit
+func (s *Server) deleteFinishedWrites() {
+	deleted, err := s.pickupStore.DeleteFinishedWrites(s.clock().Add(-outboxRetention))
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+	if deleted > 0 {
+		s.logger.Printf("Deleted %v finished queued writes.\n", deleted)
+	}
+}
+
+//Follow pickups written by any instance
+func (s *Server) pickupStoreChanged(targetPhoneNumber string, local bool) {
+	if targetPhoneNumber == "" {
+		s.reloadPickups()
+		return
+	}
+
+	//Pickups written by this instance are already in memory
+	if !local {
+		s.pickupsLock.Lock()
+		s.loadPickupIntoMemory(targetPhoneNumber)
+		s.pickupsLock.Unlock()
+	}
+
+	//Push the change to rider streams and the pickup board, whichever instance made it
+	s.pickupChanged(targetPhoneNumber)
+}
+
+//Follow van locations reported to other instances. Vans reporting to this instance are already in memory.
+func (s *Server) vanStoreChanged(vanId int, local bool) {
+	if vanId == 0 {
+		s.reloadVanLocations()
+		return
+	}
+	if local {
+		return
+	}
+
+	tmp, exist, err := s.vanStore.GetVanLocation(vanId)
+	if err != nil {
+		s.logger.Println(err)
+	} else if exist {
+		s.vanLocations.set(vanId, tmp)
+		s.vanChanged(vanId)
+	}
+}
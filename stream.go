@@ -0,0 +1,130 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//Comment line sent to idle streams so routers do not close the connection
+const streamKeepAliveInterval = time.Duration(15) * time.Second
+
+func (s *Server) subscribePickup(number string) chan bool {
+	changed := make(chan bool, 1)
+
+	s.pickupSubscribersLock.Lock()
+	s.pickupSubscribers[changed] = number
+	s.pickupSubscribersLock.Unlock()
+	return changed
+}
+
+func (s *Server) unsubscribePickup(changed chan bool) {
+	s.pickupSubscribersLock.Lock()
+	delete(s.pickupSubscribers, changed)
+	s.pickupSubscribersLock.Unlock()
+}
+
+//Wake streams showing a phone number, or every stream if number is empty because a van moved. Never blocks.
+func (s *Server) notifyPickupSubscribers(number string) {
+	s.pickupSubscribersLock.Lock()
+	defer s.pickupSubscribersLock.Unlock()
+
+	for changed, subscribedNumber := range s.pickupSubscribers {
+		if number == "" || number == subscribedNumber {
+			select {
+			case changed <- true:
+			default:
+			}
+		}
+	}
+}
+
+//JSON sent to a stream for a phone number. A pickup that does not exist is sent with status 0. Returns false if a rider's session is not for the device that requested the pickup.
+func (s *Server) pickupStreamData(number string, session Session, viewingOwnPickup bool) (string, bool) {
+	s.pickupsLock.RLock()
+	defer s.pickupsLock.RUnlock()
+
+	tmp, exist := s.pickups[number]
+	if !exist {
+		tmp = Pickup{PhoneNumber: number}
+	} else if viewingOwnPickup && session.DeviceId != tmp.devicePhrase && tmp.devicePhrase != "" {
+		return "", false
+	}
+
+	output, err := json.Marshal(s.pickupInfoFor(tmp))
+	if err != nil {
+		s.logger.Println(err)
+	}
+	return string(output), true
+}
+
+//Server-Sent Events stream of getPickupInfo. A "pickup" event is sent when the stream opens and whenever the pickup, its van or its arrival estimate changes on any instance.
+func (s *Server) streamPickupInfo(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("streamPickupInfo()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.logger.Println("Streaming not supported by response writer")
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	var number string
+
+	//staff may view any pickup, riders only the pickup for their own phone number
+	viewingOwnPickup := !hasPermission(session.Role, viewAnyPickupPermission)
+	if viewingOwnPickup {
+		number = session.PhoneNumber
+	} else {
+		var v validator
+		number = v.formPhoneNumber(r.Form)
+		if err := v.err(); err != nil {
+			s.logger.Println(err)
+			fmt.Fprint(w, legacyResponse(err))
+			return
+		}
+	}
+
+	changed := s.subscribePickup(number)
+	defer s.unsubscribePickup(changed)
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 5000\n\n")
+
+	var lastSent string
+	for {
+		data, allowed := s.pickupStreamData(number, session, viewingOwnPickup)
+		if !allowed {
+			fmt.Fprintf(w, "event: denied\ndata: %v\n\n", wrongPasswordResponse)
+			flusher.Flush()
+			return
+		}
+
+		//ETA is recalculated on every wake up, only send it when something visible changed
+		if data != lastSent {
+			fmt.Fprintf(w, "event: pickup\ndata: %v\n\n", data)
+			lastSent = data
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package shipmate
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRemoveInactivePickups(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   PickupStatus
+		idle     time.Duration //time since the rider last reported
+		device   string
+		released bool         //device phrase expected to be cleared
+		want     PickupStatus //status expected afterwards
+	}{
+		{"pending past timeout", pending, 6 * time.Minute, testDevice, true, expired},
+		{"confirmed past timeout", confirmed, 6 * time.Minute, testDevice, true, expired},
+		{"en route past timeout", enRoute, 6 * time.Minute, testDevice, true, enRoute},
+		{"pending within timeout", pending, 4 * time.Minute, testDevice, false, pending},
+		{"completed past timeout", completed, time.Hour, testDevice, false, completed},
+		{"already released", pending, time.Hour, "", false, pending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			now := ts.clock.Now()
+
+			tmp := Pickup{PhoneNumber: testRider, devicePhrase: tt.device, Status: tt.status, InitialTime: now.Add(-tt.idle), LatestTime: now.Add(-tt.idle)}
+			if err := ts.store.CreatePickup(tmp); err != nil {
+				t.Fatal(err)
+			}
+			ts.server.pickupsLock.Lock()
+			ts.server.pickups[testRider] = tmp
+			ts.server.pickupsLock.Unlock()
+
+			ts.server.removeInactivePickups(ts.server.configMinutes("pickupTimeoutMinutes"))
+
+			current, _ := ts.memoryPickup(testRider)
+			if tt.released && current.devicePhrase != "" {
+				t.Errorf("device phrase %v kept after %v idle", current.devicePhrase, tt.idle)
+			} else if !tt.released && current.devicePhrase != tt.device {
+				t.Errorf("device phrase changed from %q to %q", tt.device, current.devicePhrase)
+			}
+			if current.Status != tt.want {
+				t.Errorf("status changed from %v to %v, want %v", tt.status, current.Status, tt.want)
+			}
+			if stored, _ := ts.storedPickup(testRider); stored.Status != tt.want {
+				t.Errorf("store has status %v, want %v", stored.Status, tt.want)
+			}
+		})
+	}
+}
+
+//Expired pickups are retired by the next retire sweep after the retire delay
+func TestExpiredPickupsAreRetired(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+
+	ts.clock.Advance(ts.server.configMinutes("pickupTimeoutMinutes") + time.Second)
+	ts.server.removeInactivePickups(ts.server.configMinutes("pickupTimeoutMinutes"))
+	if current, _ := ts.memoryPickup(testRider); current.Status != expired || current.StatusActor != systemActor {
+		t.Fatalf("got %v by %q in memory, want expired by the system", current.Status, current.StatusActor)
+	}
+
+	ts.clock.Advance(pickupRetireDelay)
+	ts.server.retireFinishedPickups()
+	if _, exist := ts.storedPickup(testRider); exist {
+		t.Error("expired pickup still in the store after the retire delay")
+	}
+	past := ts.store.ListPastPickups()
+	if len(past) != 1 || past[0].Status != expired {
+		t.Errorf("got past pickups %+v, want the expired pickup", past)
+	}
+}
+
+//Queued writes are deleted once they have been finished for longer than outboxRetention. Queued writes are kept however old they are.
+func TestDeleteFinishedWrites(t *testing.T) {
+	ts := newTestServer(t)
+	writes := []struct {
+		write    queuedWrite
+		wantKept bool
+	}{
+		{queuedWrite{RequestId: "committed-old", State: outboxCommitted, ProcessedTime: testStartTime.Add(-outboxRetention - time.Minute)}, false},
+		{queuedWrite{RequestId: "failed-old", State: outboxFailed, ProcessedTime: testStartTime.Add(-outboxRetention - time.Minute)}, false},
+		{queuedWrite{RequestId: "rolledback-recent", State: outboxRolledBack, ProcessedTime: testStartTime.Add(-time.Hour)}, true},
+		{queuedWrite{RequestId: "queued-old", State: outboxQueued, CreatedTime: testStartTime.Add(-2 * outboxRetention)}, true},
+	}
+	ts.store.lock.Lock()
+	for _, v := range writes {
+		ts.store.queuedWrites[v.write.RequestId] = v.write
+	}
+	ts.store.lock.Unlock()
+
+	ts.server.deleteFinishedWrites()
+
+	for _, v := range writes {
+		if _, exist, _ := ts.store.GetQueuedWrite(v.write.RequestId); exist != v.wantKept {
+			t.Errorf("write %v kept %v, want %v", v.write.RequestId, exist, v.wantKept)
+		}
+	}
+}
+
+//A rider who stops reporting frees their phone number for another device once the timeout passes
+func TestRemoveInactivePickupsFreesPhoneNumber(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+
+	otherDevice := ts.riderToken(testRider, testOtherDevice)
+	parameters := url.Values{"latitude": {"38.98"}, "longitude": {"-76.48"}}
+	if body := ts.request(otherDevice, "/newPickup", parameters); body != failResponse {
+		t.Fatalf("got %v while the first device is active, want %v", body, failResponse)
+	}
+
+	ts.clock.Advance(ts.server.configMinutes("pickupTimeoutMinutes") + time.Second)
+	ts.server.removeInactivePickups(ts.server.configMinutes("pickupTimeoutMinutes"))
+
+	if tmp, ok := decodePickup(ts.request(otherDevice, "/newPickup", parameters)); !ok || tmp.Status != pending {
+		t.Errorf("got %+v after the timeout, want a new pending pickup", tmp)
+	}
+	if current, _ := ts.memoryPickup(testRider); current.devicePhrase != testOtherDevice {
+		t.Errorf("pickup in memory is for device %q, want %q", current.devicePhrase, testOtherDevice)
+	}
+}
+
+func TestRemoveInactiveVanLocations(t *testing.T) {
+	tests := []struct {
+		name       string
+		idle       []time.Duration //time since each van last reported, -1 for vans that never reported
+		wantStale  []int
+		wantLength int //length of vanLocations afterwards
+	}{
+		{"all vans reporting", []time.Duration{time.Minute, 9 * time.Minute}, nil, 2},
+		{"one van stopped", []time.Duration{time.Minute, 11 * time.Minute}, []int{2}, 2},
+		{"every van stopped", []time.Duration{11 * time.Minute, time.Hour}, []int{1, 2}, 0},
+		{"stopped van next to one that never reported", []time.Duration{-1, 11 * time.Minute}, []int{2}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			now := ts.clock.Now()
+
+			for i, v := range tt.idle {
+				tmp := Location{Latitude: 38.98, Longitude: -76.48}
+				if v >= 0 {
+					tmp.latestTime = now.Add(-v)
+				}
+				ts.server.vanLocations.set(i+1, tmp)
+			}
+
+			stale := ts.server.removeInactiveVanLocations(ts.server.configMinutes("vanTimeoutMinutes"))
+			if len(stale) != len(tt.wantStale) {
+				t.Fatalf("got stale vans %v, want %v", stale, tt.wantStale)
+			}
+			for i := range stale {
+				if stale[i] != tt.wantStale[i] {
+					t.Fatalf("got stale vans %v, want %v", stale, tt.wantStale)
+				}
+			}
+			if got := ts.server.vanLocations.count(); got != tt.wantLength {
+				t.Errorf("got %v van locations, want %v", got, tt.wantLength)
+			}
+			for _, v := range stale {
+				if tmp, exist := ts.server.vanLocations.get(v); exist {
+					t.Errorf("stale van %v still has location %+v", v, tmp)
+				}
+			}
+		})
+	}
+}
+
+//Pickups suggested to a van that stops reporting are offered to another van by the next sweep
+func TestRemoveInactiveVanLocationsRedispatches(t *testing.T) {
+	ts := newTestServer(t)
+	ts.reportVan(1, "38.90", "-76.40")
+	ts.reportVan(2, "38.98", "-76.48")
+	ts.newPickup(testRider, testDevice, "38.981", "-76.481")
+
+	//van 1 keeps reporting, van 2 goes quiet
+	ts.clock.Advance(6 * time.Minute)
+	ts.reportVan(1, "38.90", "-76.40")
+	ts.clock.Advance(6 * time.Minute)
+
+	stale := ts.server.removeInactiveVanLocations(ts.server.configMinutes("vanTimeoutMinutes"))
+	ts.server.redispatchPickups(stale)
+
+	if current, _ := ts.memoryPickup(testRider); current.SuggestedVanId != 1 {
+		t.Errorf("got suggested van %v after van 2 stopped, want van 1", current.SuggestedVanId)
+	}
+}
+
+//Pickups whose rider stopped reporting are not dispatched again and take no room in van loads, routes or the queue
+func TestAbandonedPickupsAreNotDispatched(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newPickup(testRider, testDevice, "38.981", "-76.481")
+	ts.clock.Advance(ts.server.configMinutes("pickupTimeoutMinutes") + time.Second)
+	ts.server.removeInactivePickups(ts.server.configMinutes("pickupTimeoutMinutes"))
+
+	ts.newPickup(testOtherRider, testOtherDevice, "38.982", "-76.482")
+	ts.reportVan(1, "38.98", "-76.48")
+	ts.server.redispatchPickups(nil)
+
+	if current, _ := ts.memoryPickup(testRider); current.VanId != 0 || current.SuggestedVanId != 0 {
+		t.Errorf("abandoned pickup was dispatched to van %v, suggested van %v", current.VanId, current.SuggestedVanId)
+	}
+	if current, _ := ts.memoryPickup(testOtherRider); current.SuggestedVanId != 1 {
+		t.Errorf("got suggested van %v, want van 1", current.SuggestedVanId)
+	}
+
+	ts.server.pickupsLock.Lock()
+	defer ts.server.pickupsLock.Unlock()
+	if got := ts.server.pickupQueuePosition(ts.server.pickups[testOtherRider]); got != 1 {
+		t.Errorf("got queue position %v, want 1", got)
+	}
+
+	//a van keeps an en route pickup after its rider stops reporting, but it no longer counts as load
+	ts.server.pickups[testRider] = Pickup{PhoneNumber: testRider, Status: enRoute, VanId: 1}
+	if got := ts.server.vanLoads()[1]; got != 0 {
+		t.Errorf("got van load %v, want 0", got)
+	}
+	if route := ts.server.currentVanRoute(1); len(route.Stops) != 0 {
+		t.Errorf("got route stops %+v, want none", route.Stops)
+	}
+}
@@ -0,0 +1,216 @@
+package shipmate
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var requestIdPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var deviceIdPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+//A request parameter that failed validation
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//Parses and checks request parameters before a handler acts on them. Every bad parameter is collected so clients can show them all at once, and err reports them together as one invalid_parameter error.
+type validator struct {
+	fields []fieldError
+}
+
+func (v *validator) addError(field string, format string, args ...interface{}) {
+	v.fields = append(v.fields, fieldError{field, fmt.Sprintf(format, args...)})
+}
+
+//True if field already failed validation
+func (v *validator) failed(field string) bool {
+	for _, f := range v.fields {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+//Nil if every parameter was valid, otherwise a 400 invalid_parameter error listing the bad fields
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+
+	var names []string
+	for _, f := range v.fields {
+		names = append(names, f.Field)
+	}
+	e := apiErrorf(http.StatusBadRequest, invalidParameterCode, "invalid %v", strings.Join(names, ", "))
+	e.Fields = v.fields
+	return e
+}
+
+//First value of a form parameter, false if it is missing or empty
+func formValue(form url.Values, field string) (string, bool) {
+	if !doKeysExist(form, []string{field}) || areFieldsEmpty(form, []string{field}) || isFieldEmpty(form[field][0]) {
+		return "", false
+	}
+	return form[field][0], true
+}
+
+//Value of a form parameter the request cannot do without
+func (v *validator) required(form url.Values, field string) (string, bool) {
+	value, exist := formValue(form, field)
+	if !exist {
+		v.addError(field, "is required")
+	}
+	return value, exist
+}
+
+//10 digit phone number without country code or punctuation
+func (v *validator) phoneNumber(field string, value string) string {
+	if !isPhoneNumberFormatValid(value) {
+		v.addError(field, "must be a 10 digit phone number")
+	}
+	return value
+}
+
+//Device id the apps generate, a UUID
+func (v *validator) deviceId(field string, value string) string {
+	if !deviceIdPattern.MatchString(value) {
+		v.addError(field, "must be a UUID")
+	}
+	return value
+}
+
+//Id of a queued write, as returned to async requests
+func (v *validator) requestId(field string, value string) string {
+	if !requestIdPattern.MatchString(value) {
+		v.addError(field, "must be a request id")
+	}
+	return value
+}
+
+func (v *validator) number(field string, value string) (float64, bool) {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+		v.addError(field, "must be a number")
+		return 0, false
+	}
+	return parsed, true
+}
+
+//Whole number of at least 1, used for driver ids
+func (v *validator) id(field string, value string) int {
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		v.addError(field, "must be a whole number of at least 1")
+		return 0
+	}
+	return parsed
+}
+
+//Id of a van. Whether a van is registered with the id is checked against the vans table by the caller.
+func (v *validator) vanId(field string, value string) int {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		v.addError(field, "must be a whole number")
+		return 0
+	}
+	return v.checkVanId(field, parsed)
+}
+
+func (v *validator) checkVanId(field string, vanId int) int {
+	if vanId < 1 {
+		v.addError(field, "must be a van id of at least 1")
+	}
+	return vanId
+}
+
+func (v *validator) checkLatitude(field string, latitude float64) float64 {
+	if math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+		v.addError(field, "must be from -90 to 90")
+	}
+	return latitude
+}
+
+func (v *validator) checkLongitude(field string, longitude float64) float64 {
+	if math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+		v.addError(field, "must be from -180 to 180")
+	}
+	return longitude
+}
+
+//Heading in degrees from north. -1 means the heading is unknown, which is what iOS reports for a stationary device.
+func (v *validator) checkHeading(field string, heading float64) float64 {
+	if heading != -1 && (math.IsNaN(heading) || heading < 0 || heading > 360) {
+		v.addError(field, "must be from 0 to 360, or -1 if unknown")
+	}
+	return heading
+}
+
+//Required "latitude" and "longitude" form parameters
+func (v *validator) formLocation(form url.Values) Location {
+	location := Location{Heading: -1}
+	if value, exist := v.required(form, "latitude"); exist {
+		if latitude, ok := v.number("latitude", value); ok {
+			location.Latitude = v.checkLatitude("latitude", latitude)
+		}
+	}
+	if value, exist := v.required(form, "longitude"); exist {
+		if longitude, ok := v.number("longitude", value); ok {
+			location.Longitude = v.checkLongitude("longitude", longitude)
+		}
+	}
+	return location
+}
+
+//Optional "heading" form parameter, -1 if it is left out
+func (v *validator) formHeading(form url.Values) float64 {
+	value, exist := formValue(form, "heading")
+	if !exist {
+		return -1
+	}
+	if heading, ok := v.number("heading", value); ok {
+		return v.checkHeading("heading", heading)
+	}
+	return -1
+}
+
+//RFC 3339 time
+func (v *validator) time(field string, value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		v.addError(field, "must be an RFC 3339 time")
+	}
+	return parsed
+}
+
+//Pickup status name
+func (v *validator) status(field string, value string) PickupStatus {
+	status, exist := parsePickupStatus(value)
+	if !exist {
+		v.addError(field, "is not a pickup status")
+	}
+	return status
+}
+
+//Required "phoneNumber" form parameter
+func (v *validator) formPhoneNumber(form url.Values) string {
+	if value, exist := v.required(form, "phoneNumber"); exist {
+		return v.phoneNumber("phoneNumber", value)
+	}
+	return ""
+}
+
+//Required "deviceId" form parameter
+func (v *validator) formDeviceId(form url.Values) string {
+	if value, exist := v.required(form, "deviceId"); exist {
+		return v.deviceId("deviceId", value)
+	}
+	return ""
+}
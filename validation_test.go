@@ -0,0 +1,144 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestValidatorFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(v *validator)
+		want     []string //fields expected to fail
+	}{
+		{"valid phone number", func(v *validator) { v.phoneNumber("phoneNumber", "4105550101") }, nil},
+		{"phone number with punctuation", func(v *validator) { v.phoneNumber("phoneNumber", "410-555-0101") }, []string{"phoneNumber"}},
+		{"phone number with country code", func(v *validator) { v.phoneNumber("phoneNumber", "14105550101") }, []string{"phoneNumber"}},
+		{"valid device id", func(v *validator) { v.deviceId("deviceId", "9B2E5C1A-4F0D-4E8B-9C3A-7D6E5F4A3B2C") }, nil},
+		{"device id not a UUID", func(v *validator) { v.deviceId("deviceId", "device-a") }, []string{"deviceId"}},
+		{"van id", func(v *validator) { v.vanId("vanId", "12") }, nil},
+		{"van id below 1", func(v *validator) { v.vanId("vanId", "0") }, []string{"vanId"}},
+		{"van id not a number", func(v *validator) { v.vanId("vanId", "two") }, []string{"vanId"}},
+		{"latitude on the pole", func(v *validator) { v.checkLatitude("latitude", 90) }, nil},
+		{"latitude past the pole", func(v *validator) { v.checkLatitude("latitude", 90.5) }, []string{"latitude"}},
+		{"longitude past the date line", func(v *validator) { v.checkLongitude("longitude", -180.5) }, []string{"longitude"}},
+		{"unknown heading", func(v *validator) { v.checkHeading("heading", -1) }, nil},
+		{"heading past north", func(v *validator) { v.checkHeading("heading", 361) }, []string{"heading"}},
+		{"request id", func(v *validator) { v.requestId("requestId", "0123456789abcdef0123456789abcdef") }, nil},
+		{"request id too short", func(v *validator) { v.requestId("requestId", "0123456789abcdef") }, []string{"requestId"}},
+		{"every bad location field", func(v *validator) {
+			v.formLocation(url.Values{"latitude": {"north"}})
+		}, []string{"latitude", "longitude"}},
+		{"empty parameter", func(v *validator) { v.formPhoneNumber(url.Values{"phoneNumber": {""}}) }, []string{"phoneNumber"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			tt.validate(&v)
+
+			var got []string
+			for _, f := range v.fields {
+				got = append(got, f.Field)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got failed fields %v, want %v", got, tt.want)
+			}
+			if (v.err() == nil) != (len(tt.want) == 0) {
+				t.Errorf("got error %v", v.err())
+			}
+		})
+	}
+}
+
+func TestLegacyMissingParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      func(ts *testServer) string
+		path       string
+		parameters url.Values
+		wantFields []string //invalid parameters listed in the reply
+	}{
+		{"new pickup without longitude", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, "/newPickup", url.Values{"latitude": {"38.98"}}, []string{"longitude"}},
+		{"new pickup with bad latitude", func(ts *testServer) string {
+			return ts.riderToken(testRider, testDevice)
+		}, "/newPickup", url.Values{"latitude": {"north"}, "longitude": {"-76.48"}}, []string{"latitude"}},
+		{"staff pickup info without phone number", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, "/getPickupInfo", url.Values{}, []string{"phoneNumber"}},
+		{"reassign to van outside the fleet", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.staffToken(dispatcherRole, 7)
+		}, "/reassignPickup", url.Values{"phoneNumber": {testRider}, "vanId": {"9"}}, nil},
+		{"progress to a status that is not one", func(ts *testServer) string {
+			ts.newPickup(testRider, testDevice, "38.98", "-76.48")
+			return ts.driverToken(3, 2)
+		}, "/updatePickupStatus", url.Values{"phoneNumber": {testRider}, "status": {"parked"}}, []string{"status"}},
+		{"request status without id", func(ts *testServer) string {
+			return ts.driverToken(3, 2)
+		}, "/getRequestStatus", url.Values{}, []string{"requestId"}},
+		{"verification code for a device id that is not a UUID", func(ts *testServer) string {
+			return ""
+		}, "/requestVerificationCode", url.Values{"phoneNumber": {testRider}, "deviceId": {"device-a"}}, []string{"deviceId"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := tt.token(ts)
+			before, _ := ts.memoryPickup(testRider)
+
+			//old apps only read status, the fields are extra
+			body := ts.request(token, tt.path, tt.parameters)
+			reply := decodeStatus(body)
+			if reply.Status != "-1" || len(reply.Fields) != len(tt.wantFields) {
+				t.Fatalf("got %v, want status -1 with fields %v", body, tt.wantFields)
+			}
+			for i, v := range reply.Fields {
+				if v.Field != tt.wantFields[i] || v.Message == "" {
+					t.Errorf("got field %+v, want %v", v, tt.wantFields[i])
+				}
+			}
+			if current, _ := ts.memoryPickup(testRider); current.Status != before.Status || current.version != before.version || current.VanId != before.VanId {
+				t.Errorf("rejected request changed pickup from %+v to %+v", before, current)
+			}
+		})
+	}
+}
+
+//Legacy failures keep the status old apps read and list the invalid parameters next to it
+func TestLegacyFieldErrors(t *testing.T) {
+	ts := newTestServer(t)
+
+	want := `{"status":"-1","fields":[{"field":"latitude","message":"must be from -90 to 90"},{"field":"longitude","message":"is required"}]}`
+	if body := ts.request(ts.riderToken(testRider, testDevice), "/newPickup", url.Values{"latitude": {"91"}}); body != want {
+		t.Errorf("got %v, want %v", body, want)
+	}
+}
+
+func TestAPIFieldErrors(t *testing.T) {
+	ts := newTestServer(t)
+
+	status, body := ts.apiRequest(ts.driverToken(3, 2), "PUT", "/api/v1/vans/2/location", map[string]float64{"latitude": 91, "heading": 400})
+	var envelope apiErrorEnvelope
+	if status != http.StatusBadRequest || json.Unmarshal([]byte(body), &envelope) != nil || envelope.Error == nil {
+		t.Fatalf("got %v %v, want a 400 error envelope", status, body)
+	}
+
+	var got []string
+	for _, f := range envelope.Error.Fields {
+		got = append(got, f.Field)
+	}
+	if want := []string{"latitude", "longitude", "heading"}; envelope.Error.Code != invalidParameterCode || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v with fields %v, want %v with fields %v", envelope.Error.Code, got, invalidParameterCode, want)
+	}
+
+	if status, body := ts.apiRequest(ts.driverToken(3, 2), "GET", "/api/v1/pickups/410555", nil); status != http.StatusBadRequest || decodeAPIErrorCode(body) != invalidParameterCode {
+		t.Errorf("short phone number in path got %v %v, want 400 %v", status, body, invalidParameterCode)
+	}
+}
@@ -0,0 +1,368 @@
+package shipmate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Latest location of each van keyed by van id. Handlers, store changes and the inactivity sweep only change it through these methods, which hold its lock just long enough to copy locations in or out. No other lock is taken while it is held, so it may be used with or without pickupsLock.
+type vanTable struct {
+	lock      sync.RWMutex
+	locations map[int]Location
+}
+
+//Location of a van, false if the van has never reported or its location was cleared
+func (t *vanTable) get(vanId int) (Location, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	tmp, exist := t.locations[vanId]
+	return tmp, exist && tmp.latestTime != time.Time{}
+}
+
+//Copy of every van's location keyed by van id. Vans whose location was cleared have the zero Location.
+func (t *vanTable) list() map[int]Location {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	tmp := make(map[int]Location)
+	for k, v := range t.locations {
+		tmp[k] = v
+	}
+	return tmp
+}
+
+func (t *vanTable) count() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return len(t.locations)
+}
+
+//Replace a van's location and return the one it replaced
+func (t *vanTable) set(vanId int, vanLocation Location) Location {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.locations == nil {
+		t.locations = make(map[int]Location)
+	}
+	previous := t.locations[vanId]
+	t.locations[vanId] = vanLocation
+	return previous
+}
+
+//Replace every van's location with locations keyed by van id
+func (t *vanTable) replace(locations map[int]Location) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.locations = make(map[int]Location)
+	for k, v := range locations {
+		if k < 1 {
+			continue
+		}
+		t.locations[k] = v
+	}
+}
+
+//Clear locations of vans that have not reported since timeDifference before now and return the ids of those vans, lowest first
+func (t *vanTable) clearInactive(now time.Time, timeDifference time.Duration) []int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var numberOfEmptyLocations int
+	var staleVanIds []int
+
+	for k, v := range t.locations {
+		if (v.latestTime != time.Time{} && now.Sub(v.latestTime) > timeDifference) {
+			v.Latitude = 0
+			v.Longitude = 0
+			v.latestTime = time.Time{}
+			t.locations[k] = v
+			numberOfEmptyLocations++
+			staleVanIds = append(staleVanIds, k)
+
+		} else if (v.latestTime == time.Time{}) {
+			numberOfEmptyLocations++
+		}
+	}
+	sort.Ints(staleVanIds)
+
+	//if every van is empty, no vans are around so start over with an empty table
+	if numberOfEmptyLocations == len(t.locations) {
+		t.locations = make(map[int]Location)
+	}
+	return staleVanIds
+}
+
+//A van registered in the vans table. Only active vans may report locations, be dispatched pickups or be assigned to drivers.
+type Van struct {
+	Id            int      `json:"vanId"`
+	Callsign      string   `json:"callsign"` //name drivers and dispatchers call the van by, e.g. "Navy 3"
+	Capacity      int      `json:"capacity"` //most active pickups the van may hold, 0 for the vanCapacity setting
+	Active        bool     `json:"active"`
+	Plate         string   `json:"plate"`
+	Accessibility []string `json:"accessibility"` //features such as wheelchair or ramp
+}
+
+//Registered vans keyed by van id, as last loaded from the van store. Like vanTable, its lock is only held to copy vans in or out.
+type vanRegistry struct {
+	lock sync.RWMutex
+	vans map[int]Van
+}
+
+//Registered van, false if no van has the id
+func (r *vanRegistry) get(vanId int) (Van, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	tmp, exist := r.vans[vanId]
+	return tmp, exist
+}
+
+//Every registered van, lowest id first
+func (r *vanRegistry) list() []Van {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	list := make([]Van, 0, len(r.vans))
+	for _, v := range r.vans {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
+
+func (r *vanRegistry) set(targetVan Van) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.vans == nil {
+		r.vans = make(map[int]Van)
+	}
+	r.vans[targetVan.Id] = targetVan
+}
+
+func (r *vanRegistry) replace(list []Van) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.vans = make(map[int]Van)
+	for _, v := range list {
+		r.vans[v.Id] = v
+	}
+}
+
+//SELECT every registered van into memory so vans registered or changed on other instances are picked up
+func (s *Server) loadVans() {
+	list, err := s.vanStore.ListVans()
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+	s.vans.replace(list)
+}
+
+//Registered van that is in service. Vans that were never registered are unknown_van, vans taken out of service van_inactive.
+func (s *Server) activeVan(vanId int) (Van, error) {
+	tmp, exist := s.vans.get(vanId)
+	if !exist {
+		return Van{}, apiErrorf(http.StatusNotFound, unknownVanCode, "no van %v is registered", vanId)
+	}
+	if !tmp.Active {
+		return Van{}, apiErrorf(http.StatusConflict, vanInactiveCode, "van %v is not in service", vanId)
+	}
+	return tmp, nil
+}
+
+//Most active pickups a van may hold
+func (s *Server) vanCapacity(vanId int) int {
+	if tmp, exist := s.vans.get(vanId); exist && tmp.Capacity > 0 {
+		return tmp.Capacity
+	}
+	return int(s.configValue("vanCapacity"))
+}
+
+//Location of every active van that has reported, keyed by van id
+func (s *Server) activeVanLocations() map[int]Location {
+	list := make(map[int]Location)
+	for k, v := range s.vanLocations.list() {
+		if tmp, exist := s.vans.get(k); exist && tmp.Active && (v.latestTime != time.Time{}) {
+			list[k] = v
+		}
+	}
+	return list
+}
+
+//Apply the optional "callsign", "capacity", "active", "plate" and "accessibility" parameters to a van. Accessibility features are separated by commas, an empty list is sent as "none".
+func applyVanParameters(targetVan *Van, targetDictionary url.Values) error {
+	var v validator
+	tmpVan := *targetVan
+
+	if value, exist := formValue(targetDictionary, "callsign"); exist {
+		if len(value) > 32 {
+			v.addError("callsign", "must be at most 32 characters")
+		}
+		tmpVan.Callsign = value
+	}
+
+	if value, exist := formValue(targetDictionary, "capacity"); exist {
+		if capacity, err := strconv.Atoi(value); err != nil || capacity < 0 {
+			v.addError("capacity", "must be a whole number, 0 for the vanCapacity setting")
+		} else {
+			tmpVan.Capacity = capacity
+		}
+	}
+
+	if value, exist := formValue(targetDictionary, "active"); exist {
+		if active, err := strconv.ParseBool(value); err != nil {
+			v.addError("active", "must be true or false")
+		} else {
+			tmpVan.Active = active
+		}
+	}
+
+	if value, exist := formValue(targetDictionary, "plate"); exist {
+		if len(value) > 16 {
+			v.addError("plate", "must be at most 16 characters")
+		}
+		tmpVan.Plate = value
+	}
+
+	if value, exist := formValue(targetDictionary, "accessibility"); exist {
+		tmpVan.Accessibility = make([]string, 0)
+		if value != "none" {
+			for _, feature := range strings.Split(value, ",") {
+				if feature = strings.TrimSpace(feature); feature != "" {
+					tmpVan.Accessibility = append(tmpVan.Accessibility, feature)
+				}
+			}
+		}
+	}
+
+	if tmpVan.Callsign == "" {
+		v.addError("callsign", "is required")
+	}
+
+	if err := v.err(); err != nil {
+		return err
+	}
+	*targetVan = tmpVan
+	return nil
+}
+
+//Register a van on every instance
+func (s *Server) createVanFrom(targetDictionary url.Values) (Van, error) {
+	var v validator
+	var tmpVan Van
+	if value, exist := v.required(targetDictionary, "vanId"); exist {
+		tmpVan.Id = v.vanId("vanId", value)
+	}
+	if err := v.err(); err != nil {
+		return Van{}, err
+	}
+
+	tmpVan.Active = true
+	tmpVan.Accessibility = make([]string, 0)
+	if err := applyVanParameters(&tmpVan, targetDictionary); err != nil {
+		return Van{}, err
+	}
+
+	if err := s.vanStore.CreateVan(tmpVan); err == errVanExists {
+		return Van{}, apiErrorf(http.StatusConflict, vanExistsCode, "van %v is already registered", tmpVan.Id)
+	} else if err != nil {
+		return Van{}, apiErrorf(http.StatusServiceUnavailable, storeUnavailableCode, "%v", err)
+	}
+	s.vans.set(tmpVan)
+	return tmpVan, nil
+}
+
+//Change a registered van on every instance. Vans taken out of service leave the board and their pending pickups are dispatched to other vans.
+func (s *Server) updateVanFrom(targetDictionary url.Values) (Van, error) {
+	var v validator
+	var vanId int
+	if value, exist := v.required(targetDictionary, "vanId"); exist {
+		vanId = v.vanId("vanId", value)
+	}
+	if err := v.err(); err != nil {
+		return Van{}, err
+	}
+
+	//start from the van as it is in the store, it may have been changed on another instance since the last sweep
+	s.loadVans()
+	tmpVan, exist := s.vans.get(vanId)
+	if !exist {
+		return Van{}, apiErrorf(http.StatusNotFound, unknownVanCode, "no van %v is registered", vanId)
+	}
+	wasActive := tmpVan.Active
+	if err := applyVanParameters(&tmpVan, targetDictionary); err != nil {
+		return Van{}, err
+	}
+
+	if err := s.vanStore.UpdateVan(tmpVan); err == errNoSuchVan {
+		return Van{}, apiErrorf(http.StatusNotFound, unknownVanCode, "no van %v is registered", vanId)
+	} else if err != nil {
+		return Van{}, apiErrorf(http.StatusServiceUnavailable, storeUnavailableCode, "%v", err)
+	}
+	s.vans.set(tmpVan)
+
+	if wasActive && !tmpVan.Active {
+		s.publishBoardVansRemoved([]int{vanId})
+		s.redispatchPickups([]int{vanId})
+	}
+	return tmpVan, nil
+}
+
+func (s *Server) listVans(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("listVans()")
+
+	if output, err := json.Marshal(s.vans.list()); err == nil {
+		fmt.Fprint(w, string(output))
+	} else {
+		s.logger.Println(err)
+	}
+}
+
+func (s *Server) createVan(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("createVan()")
+
+	//parse http parameters
+	r.ParseForm()
+
+	tmpVan, err := s.createVanFrom(r.Form)
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	s.logger.Printf("Van %v registered by driver %v\n", tmpVan.Id, session.DriverId)
+	fmt.Fprint(w, successResponse)
+}
+
+func (s *Server) updateVan(w http.ResponseWriter, r *http.Request, session Session) {
+	s.logger.Println("updateVan()")
+
+	//parse http parameters
+	r.ParseForm()
+
+	tmpVan, err := s.updateVanFrom(r.Form)
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	s.logger.Printf("Van %v updated by driver %v\n", tmpVan.Id, session.DriverId)
+	fmt.Fprint(w, successResponse)
+}
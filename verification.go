@@ -0,0 +1,234 @@
+package shipmate
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+const verificationCodeLifetime = time.Duration(10) * time.Minute
+const verificationCodeResendDelay = time.Duration(30) * time.Second
+const verificationCodeMaxAttempts = 5
+
+//Pending or completed verification of a phone number on a device
+type phoneVerification struct {
+	codeHash     string
+	sentTime     time.Time
+	expireTime   time.Time
+	attempts     int
+	verifiedTime pq.NullTime
+}
+
+func isPhoneNumberFormatValid(number string) bool {
+	if len(number) != 10 {
+		return false
+	}
+	for _, v := range number {
+		if v < '0' || v > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//Random 6 digit code
+func generateVerificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+//Codes are stored hashed together with the phone number and device they were sent for
+func verificationCodeHash(number string, deviceId string, code string) string {
+	return sha256Hex(number + ":" + deviceId + ":" + code)
+}
+
+//SELECT verification row for a phone number and device. Return false if no code was ever requested.
+func (s *Server) selectPhoneVerification(number string, deviceId string) (phoneVerification, bool) {
+	var tmp phoneVerification
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return tmp, false
+	}
+
+	if err := s.db.QueryRow(`SELECT CodeHash, SentTime, ExpireTime, Attempts, VerifiedTime
+		FROM phoneverifications
+		WHERE PhoneNumber = $1 AND DeviceId = $2;`, number, deviceId).Scan(&tmp.codeHash, &tmp.sentTime, &tmp.expireTime, &tmp.attempts, &tmp.verifiedTime); err != nil {
+		if err != sql.ErrNoRows {
+			s.logger.Println(err)
+		}
+		return tmp, false
+	}
+	return tmp, true
+}
+
+//INSERT or UPDATE verification row with a newly sent code
+func (s *Server) databaseUpsertVerificationCode(number string, deviceId string, codeHash string) bool {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		now := time.Now()
+		if _, err := s.db.Exec(`INSERT INTO phoneverifications (PhoneNumber, DeviceId, CodeHash, SentTime, ExpireTime, Attempts)
+			VALUES ($1, $2, $3, $4, $5, 0)
+			ON CONFLICT (PhoneNumber, DeviceId) DO UPDATE SET CodeHash = $3, SentTime = $4, ExpireTime = $5, Attempts = 0;`, number, deviceId, codeHash, now, now.Add(verificationCodeLifetime)); err != nil {
+			s.logger.Println(err)
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+//UPDATE failed attempt counter of a verification row
+func (s *Server) databaseIncrementVerificationAttempts(number string, deviceId string) {
+	if checkDatabaseHandleValid(s.db, s.logger) {
+		if _, err := s.db.Exec(`UPDATE phoneverifications
+			SET Attempts = Attempts + 1
+			WHERE PhoneNumber = $1 AND DeviceId = $2;`, number, deviceId); err != nil {
+			s.logger.Println(err)
+		}
+	}
+}
+
+//Mark the device verified for the phone number. Any other device bound to the number is removed and its rider sessions revoked.
+func (s *Server) databaseBindVerifiedDevice(number string, deviceId string) bool {
+	if !checkDatabaseHandleValid(s.db, s.logger) {
+		return false
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.Println(err)
+		return false
+	}
+
+	if _, err := tx.Exec(`UPDATE phoneverifications
+		SET VerifiedTime = $3, CodeHash = ''
+		WHERE PhoneNumber = $1 AND DeviceId = $2;`, number, deviceId, time.Now()); err != nil {
+		s.logger.Println(err)
+		tx.Rollback()
+		return false
+	}
+
+	if _, err := tx.Exec("DELETE FROM phoneverifications WHERE PhoneNumber = $1 AND DeviceId <> $2;", number, deviceId); err != nil {
+		s.logger.Println(err)
+		tx.Rollback()
+		return false
+	}
+
+	if _, err := tx.Exec("UPDATE sessions SET Revoked = TRUE WHERE Role = $1 AND PhoneNumber = $2 AND DeviceId <> $3;", riderRole, number, deviceId); err != nil {
+		s.logger.Println(err)
+		tx.Rollback()
+		return false
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Println(err)
+		return false
+	}
+	return true
+}
+
+//Check the phone number has been verified on this device
+func (s *Server) isPhoneNumberVerified(number string, deviceId string) bool {
+	verified, err := s.sessionStore.IsPhoneNumberVerified(number, deviceId)
+	if err != nil {
+		s.logger.Println(err)
+	}
+	return verified
+}
+
+//Text a verification code to the phone number for the device to confirm at /registerRider
+func (s *Server) requestVerificationCode(w http.ResponseWriter, r *http.Request) {
+	s.logger.Println("requestVerificationCode()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	number := v.formPhoneNumber(r.Form)
+	deviceId := v.formDeviceId(r.Form)
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	//limit how often a phone can be texted
+	if existing, exist := s.selectPhoneVerification(number, deviceId); exist && time.Since(existing.sentTime) < verificationCodeResendDelay {
+		s.logger.Println("Verification code for", number, "requested again too soon")
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	code, err := generateVerificationCode()
+	if err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	if !s.databaseUpsertVerificationCode(number, deviceId, verificationCodeHash(number, deviceId, code)) {
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	if err := s.smsSender.SendSMS(number, "Your Shipmate verification code is "+code); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	fmt.Fprint(w, successResponse)
+}
+
+//Confirm the texted code, bind the phone number to the device and start a rider session
+func (s *Server) registerRider(w http.ResponseWriter, r *http.Request) {
+	s.logger.Println("registerRider()")
+
+	//bypass same origin policy
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//parse http parameters
+	r.ParseForm()
+
+	var v validator
+	number := v.formPhoneNumber(r.Form)
+	deviceId := v.formDeviceId(r.Form)
+	code, _ := v.required(r.Form, "code")
+	if err := v.err(); err != nil {
+		s.logger.Println(err)
+		fmt.Fprint(w, legacyResponse(err))
+		return
+	}
+
+	verification, exist := s.selectPhoneVerification(number, deviceId)
+	if !exist || isFieldEmpty(verification.codeHash) || time.Now().After(verification.expireTime) || verification.attempts >= verificationCodeMaxAttempts {
+		fmt.Fprint(w, wrongPasswordResponse)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(verification.codeHash), []byte(verificationCodeHash(number, deviceId, code))) != 1 {
+		s.databaseIncrementVerificationAttempts(number, deviceId)
+		fmt.Fprint(w, wrongPasswordResponse)
+		return
+	}
+
+	if !s.databaseBindVerifiedDevice(number, deviceId) {
+		fmt.Fprint(w, failResponse)
+		return
+	}
+
+	if tokens, ok := s.issueSessionTokens(Session{Role: riderRole, PhoneNumber: number, DeviceId: deviceId}); ok {
+		s.writeSessionTokens(w, tokens)
+	} else {
+		fmt.Fprint(w, failResponse)
+	}
+}